@@ -0,0 +1,401 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/robfig/cron/v3"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	defaultPort             = "8080"
+	defaultShutdownTimeout  = 30
+	defaultWebhookWorkers   = 4
+	defaultWebhookQueueSize = 100
+	minAdminTokenLength     = 16
+	defaultLogLevel         = "info"
+	defaultLogFormat        = LogFormatJSON
+	defaultTracingExporter  = TracingExporterNone
+	defaultTracingService   = "sapo"
+	defaultWeeklySchedule   = "CRON_TZ=Asia/Bangkok 0 9 * * 1"
+	defaultMonthlySchedule  = "CRON_TZ=Asia/Bangkok 0 9 1 * *"
+	defaultIngestSchedule   = "*/30 * * * *"
+)
+
+// the log formats and tracing exporters mirror logger.Format* and tracing.Exporter*,
+// config_test.go pins them equal so config does not have to import either package
+const (
+	LogFormatJSON = "json"
+	LogFormatText = "text"
+
+	TracingExporterNone   = "none"
+	TracingExporterStdout = "stdout"
+	TracingExporterOTLP   = "otlp"
+)
+
+type Config struct {
+	App       App       `yaml:"app"`
+	Log       Log       `yaml:"log"`
+	Tracing   Tracing   `yaml:"tracing"`
+	Scheduler Scheduler `yaml:"scheduler"`
+	Mongo     Mongo     `yaml:"mongo"`
+	LINE      LINE      `yaml:"line"`
+	Spotify   Spotify   `yaml:"spotify"`
+}
+
+type App struct {
+	Port                 string   `yaml:"port" env:"APP_PORT"`
+	BasedURL             string   `yaml:"basedUrl" env:"BASED_URL_APP"`
+	CORSAllowOrigins     []string `yaml:"corsAllowOrigins" env:"CORS_ALLOW_ORIGIN"`
+	LIFFLoginURL         string   `yaml:"liffLoginUrl" env:"LIFF_LOGIN_URL"`
+	LIFFLoginCallbackURL string   `yaml:"liffLoginCallbackUrl" env:"LIFF_LOGIN_CALLBACK_URL"`
+	ShutdownTimeout      int      `yaml:"shutdownTimeout" env:"SHUTDOWN_TIMEOUT"`
+	WebhookWorkers       int      `yaml:"webhookWorkers" env:"WEBHOOK_WORKERS"`
+	WebhookQueueSize     int      `yaml:"webhookQueueSize" env:"WEBHOOK_QUEUE_SIZE"`
+	AdminToken           string   `yaml:"adminToken" env:"ADMIN_TOKEN"`
+}
+
+type Log struct {
+	Level  string `yaml:"level" env:"LOG_LEVEL"`
+	Format string `yaml:"format" env:"LOG_FORMAT"`
+}
+
+type Tracing struct {
+	// Exporter is none, stdout or otlp, spans are only recorded when it is not none
+	Exporter     string `yaml:"exporter" env:"TRACING_EXPORTER"`
+	ServiceName  string `yaml:"serviceName" env:"TRACING_SERVICE_NAME"`
+	OTLPEndpoint string `yaml:"otlpEndpoint" env:"TRACING_OTLP_ENDPOINT"`
+	OTLPInsecure bool   `yaml:"otlpInsecure" env:"TRACING_OTLP_INSECURE"`
+}
+
+type Scheduler struct {
+	// Enabled runs due jobs on this replica, replicas still elect a single leader among the enabled ones
+	Enabled bool `yaml:"enabled" env:"SCHEDULER_ENABLED"`
+	// WeeklyPlaylist is the cron spec the weekly playlist is pushed on, a CRON_TZ= prefix sets its time zone
+	WeeklyPlaylist string `yaml:"weeklyPlaylist" env:"SCHEDULE_WEEKLY_PLAYLIST"`
+	// MonthlyRecap should run on the 1st, the recap covers the month of the day before it runs
+	MonthlyRecap string `yaml:"monthlyRecap" env:"SCHEDULE_MONTHLY_RECAP"`
+	// IngestPlays has to run before a user can play more tracks than spotify remembers, which is 50
+	IngestPlays string `yaml:"ingestPlays" env:"SCHEDULE_INGEST_PLAYS"`
+}
+
+type Mongo struct {
+	AuthSource string `yaml:"authSource" env:"MONGO_AUTH_SOURCE"`
+	Database   string `yaml:"database" env:"MONGO_DATABASE"`
+	Host       string `yaml:"host" env:"MONGO_HOST"`
+	Username   string `yaml:"username" env:"MONGO_USERNAME"`
+	Password   string `yaml:"password" env:"MONGO_PASSWORD"`
+}
+
+type LINE struct {
+	ChannelSecret    string `yaml:"channelSecret" env:"CHANNEL_SECRET"`
+	ChannelToken     string `yaml:"channelToken" env:"CHANNEL_TOKEN"`
+	LoginChannelID   string `yaml:"loginChannelId" env:"LINE_LOGIN_CHANNEL_ID"`
+	IDTokenVerifyURL string `yaml:"idTokenVerifyUrl" env:"LINE_ID_TOKEN_VERIFY_URL"`
+	RichMenuLogin    string `yaml:"richMenuLogin" env:"RICH_MENU_LOGIN"`
+	RichMenuDefault  string `yaml:"richMenuDefault" env:"RICH_MENU_DEFAULT"`
+	// RichMenuStateFile is written by the richmenu command, when set the rich menu ids are read from it
+	RichMenuStateFile string `yaml:"richMenuStateFile" env:"RICH_MENU_STATE_FILE"`
+}
+
+type Spotify struct {
+	ClientID     string `yaml:"clientId" env:"MY_CLIENT_ID"`
+	ClientSecret string `yaml:"clientSecret" env:"MY_CLIENT_SECRET"`
+	PKCE         bool   `yaml:"pkce" env:"SPOTIFY_PKCE"`
+}
+
+// ValidationError reports every invalid field of a config at once
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid config: %s", strings.Join(e.Problems, "; "))
+}
+
+func Default() *Config {
+	return &Config{
+		App: App{
+			Port:             defaultPort,
+			ShutdownTimeout:  defaultShutdownTimeout,
+			WebhookWorkers:   defaultWebhookWorkers,
+			WebhookQueueSize: defaultWebhookQueueSize,
+		},
+		Log: Log{
+			Level:  defaultLogLevel,
+			Format: defaultLogFormat,
+		},
+		Tracing: Tracing{
+			Exporter:    defaultTracingExporter,
+			ServiceName: defaultTracingService,
+		},
+		Scheduler: Scheduler{
+			Enabled:        true,
+			WeeklyPlaylist: defaultWeeklySchedule,
+			MonthlyRecap:   defaultMonthlySchedule,
+			IngestPlays:    defaultIngestSchedule,
+		},
+	}
+}
+
+// Load reads the config like Read and validates the result.
+func Load(path string) (*Config, error) {
+	cfg, err := Read(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// Read reads the optional YAML or .env file at path and overrides it with environment variables, without validating.
+func Read(path string) (*Config, error) {
+	cfg := Default()
+
+	if path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return nil, errors.Wrapf(err, "[Read]: unable to load config file %s", path)
+		}
+	}
+
+	if err := applyEnv(cfg, os.LookupEnv); err != nil {
+		return nil, errors.Wrap(err, "[Read]: unable to read environment variables")
+	}
+
+	return cfg, nil
+}
+
+func (c *Config) loadFile(path string) error {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return errors.Wrap(err, "[loadFile]: unable to read file")
+	}
+
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		if err := yaml.UnmarshalStrict(content, c); err != nil {
+			return errors.Wrap(err, "[loadFile]: unable to unmarshal yaml")
+		}
+	default:
+		values, err := parseDotEnv(string(content))
+		if err != nil {
+			return errors.Wrap(err, "[loadFile]: unable to parse env file")
+		}
+
+		lookup := func(key string) (string, bool) {
+			value, ok := values[key]
+			return value, ok
+		}
+		if err := applyEnv(c, lookup); err != nil {
+			return errors.Wrap(err, "[loadFile]: unable to apply env file")
+		}
+	}
+
+	return nil
+}
+
+func (c *Config) Validate() error {
+	problems := []string{}
+	required := func(name, value string) {
+		if value == "" {
+			problems = append(problems, fmt.Sprintf("%s is required", name))
+		}
+	}
+	validURL := func(name, value string) {
+		if value == "" {
+			return
+		}
+		u, err := url.Parse(value)
+		if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			problems = append(problems, fmt.Sprintf("%s must be an absolute http(s) url, got %q", name, value))
+		}
+	}
+
+	required("APP_PORT", c.App.Port)
+	if _, err := strconv.Atoi(c.App.Port); c.App.Port != "" && err != nil {
+		problems = append(problems, fmt.Sprintf("APP_PORT must be a number, got %q", c.App.Port))
+	}
+	required("BASED_URL_APP", c.App.BasedURL)
+	validURL("BASED_URL_APP", c.App.BasedURL)
+	required("LIFF_LOGIN_URL", c.App.LIFFLoginURL)
+	validURL("LIFF_LOGIN_URL", c.App.LIFFLoginURL)
+	required("LIFF_LOGIN_CALLBACK_URL", c.App.LIFFLoginCallbackURL)
+	validURL("LIFF_LOGIN_CALLBACK_URL", c.App.LIFFLoginCallbackURL)
+	positive := func(name string, value int) {
+		if value <= 0 {
+			problems = append(problems, fmt.Sprintf("%s must be greater than zero, got %d", name, value))
+		}
+	}
+	positive("SHUTDOWN_TIMEOUT", c.App.ShutdownTimeout)
+	positive("WEBHOOK_WORKERS", c.App.WebhookWorkers)
+	positive("WEBHOOK_QUEUE_SIZE", c.App.WebhookQueueSize)
+	if c.App.AdminToken != "" && len(c.App.AdminToken) < minAdminTokenLength {
+		problems = append(problems, fmt.Sprintf("ADMIN_TOKEN must be at least %d characters", minAdminTokenLength))
+	}
+	for _, origin := range c.App.CORSAllowOrigins {
+		if origin != "*" {
+			validURL("CORS_ALLOW_ORIGIN", origin)
+		}
+	}
+
+	if _, err := logrus.ParseLevel(c.Log.Level); err != nil {
+		problems = append(problems, fmt.Sprintf("LOG_LEVEL must be one of panic, fatal, error, warn, info, debug or trace, got %q", c.Log.Level))
+	}
+	if c.Log.Format != LogFormatJSON && c.Log.Format != LogFormatText {
+		problems = append(problems, fmt.Sprintf("LOG_FORMAT must be json or text, got %q", c.Log.Format))
+	}
+
+	switch c.Tracing.Exporter {
+	case TracingExporterNone, TracingExporterStdout:
+	case TracingExporterOTLP:
+		required("TRACING_SERVICE_NAME", c.Tracing.ServiceName)
+	default:
+		problems = append(problems, fmt.Sprintf("TRACING_EXPORTER must be none, stdout or otlp, got %q", c.Tracing.Exporter))
+	}
+
+	schedule := func(name, spec string) {
+		if _, err := cron.ParseStandard(spec); err != nil {
+			problems = append(problems, fmt.Sprintf("%s must be a cron spec, got %q: %v", name, spec, err))
+		}
+	}
+	schedule("SCHEDULE_WEEKLY_PLAYLIST", c.Scheduler.WeeklyPlaylist)
+	schedule("SCHEDULE_MONTHLY_RECAP", c.Scheduler.MonthlyRecap)
+	schedule("SCHEDULE_INGEST_PLAYS", c.Scheduler.IngestPlays)
+
+	required("MONGO_HOST", c.Mongo.Host)
+	required("MONGO_DATABASE", c.Mongo.Database)
+
+	required("CHANNEL_SECRET", c.LINE.ChannelSecret)
+	required("CHANNEL_TOKEN", c.LINE.ChannelToken)
+	required("LINE_LOGIN_CHANNEL_ID", c.LINE.LoginChannelID)
+	validURL("LINE_ID_TOKEN_VERIFY_URL", c.LINE.IDTokenVerifyURL)
+	if c.LINE.RichMenuStateFile == "" {
+		required("RICH_MENU_LOGIN", c.LINE.RichMenuLogin)
+		required("RICH_MENU_DEFAULT", c.LINE.RichMenuDefault)
+	}
+
+	required("MY_CLIENT_ID", c.Spotify.ClientID)
+	required("MY_CLIENT_SECRET", c.Spotify.ClientSecret)
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+
+	return nil
+}
+
+// applyEnv walks the config and sets every field tagged with env from lookup,
+// values that do not parse are all reported in one ValidationError
+func applyEnv(v interface{}, lookup func(string) (string, bool)) error {
+	problems := []string{}
+	if err := applyEnvValue(reflect.ValueOf(v).Elem(), lookup, &problems); err != nil {
+		return err
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+
+	return nil
+}
+
+func applyEnvValue(v reflect.Value, lookup func(string) (string, bool), problems *[]string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := v.Field(i)
+		if field.Kind() == reflect.Struct {
+			if err := applyEnvValue(field, lookup, problems); err != nil {
+				return err
+			}
+			continue
+		}
+
+		key := t.Field(i).Tag.Get("env")
+		if key == "" {
+			continue
+		}
+		value, ok := lookup(key)
+		if !ok {
+			continue
+		}
+
+		switch field.Kind() {
+		case reflect.String:
+			field.SetString(value)
+		case reflect.Int:
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				*problems = append(*problems, fmt.Sprintf("%s must be a number, got %q", key, value))
+				continue
+			}
+			field.SetInt(int64(n))
+		case reflect.Bool:
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				*problems = append(*problems, fmt.Sprintf("%s must be a boolean, got %q", key, value))
+				continue
+			}
+			field.SetBool(b)
+		case reflect.Slice:
+			items := []string{}
+			for _, item := range strings.Split(value, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					items = append(items, item)
+				}
+			}
+			field.Set(reflect.ValueOf(items))
+		default:
+			return errors.Errorf("[applyEnv]: unsupported type %s for %s", field.Kind(), key)
+		}
+	}
+
+	return nil
+}
+
+// parseDotEnv reads KEY=VALUE lines, ignoring blank lines and comments and trimming optional quotes
+func parseDotEnv(content string) (map[string]string, error) {
+	values := map[string]string{}
+
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		text = strings.TrimPrefix(text, "export ")
+
+		parts := strings.SplitN(text, "=", 2)
+		if len(parts) != 2 {
+			return nil, errors.Errorf("[parseDotEnv]: line %d is not in KEY=VALUE format", line)
+		}
+
+		key := strings.TrimSpace(parts[0])
+		value := strings.TrimSpace(parts[1])
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		values[key] = value
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "[parseDotEnv]: unable to scan content")
+	}
+
+	return values, nil
+}
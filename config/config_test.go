@@ -0,0 +1,267 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bbkbbbk/sapo/pkg/logger"
+	"github.com/bbkbbbk/sapo/pkg/tracing"
+)
+
+func TestConstantsMatchPackages(t *testing.T) {
+	tests := []struct {
+		name string
+		got  string
+		want string
+	}{
+		{"json log format", LogFormatJSON, logger.FormatJSON},
+		{"text log format", LogFormatText, logger.FormatText},
+		{"no tracing exporter", TracingExporterNone, tracing.ExporterNone},
+		{"stdout tracing exporter", TracingExporterStdout, tracing.ExporterStdout},
+		{"otlp tracing exporter", TracingExporterOTLP, tracing.ExporterOTLP},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.got != tt.want {
+				t.Errorf("got %q, want %q", tt.got, tt.want)
+			}
+		})
+	}
+}
+
+func validConfig() *Config {
+	cfg := Default()
+	cfg.App.BasedURL = "https://sapo.example.com"
+	cfg.App.LIFFLoginURL = "https://liff.line.me/1234"
+	cfg.App.LIFFLoginCallbackURL = "https://sapo.example.com/callback"
+	cfg.Mongo.Host = "localhost"
+	cfg.Mongo.Database = "sapo"
+	cfg.LINE.ChannelSecret = "secret"
+	cfg.LINE.ChannelToken = "token"
+	cfg.LINE.LoginChannelID = "1234"
+	cfg.LINE.RichMenuLogin = "richmenu-login"
+	cfg.LINE.RichMenuDefault = "richmenu-default"
+	cfg.Spotify.ClientID = "client"
+	cfg.Spotify.ClientSecret = "client-secret"
+
+	return cfg
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name         string
+		modify       func(c *Config)
+		wantProblems []string
+	}{
+		{"valid", func(c *Config) {}, nil},
+		{
+			"missing required fields are all reported",
+			func(c *Config) {
+				c.Mongo.Host = ""
+				c.Spotify.ClientID = ""
+			},
+			[]string{"MONGO_HOST is required", "MY_CLIENT_ID is required"},
+		},
+		{
+			"relative url",
+			func(c *Config) { c.App.BasedURL = "/sapo" },
+			[]string{`BASED_URL_APP must be an absolute http(s) url, got "/sapo"`},
+		},
+		{
+			"port that is not a number",
+			func(c *Config) { c.App.Port = "http" },
+			[]string{`APP_PORT must be a number, got "http"`},
+		},
+		{
+			"workers must be positive",
+			func(c *Config) { c.App.WebhookWorkers = 0 },
+			[]string{"WEBHOOK_WORKERS must be greater than zero, got 0"},
+		},
+		{
+			"short admin token",
+			func(c *Config) { c.App.AdminToken = "short" },
+			[]string{"ADMIN_TOKEN must be at least 16 characters"},
+		},
+		{
+			"any cors origin",
+			func(c *Config) { c.App.CORSAllowOrigins = []string{"*", "https://sapo.example.com"} },
+			nil,
+		},
+		{
+			"unknown log format",
+			func(c *Config) { c.Log.Format = "xml" },
+			[]string{`LOG_FORMAT must be json or text, got "xml"`},
+		},
+		{
+			"otlp needs a service name",
+			func(c *Config) {
+				c.Tracing.Exporter = TracingExporterOTLP
+				c.Tracing.ServiceName = ""
+			},
+			[]string{"TRACING_SERVICE_NAME is required"},
+		},
+		{
+			"rich menu state file replaces the rich menu ids",
+			func(c *Config) {
+				c.LINE.RichMenuLogin = ""
+				c.LINE.RichMenuDefault = ""
+				c.LINE.RichMenuStateFile = "richmenu.state.json"
+			},
+			nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := validConfig()
+			tt.modify(cfg)
+
+			err := cfg.Validate()
+			if tt.wantProblems == nil {
+				if err != nil {
+					t.Errorf("Validate() = %v, want nil", err)
+				}
+				return
+			}
+			verr, ok := err.(*ValidationError)
+			if !ok {
+				t.Fatalf("Validate() = %v, want a *ValidationError", err)
+			}
+			if !reflect.DeepEqual(verr.Problems, tt.wantProblems) {
+				t.Errorf("Validate() problems = %q, want %q", verr.Problems, tt.wantProblems)
+			}
+		})
+	}
+}
+
+func TestValidateSchedule(t *testing.T) {
+	cfg := validConfig()
+	cfg.Scheduler.IngestPlays = "every half hour"
+
+	err := cfg.Validate()
+	if err == nil || !strings.Contains(err.Error(), "SCHEDULE_INGEST_PLAYS must be a cron spec") {
+		t.Errorf("Validate() = %v, want an invalid SCHEDULE_INGEST_PLAYS", err)
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	tests := []struct {
+		name         string
+		env          map[string]string
+		want         func(c *Config)
+		wantProblems []string
+	}{
+		{"nothing set keeps the defaults", map[string]string{}, func(c *Config) {}, nil},
+		{
+			"every kind of field",
+			map[string]string{
+				"APP_PORT":          "9090",
+				"WEBHOOK_WORKERS":   "8",
+				"SCHEDULER_ENABLED": "false",
+				"CORS_ALLOW_ORIGIN": " https://a.example.com, ,https://b.example.com",
+			},
+			func(c *Config) {
+				c.App.Port = "9090"
+				c.App.WebhookWorkers = 8
+				c.Scheduler.Enabled = false
+				c.App.CORSAllowOrigins = []string{"https://a.example.com", "https://b.example.com"}
+			},
+			nil,
+		},
+		{
+			"values that do not parse are all reported",
+			map[string]string{
+				"WEBHOOK_WORKERS":       "four",
+				"SPOTIFY_PKCE":          "maybe",
+				"WEBHOOK_QUEUE_SIZE":    "50",
+				"TRACING_OTLP_INSECURE": "yes",
+			},
+			func(c *Config) { c.App.WebhookQueueSize = 50 },
+			[]string{
+				`WEBHOOK_WORKERS must be a number, got "four"`,
+				`TRACING_OTLP_INSECURE must be a boolean, got "yes"`,
+				`SPOTIFY_PKCE must be a boolean, got "maybe"`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lookup := func(key string) (string, bool) {
+				value, ok := tt.env[key]
+				return value, ok
+			}
+			got := Default()
+			want := Default()
+			tt.want(want)
+
+			err := applyEnv(got, lookup)
+			if tt.wantProblems == nil && err != nil {
+				t.Fatalf("applyEnv() = %v, want nil", err)
+			}
+			if tt.wantProblems != nil {
+				verr, ok := err.(*ValidationError)
+				if !ok {
+					t.Fatalf("applyEnv() = %v, want a *ValidationError", err)
+				}
+				if !reflect.DeepEqual(verr.Problems, tt.wantProblems) {
+					t.Errorf("applyEnv() problems = %q, want %q", verr.Problems, tt.wantProblems)
+				}
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("applyEnv() = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestParseDotEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    map[string]string
+		wantErr bool
+	}{
+		{"empty", "", map[string]string{}, false},
+		{
+			"comments and blank lines are skipped",
+			"# mongo\n\nMONGO_HOST=localhost\n  # indented comment\n",
+			map[string]string{"MONGO_HOST": "localhost"},
+			false,
+		},
+		{
+			"export prefix and spaces around the equals sign",
+			"export APP_PORT = 8080\n",
+			map[string]string{"APP_PORT": "8080"},
+			false,
+		},
+		{
+			"matching quotes are trimmed",
+			"A=\"quoted value\"\nB='single'\nC=\"unmatched'\n",
+			map[string]string{"A": "quoted value", "B": "single", "C": "\"unmatched'"},
+			false,
+		},
+		{
+			"values keep later equals signs",
+			"BASED_URL_APP=https://sapo.example.com/?a=b\n",
+			map[string]string{"BASED_URL_APP": "https://sapo.example.com/?a=b"},
+			false,
+		},
+		{"empty value", "ADMIN_TOKEN=\n", map[string]string{"ADMIN_TOKEN": ""}, false},
+		{"line without an equals sign", "MONGO_HOST=localhost\nMONGO_DATABASE\n", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseDotEnv(tt.content)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseDotEnv() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseDotEnv() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
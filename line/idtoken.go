@@ -0,0 +1,144 @@
+package line
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/bbkbbbk/sapo/pkg/logger"
+)
+
+const (
+	defaultIDTokenVerifyURL = "https://api.line.me/oauth2/v2.1/verify"
+	idTokenIssuer           = "https://access.line.me"
+)
+
+var (
+	errorInvalidIDToken = errors.New("invalid LINE id token")
+)
+
+// IDTokenVerifier validates ID tokens issued to the LIFF app and returns the claims they carry
+type IDTokenVerifier interface {
+	Verify(ctx context.Context, idToken string) (*IDTokenClaims, error)
+}
+
+type IDTokenClaims struct {
+	Issuer    string `json:"iss"`
+	Subject   string `json:"sub"`
+	Audience  string `json:"aud"`
+	ExpiresAt int64  `json:"exp"`
+	IssuedAt  int64  `json:"iat"`
+	Name      string `json:"name"`
+	Picture   string `json:"picture"`
+}
+
+// UserID returns the LINE user id the token was issued for
+func (c *IDTokenClaims) UserID() string {
+	return c.Subject
+}
+
+type idTokenVerifier struct {
+	channelID  string
+	verifyURL  string
+	httpClient *http.Client
+}
+
+type responseVerifyError struct {
+	Error       string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+// NewIDTokenVerifier creates a verifier for the LINE Login channel the LIFF app belongs to.
+// verifyURL defaults to the LINE verify endpoint and can point to a local stub during development.
+func NewIDTokenVerifier(channelID, verifyURL string) IDTokenVerifier {
+	if verifyURL == "" {
+		verifyURL = defaultIDTokenVerifyURL
+	}
+
+	return &idTokenVerifier{
+		channelID:  channelID,
+		verifyURL:  verifyURL,
+		httpClient: newHTTPClient(),
+	}
+}
+
+func (v *idTokenVerifier) Verify(ctx context.Context, idToken string) (*IDTokenClaims, error) {
+	if idToken == "" {
+		return nil, errorInvalidIDToken
+	}
+
+	form := url.Values{}
+	form.Add("id_token", idToken)
+	form.Add("client_id", v.channelID)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, v.verifyURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, errors.Wrap(err, "[Verify]: unable to create request")
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Add("Content-Length", strconv.Itoa(len(form.Encode())))
+
+	res, err := v.httpClient.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "[Verify]: unable to get response from verify endpoint")
+	}
+	defer func() {
+		err := res.Body.Close()
+		if err != nil {
+			logger.FromContext(ctx).WithError(err).Warn("unable to close verify response body")
+		}
+	}()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "[Verify]: unable to read response body")
+	}
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		var resErr responseVerifyError
+		if err := json.Unmarshal(body, &resErr); err != nil {
+			return nil, errors.Wrapf(errorInvalidIDToken, "[Verify]: verify endpoint responded with status %d", res.StatusCode)
+		}
+
+		return nil, errors.Wrapf(errorInvalidIDToken, "[Verify]: %s %s", resErr.Error, resErr.Description)
+	}
+
+	var claims IDTokenClaims
+	err = json.Unmarshal(body, &claims)
+	if err != nil {
+		return nil, errors.Wrap(err, "[Verify]: unable to unmarshal response body")
+	}
+
+	if err := v.validateClaims(&claims); err != nil {
+		return nil, err
+	}
+
+	return &claims, nil
+}
+
+func (v *idTokenVerifier) validateClaims(claims *IDTokenClaims) error {
+	if claims.Issuer != idTokenIssuer {
+		return errors.Wrapf(errorInvalidIDToken, "[validateClaims]: unexpected issuer %s", claims.Issuer)
+	}
+
+	if claims.Audience != v.channelID {
+		return errors.Wrapf(errorInvalidIDToken, "[validateClaims]: unexpected audience %s", claims.Audience)
+	}
+
+	if time.Now().Unix() > claims.ExpiresAt {
+		return errors.Wrap(errorInvalidIDToken, "[validateClaims]: token has expired")
+	}
+
+	if claims.Subject == "" {
+		return errors.Wrap(errorInvalidIDToken, "[validateClaims]: token has no subject")
+	}
+
+	return nil
+}
@@ -0,0 +1,90 @@
+package line
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const testChannelID = "1234567890"
+
+func TestIDTokenVerifierVerify(t *testing.T) {
+	valid := func() IDTokenClaims {
+		return IDTokenClaims{
+			Issuer:    idTokenIssuer,
+			Subject:   "U1234",
+			Audience:  testChannelID,
+			ExpiresAt: time.Now().Add(time.Hour).Unix(),
+			IssuedAt:  time.Now().Unix(),
+			Name:      "sapo",
+		}
+	}
+
+	tests := []struct {
+		name    string
+		status  int
+		modify  func(c *IDTokenClaims)
+		wantErr bool
+	}{
+		{"valid token", http.StatusOK, func(c *IDTokenClaims) {}, false},
+		{"wrong issuer", http.StatusOK, func(c *IDTokenClaims) { c.Issuer = "https://example.com" }, true},
+		{"wrong audience", http.StatusOK, func(c *IDTokenClaims) { c.Audience = "another channel" }, true},
+		{"expired", http.StatusOK, func(c *IDTokenClaims) { c.ExpiresAt = time.Now().Add(-time.Minute).Unix() }, true},
+		{"empty subject", http.StatusOK, func(c *IDTokenClaims) { c.Subject = "" }, true},
+		{"rejected by the verify endpoint", http.StatusBadRequest, func(c *IDTokenClaims) {}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := valid()
+			tt.modify(&claims)
+
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if err := r.ParseForm(); err != nil {
+					t.Error(err)
+				}
+				if r.PostForm.Get("id_token") != "token" || r.PostForm.Get("client_id") != testChannelID {
+					t.Errorf("verify request form = %v", r.PostForm)
+				}
+
+				w.WriteHeader(tt.status)
+				if tt.status != http.StatusOK {
+					_ = json.NewEncoder(w).Encode(responseVerifyError{Error: "invalid_request", Description: "Invalid IdToken."})
+					return
+				}
+				_ = json.NewEncoder(w).Encode(claims)
+			}))
+			defer srv.Close()
+
+			got, err := NewIDTokenVerifier(testChannelID, srv.URL).Verify(context.Background(), "token")
+			if tt.wantErr {
+				if errors.Cause(err) != errorInvalidIDToken {
+					t.Errorf("Verify() error = %v, want %v", err, errorInvalidIDToken)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Verify() error = %v", err)
+			}
+			if *got != claims {
+				t.Errorf("Verify() = %+v, want %+v", *got, claims)
+			}
+		})
+	}
+}
+
+func TestIDTokenVerifierVerifyEmptyToken(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("an empty token must not reach the verify endpoint")
+	}))
+	defer srv.Close()
+
+	if _, err := NewIDTokenVerifier(testChannelID, srv.URL).Verify(context.Background(), ""); err != errorInvalidIDToken {
+		t.Errorf("Verify() error = %v, want %v", err, errorInvalidIDToken)
+	}
+}
@@ -1,25 +1,42 @@
 package message
 
 import (
+	"encoding/json"
 	"fmt"
+	"strings"
 )
 
+// quote returns s as a JSON string literal, names from spotify may hold quotes or backslashes
+// that would break a message formatted with a plain "%s"
+func quote(s string) string {
+	b, _ := json.Marshal(s)
+
+	return string(b)
+}
+
 type Flex interface {
 	ToComponent() string
 	ToFlex() string
 	ToJson() []byte
 }
 
+// Reply sends Message followed by Messages, a reply holds at most 5 messages
 type Reply struct {
 	ReplyToken string
 	Message    Flex
+	Messages   []Flex
 }
 
 func (r *Reply) ToJson() []byte {
+	messages := []string{r.Message.ToFlex()}
+	for _, m := range r.Messages {
+		messages = append(messages, m.ToFlex())
+	}
+
 	msg := fmt.Sprintf(`{
-		"replyToken":"%s",
+		"replyToken":%s,
 		"messages":[%s]
-	}`, r.ReplyToken, r.Message.ToFlex())
+	}`, quote(r.ReplyToken), strings.Join(messages, ","))
 
 	return []byte(msg)
 }
@@ -31,9 +48,63 @@ type Push struct {
 
 func (r *Push) ToJson() []byte {
 	msg := fmt.Sprintf(`{
-		"to":"%s",
+		"to":%s,
 		"messages":[%s]
-	}`, r.ToID, r.Message.ToFlex())
+	}`, quote(r.ToID), r.Message.ToFlex())
 
 	return []byte(msg)
 }
+
+const (
+	ActionTypeURI      = "uri"
+	ActionTypePostback = "postback"
+)
+
+// Action is what tapping a button does, a postback sends Data back to the bot as a postback event
+type Action struct {
+	Type        string
+	Label       string
+	URI         string
+	Data        string
+	DisplayText string
+}
+
+func NewURIAction(label, uri string) Action {
+	return Action{
+		Type:  ActionTypeURI,
+		Label: label,
+		URI:   uri,
+	}
+}
+
+// NewPostbackAction shows displayText in the chat as if the user typed it, an empty displayText shows nothing
+func NewPostbackAction(label, data, displayText string) Action {
+	return Action{
+		Type:        ActionTypePostback,
+		Label:       label,
+		Data:        data,
+		DisplayText: displayText,
+	}
+}
+
+func (a *Action) ToComponent() string {
+	if a.Type == ActionTypePostback {
+		displayText := ""
+		if a.DisplayText != "" {
+			displayText = fmt.Sprintf(`,
+				  "displayText": %s`, quote(a.DisplayText))
+		}
+
+		return fmt.Sprintf(`{
+				  "type": "postback",
+				  "label": %s,
+				  "data": %s%s
+				}`, quote(a.Label), quote(a.Data), displayText)
+	}
+
+	return fmt.Sprintf(`{
+				  "type": "uri",
+				  "label": %s,
+				  "uri": %s
+				}`, quote(a.Label), quote(a.URI))
+}
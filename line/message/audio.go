@@ -0,0 +1,77 @@
+package message
+
+import (
+	"encoding/json"
+)
+
+// Text is a plain text message, for sending text along with other messages in a single reply
+type Text struct {
+	Text string
+}
+
+func NewText(text string) Flex {
+	return &Text{
+		Text: text,
+	}
+}
+
+// ToComponent marshals rather than formats the message, the text usually comes from spotify and may hold quotes
+func (t *Text) ToComponent() string {
+	text, _ := json.Marshal(struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}{
+		Type: "text",
+		Text: t.Text,
+	})
+
+	return string(text)
+}
+
+func (t *Text) ToFlex() string {
+	return t.ToComponent()
+}
+
+func (t *Text) ToJson() []byte {
+	return []byte(t.ToFlex())
+}
+
+// Audio is an audio message, Duration is in milliseconds
+type Audio struct {
+	URL      string
+	Duration int
+}
+
+// NewAudio returns a text message with fallback instead when there is no audio to play
+func NewAudio(url string, duration int, fallback string) Flex {
+	if url == "" {
+		return NewText(fallback)
+	}
+
+	return &Audio{
+		URL:      url,
+		Duration: duration,
+	}
+}
+
+func (a *Audio) ToComponent() string {
+	audio, _ := json.Marshal(struct {
+		Type     string `json:"type"`
+		URL      string `json:"originalContentUrl"`
+		Duration int    `json:"duration"`
+	}{
+		Type:     "audio",
+		URL:      a.URL,
+		Duration: a.Duration,
+	})
+
+	return string(audio)
+}
+
+func (a *Audio) ToFlex() string {
+	return a.ToComponent()
+}
+
+func (a *Audio) ToJson() []byte {
+	return []byte(a.ToFlex())
+}
@@ -30,46 +30,46 @@ func NewBubbleWithButton(altText, header, text, buttonLabel, urlAction, imgUrl,
 func (b *BubbleWithButton) ToComponent() string {
 	cover := fmt.Sprintf(`{
 					"type": "image",
-					"url": "%s",
+					"url": %s,
 					"size": "full",
 					"aspectMode": "cover",
 					"gravity": "center"
-				  }`, b.ImageURL)
+				  }`, quote(b.ImageURL))
 	header := fmt.Sprintf(`{
 						"type": "box",
 						"layout": "vertical",
 						"contents": [
 						  {
 							"type": "text",
-							"text": "%s",
+							"text": %s,
 							"color": "#ffffff",
 							"weight": "bold",
 							"size": "sm"
 						  }
 						]
-					  }`, b.Header)
+					  }`, quote(b.Header))
 	text := fmt.Sprintf(`{
 						"type": "box",
 						"layout": "vertical",
 						"contents": [
 						  {
 							"type": "text",
-							"text": "%s",
+							"text": %s,
 							"color": "#969696",
 							"size": "xxs"
 						  }
 						]
-					  }`, b.Text)
+					  }`, quote(b.Text))
 	button := fmt.Sprintf(`{
 						"type": "button",
 						"action": {
 						  "type": "uri",
-						  "label": "%s",
-						  "uri": "%s"
+						  "label": %s,
+						  "uri": %s
 						},
 						"color": "#ffffff",
 						"offsetBottom": "5px"
-					  }`, b.ButtonLabel, b.URLAction)
+					  }`, quote(b.ButtonLabel), quote(b.URLAction))
 	footer := fmt.Sprintf(`{
 					"type": "box",
 					"layout": "vertical",
@@ -102,9 +102,9 @@ func (b *BubbleWithButton) ToFlex() string {
 	flex := fmt.Sprintf(
 		`{
 				  "type": "flex",
-				  "altText": "%s",
+				  "altText": %s,
 				  "contents": %s
-				}`, b.AltText, b.ToComponent())
+				}`, quote(b.AltText), b.ToComponent())
 
 	return flex
 }
@@ -121,12 +121,14 @@ type BubbleReceipt struct {
 	Items   []BubbleReceiptBox
 }
 
+// BubbleReceiptBox opens URL when tapped, unless Action is set
 type BubbleReceiptBox struct {
 	Header   string
 	Text     string
 	LeftText string
 	ImageURL string
 	URL      string
+	Action   *Action
 }
 
 func NewBubbleReceipt(altText, topText, header, text string, items []BubbleReceiptBox) Flex {
@@ -142,37 +144,40 @@ func NewBubbleReceipt(altText, topText, header, text string, items []BubbleRecei
 func (b *BubbleReceiptBox) ToComponent() string {
 	image := fmt.Sprintf(`{
 				  "type": "image",
-				  "url": "%s",
+				  "url": %s,
 				  "size": "50px",
 				  "align": "start",
 				  "aspectRatio": "1:1"
-				}`, b.ImageURL)
+				}`, quote(b.ImageURL))
 	header := fmt.Sprintf(`{
 				  "type": "text",
-				  "text": "%s",
+				  "text": %s,
 				  "color": "#373C41",
 				  "size": "sm",
 				  "weight": "bold",
 				  "align": "start"
-				}`, b.Header)
+				}`, quote(b.Header))
 	leftText := fmt.Sprintf(`{
 				  "type": "text",
-				  "text": "%s",
+				  "text": %s,
 				  "color": "#969696",
 				  "size": "xxs",
 				  "align": "end"
-				}`, b.LeftText)
+				}`, quote(b.LeftText))
 	text := fmt.Sprintf(`{
 				  "type": "text",
-				  "text": "%s",
+				  "text": %s,
 				  "color": "#969696",
 				  "size": "xxs"
-				}`, b.Text)
+				}`, quote(b.Text))
 	action := fmt.Sprintf(`{
                 "type": "uri",
                 "label": "action",
-                "uri": "%s"
-              }`, b.URL)
+                "uri": %s
+              }`, quote(b.URL))
+	if b.Action != nil {
+		action = b.Action.ToComponent()
+	}
 	box := fmt.Sprintf(`{
 			  "type": "box",
 			  "layout": "horizontal",
@@ -210,27 +215,27 @@ func (b *BubbleReceipt) ToComponent() string {
 
 	topText := fmt.Sprintf(`{
 				  "type": "text",
-				  "text": "%s",
+				  "text": %s,
 				  "weight": "bold",
 				  "color": "#2FA6E9",
 				  "size": "sm"
-				}`, b.TopText)
+				}`, quote(b.TopText))
 	header := fmt.Sprintf(`{
 				  "type": "text",
-				  "text": "%s",
+				  "text": %s,
 				  "weight": "bold",
 				  "size": "xxl",
 				  "margin": "md",
 				  "color": "#373C41"
-				}`, b.Header)
+				}`, quote(b.Header))
 	text := fmt.Sprintf(`{
 				  "type": "text",
-				  "text": "%s",
+				  "text": %s,
 				  "size": "xs",
 				  "color": "#969696",
 				  "wrap": true,
   				  "offsetTop": "5px"
-				}`, b.Text)
+				}`, quote(b.Text))
 	bubble := fmt.Sprintf(`{
 				"type": "bubble",
 				"body": {
@@ -259,9 +264,9 @@ func (b *BubbleReceipt) ToFlex() string {
 	flex := fmt.Sprintf(
 		`{
 				  "type": "flex",
-				  "altText": "%s",
+				  "altText": %s,
 				  "contents": %s
-				}`, b.AltText, b.ToComponent())
+				}`, quote(b.AltText), b.ToComponent())
 
 	return flex
 }
@@ -274,15 +279,16 @@ type BubblePlain struct {
 	AltText  string
 	Text     string
 	ImageURL string
-	URL      string
+	Action   Action
 	Color    string
 }
 
-func NewBubblePlain(text, img, url, color string) Flex {
+// NewBubblePlain runs action when the bubble is tapped
+func NewBubblePlain(text, img string, action Action, color string) Flex {
 	return &BubblePlain{
 		Text:     text,
 		ImageURL: img,
-		URL:      url,
+		Action:   action,
 		Color:    color,
 	}
 }
@@ -290,21 +296,17 @@ func NewBubblePlain(text, img, url, color string) Flex {
 func (b *BubblePlain) ToComponent() string {
 	cover := fmt.Sprintf(`{
             "type": "image",
-            "url": "%s",
+            "url": %s,
             "aspectMode": "cover",
             "size": "full"
-          }`, b.ImageURL)
+          }`, quote(b.ImageURL))
 	text := fmt.Sprintf(`{
                 "type": "text",
-                "text": "%s",
+                "text": %s,
                 "color": "#ffffff",
                 "size": "xxs"
-              }`, b.Text)
-	action := fmt.Sprintf(`{
-          "type": "uri",
-          "label": "action",
-          "uri": "%s"
-        }`, b.URL)
+              }`, quote(b.Text))
+	action := b.Action.ToComponent()
 	bubble := fmt.Sprintf(`{
       "type": "bubble",
       "size": "nano",
@@ -341,9 +343,9 @@ func (b *BubblePlain) ToFlex() string {
 	flex := fmt.Sprintf(
 		`{
 				  "type": "flex",
-				  "altText": "%s",
+				  "altText": %s,
 				  "contents": %s
-				}`, b.AltText, b.ToComponent())
+				}`, quote(b.AltText), b.ToComponent())
 
 	return flex
 }
@@ -381,9 +383,9 @@ func (c *Carousel) ToFlex() string {
 	flex := fmt.Sprintf(
 		`{
 				  "type": "flex",
-				  "altText": "%s",
+				  "altText": %s,
 				  "contents": %s
-				}`, c.AltText, c.ToComponent())
+				}`, quote(c.AltText), c.ToComponent())
 
 	return flex
 }
@@ -415,27 +417,27 @@ func NewBubbleWithImage(altText, header, text, imageUrl, url, color string) Flex
 func (b *BubbleWithImage) ToComponent() string {
 	cover := fmt.Sprintf(`{
 						"type": "image",
-						"url": "%s",
+						"url": %s,
 						"size": "full",
 						"aspectMode": "cover"
-					  }`, b.ImageURL)
+					  }`, quote(b.ImageURL))
 	header := fmt.Sprintf(`{
 							"type": "text",
-							"text": "%s",
+							"text": %s,
 							"color": "#ffffff",
 							"size": "md"
-						  }`, b.Header)
+						  }`, quote(b.Header))
 	text := fmt.Sprintf(`{
 							"type": "text",
-							"text": "%s",
+							"text": %s,
 							"color": "#969696",
               				"size": "xs"
-						  }`, b.Text)
+						  }`, quote(b.Text))
 	action := fmt.Sprintf(`{
 					  "type": "uri",
 					  "label": "action",
-					  "uri": "%s"
-					}`, b.URLAction)
+					  "uri": %s
+					}`, quote(b.URLAction))
 	bubble := fmt.Sprintf(`{
 				  "type": "bubble",
 				  "size": "kilo",
@@ -469,12 +471,479 @@ func (b *BubbleWithImage) ToFlex() string {
 	flex := fmt.Sprintf(
 		`{
 				  "type": "flex",
-				  "altText": "%s",
+				  "altText": %s,
 				  "contents": %s
-				}`, b.AltText, b.ToComponent())
+				}`, quote(b.AltText), b.ToComponent())
 	return flex
 }
 
 func (b *BubbleWithImage) ToJson() []byte {
 	return []byte(b.ToFlex())
 }
+
+type BubbleStats struct {
+	AltText string
+	Header  string
+	Text    string
+	Items   []BubbleStatsItem
+}
+
+type BubbleStatsItem struct {
+	Label string
+	Value string
+}
+
+func NewBubbleStats(altText, header, text string, items []BubbleStatsItem) Flex {
+	return &BubbleStats{
+		AltText: altText,
+		Header:  header,
+		Text:    text,
+		Items:   items,
+	}
+}
+
+func (b *BubbleStatsItem) ToComponent() string {
+	row := fmt.Sprintf(`{
+			  "type": "box",
+			  "layout": "baseline",
+			  "contents": [
+				{
+				  "type": "text",
+				  "text": %s,
+				  "color": "#969696",
+				  "size": "sm",
+				  "flex": 0
+				},
+				{
+				  "type": "text",
+				  "text": %s,
+				  "color": "#373C41",
+				  "size": "sm",
+				  "weight": "bold",
+				  "align": "end"
+				}
+			  ]
+			}`, quote(b.Label), quote(b.Value))
+
+	return row
+}
+
+func (b *BubbleStats) ToComponent() string {
+	rows := []string{}
+	for _, item := range b.Items {
+		rows = append(rows, item.ToComponent())
+	}
+
+	header := fmt.Sprintf(`{
+				  "type": "text",
+				  "text": %s,
+				  "weight": "bold",
+				  "size": "xl",
+				  "color": "#373C41"
+				}`, quote(b.Header))
+	text := fmt.Sprintf(`{
+				  "type": "text",
+				  "text": %s,
+				  "size": "xs",
+				  "color": "#969696",
+				  "wrap": true,
+				  "margin": "sm"
+				}`, quote(b.Text))
+	bubble := fmt.Sprintf(`{
+				"type": "bubble",
+				"body": {
+				  "type": "box",
+				  "layout": "vertical",
+				  "contents": [%s,%s,
+					{
+					  "type": "separator",
+					  "margin": "xl"
+					},
+					{
+					  "type": "box",
+					  "layout": "vertical",
+					  "margin": "xl",
+					  "spacing": "md",
+					  "contents": [%s]
+					}
+				  ]
+				}
+			  }`, header, text, strings.Join(rows, ","))
+
+	return bubble
+}
+
+func (b *BubbleStats) ToFlex() string {
+	flex := fmt.Sprintf(
+		`{
+				  "type": "flex",
+				  "altText": %s,
+				  "contents": %s
+				}`, quote(b.AltText), b.ToComponent())
+
+	return flex
+}
+
+func (b *BubbleStats) ToJson() []byte {
+	return []byte(b.ToFlex())
+}
+
+type BubbleBarChart struct {
+	AltText string
+	Header  string
+	Text    string
+	Bars    []BubbleBar
+}
+
+// BubbleBar is a row of the chart, Percent from 0 to 100 is the width of its bar
+type BubbleBar struct {
+	Label   string
+	Value   string
+	Percent int
+}
+
+func NewBubbleBarChart(altText, header, text string, bars []BubbleBar) Flex {
+	return &BubbleBarChart{
+		AltText: altText,
+		Header:  header,
+		Text:    text,
+		Bars:    bars,
+	}
+}
+
+func (b *BubbleBar) ToComponent() string {
+	percent := b.Percent
+	if percent < 1 {
+		percent = 1
+	}
+	if percent > 100 {
+		percent = 100
+	}
+
+	bar := fmt.Sprintf(`{
+			  "type": "box",
+			  "layout": "vertical",
+			  "spacing": "xs",
+			  "contents": [
+				{
+				  "type": "box",
+				  "layout": "baseline",
+				  "contents": [
+					{
+					  "type": "text",
+					  "text": %s,
+					  "color": "#373C41",
+					  "size": "sm"
+					},
+					{
+					  "type": "text",
+					  "text": %s,
+					  "color": "#969696",
+					  "size": "xxs",
+					  "align": "end",
+					  "flex": 0
+					}
+				  ]
+				},
+				{
+				  "type": "box",
+				  "layout": "vertical",
+				  "contents": [
+					{
+					  "type": "box",
+					  "layout": "vertical",
+					  "contents": [],
+					  "width": "%d%%",
+					  "height": "6px",
+					  "backgroundColor": "#2FA6E9"
+					}
+				  ],
+				  "height": "6px",
+				  "backgroundColor": "#EEEEEE"
+				}
+			  ]
+			}`, quote(b.Label), quote(b.Value), percent)
+
+	return bar
+}
+
+func (b *BubbleBarChart) ToComponent() string {
+	bars := []string{}
+	for _, bar := range b.Bars {
+		bars = append(bars, bar.ToComponent())
+	}
+
+	header := fmt.Sprintf(`{
+				  "type": "text",
+				  "text": %s,
+				  "weight": "bold",
+				  "size": "xl",
+				  "color": "#373C41"
+				}`, quote(b.Header))
+	text := fmt.Sprintf(`{
+				  "type": "text",
+				  "text": %s,
+				  "size": "xs",
+				  "color": "#969696",
+				  "wrap": true,
+				  "margin": "sm"
+				}`, quote(b.Text))
+	bubble := fmt.Sprintf(`{
+				"type": "bubble",
+				"body": {
+				  "type": "box",
+				  "layout": "vertical",
+				  "contents": [%s,%s,
+					{
+					  "type": "box",
+					  "layout": "vertical",
+					  "margin": "xl",
+					  "spacing": "lg",
+					  "contents": [%s]
+					}
+				  ]
+				}
+			  }`, header, text, strings.Join(bars, ","))
+
+	return bubble
+}
+
+func (b *BubbleBarChart) ToFlex() string {
+	flex := fmt.Sprintf(
+		`{
+				  "type": "flex",
+				  "altText": %s,
+				  "contents": %s
+				}`, quote(b.AltText), b.ToComponent())
+
+	return flex
+}
+
+func (b *BubbleBarChart) ToJson() []byte {
+	return []byte(b.ToFlex())
+}
+
+// BubbleWithActions is a bubble with a button per action under its text, the image is left out when ImageURL is empty
+type BubbleWithActions struct {
+	AltText  string
+	Header   string
+	Text     string
+	ImageURL string
+	Actions  []Action
+}
+
+func NewBubbleWithActions(altText, header, text, imageUrl string, actions []Action) Flex {
+	return &BubbleWithActions{
+		AltText:  altText,
+		Header:   header,
+		Text:     text,
+		ImageURL: imageUrl,
+		Actions:  actions,
+	}
+}
+
+func (b *BubbleWithActions) ToComponent() string {
+	buttons := []string{}
+	for _, action := range b.Actions {
+		button := fmt.Sprintf(`{
+					"type": "button",
+					"action": %s,
+					"style": "link",
+					"height": "sm",
+					"color": "#2FA6E9"
+				  }`, action.ToComponent())
+		buttons = append(buttons, button)
+	}
+
+	hero := ""
+	if b.ImageURL != "" {
+		hero = fmt.Sprintf(`
+			  "hero": {
+				"type": "image",
+				"url": %s,
+				"size": "full",
+				"aspectRatio": "1:1",
+				"aspectMode": "cover"
+			  },`, quote(b.ImageURL))
+	}
+	header := fmt.Sprintf(`{
+				  "type": "text",
+				  "text": %s,
+				  "weight": "bold",
+				  "size": "md",
+				  "color": "#373C41",
+				  "wrap": true,
+				  "maxLines": 2
+				}`, quote(b.Header))
+	text := fmt.Sprintf(`{
+				  "type": "text",
+				  "text": %s,
+				  "size": "xs",
+				  "color": "#969696",
+				  "wrap": true,
+				  "maxLines": 2
+				}`, quote(b.Text))
+	bubble := fmt.Sprintf(`{
+			  "type": "bubble",
+			  "size": "kilo",%s
+			  "body": {
+				"type": "box",
+				"layout": "vertical",
+				"spacing": "xs",
+				"contents": [%s,%s]
+			  },
+			  "footer": {
+				"type": "box",
+				"layout": "vertical",
+				"spacing": "xs",
+				"contents": [%s]
+			  }
+			}`, hero, header, text, strings.Join(buttons, ","))
+
+	return bubble
+}
+
+func (b *BubbleWithActions) ToFlex() string {
+	flex := fmt.Sprintf(
+		`{
+				  "type": "flex",
+				  "altText": %s,
+				  "contents": %s
+				}`, quote(b.AltText), b.ToComponent())
+
+	return flex
+}
+
+func (b *BubbleWithActions) ToJson() []byte {
+	return []byte(b.ToFlex())
+}
+
+// BubbleNowPlaying shows a playing track with a progress bar, Percent from 0 to 100, and its actions side by side
+type BubbleNowPlaying struct {
+	AltText      string
+	Header       string
+	Text         string
+	ImageURL     string
+	Percent      int
+	ProgressText string
+	Actions      []Action
+}
+
+func NewBubbleNowPlaying(altText, header, text, imageUrl string, percent int, progressText string, actions []Action) Flex {
+	return &BubbleNowPlaying{
+		AltText:      altText,
+		Header:       header,
+		Text:         text,
+		ImageURL:     imageUrl,
+		Percent:      percent,
+		ProgressText: progressText,
+		Actions:      actions,
+	}
+}
+
+func (b *BubbleNowPlaying) ToComponent() string {
+	percent := b.Percent
+	if percent < 1 {
+		percent = 1
+	}
+	if percent > 100 {
+		percent = 100
+	}
+
+	buttons := []string{}
+	for _, action := range b.Actions {
+		button := fmt.Sprintf(`{
+					"type": "button",
+					"action": %s,
+					"style": "link",
+					"height": "sm",
+					"color": "#2FA6E9"
+				  }`, action.ToComponent())
+		buttons = append(buttons, button)
+	}
+
+	hero := ""
+	if b.ImageURL != "" {
+		hero = fmt.Sprintf(`
+			  "hero": {
+				"type": "image",
+				"url": %s,
+				"size": "full",
+				"aspectRatio": "1:1",
+				"aspectMode": "cover"
+			  },`, quote(b.ImageURL))
+	}
+	header := fmt.Sprintf(`{
+				  "type": "text",
+				  "text": %s,
+				  "weight": "bold",
+				  "size": "md",
+				  "color": "#373C41",
+				  "wrap": true,
+				  "maxLines": 2
+				}`, quote(b.Header))
+	text := fmt.Sprintf(`{
+				  "type": "text",
+				  "text": %s,
+				  "size": "xs",
+				  "color": "#969696",
+				  "wrap": true,
+				  "maxLines": 2
+				}`, quote(b.Text))
+	progress := fmt.Sprintf(`{
+				  "type": "box",
+				  "layout": "vertical",
+				  "margin": "md",
+				  "contents": [
+					{
+					  "type": "box",
+					  "layout": "vertical",
+					  "contents": [],
+					  "width": "%d%%",
+					  "height": "4px",
+					  "backgroundColor": "#2FA6E9"
+					}
+				  ],
+				  "height": "4px",
+				  "backgroundColor": "#EEEEEE"
+				},
+				{
+				  "type": "text",
+				  "text": %s,
+				  "size": "xxs",
+				  "color": "#969696",
+				  "align": "end"
+				}`, percent, quote(b.ProgressText))
+	bubble := fmt.Sprintf(`{
+			  "type": "bubble",
+			  "size": "kilo",%s
+			  "body": {
+				"type": "box",
+				"layout": "vertical",
+				"spacing": "xs",
+				"contents": [%s,%s,%s]
+			  },
+			  "footer": {
+				"type": "box",
+				"layout": "horizontal",
+				"contents": [%s]
+			  }
+			}`, hero, header, text, progress, strings.Join(buttons, ","))
+
+	return bubble
+}
+
+func (b *BubbleNowPlaying) ToFlex() string {
+	flex := fmt.Sprintf(
+		`{
+				  "type": "flex",
+				  "altText": %s,
+				  "contents": %s
+				}`, quote(b.AltText), b.ToComponent())
+
+	return flex
+}
+
+func (b *BubbleNowPlaying) ToJson() []byte {
+	return []byte(b.ToFlex())
+}
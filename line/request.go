@@ -0,0 +1,108 @@
+package line
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/bbkbbbk/sapo/pkg/logger"
+	"github.com/bbkbbbk/sapo/pkg/metrics"
+	"github.com/bbkbbbk/sapo/pkg/tracing"
+)
+
+// pathCollections are the path segments followed by an id, used to keep endpoint names low cardinality
+var pathCollections = map[string]bool{
+	"user":     true,
+	"richmenu": true,
+	"alias":    true,
+}
+
+// endpointName turns a request url into a name like "POST /v2/bot/user/{id}/richmenu/{id}" for logs
+func endpointName(method, rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return method
+	}
+
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+	for i := 1; i < len(segments); i++ {
+		if pathCollections[segments[i-1]] && segments[i] != "alias" {
+			segments[i] = "{id}"
+		}
+	}
+
+	return fmt.Sprintf("%s /%s", method, strings.Join(segments, "/"))
+}
+
+// RequestError is returned when LINE responds to a raw request with a non 2xx status
+type RequestError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *RequestError) Error() string {
+	return fmt.Sprintf("line responded with status %d: %s", e.StatusCode, e.Body)
+}
+
+func requestEndpointName(req *http.Request) string {
+	return endpointName(req.Method, req.URL.String())
+}
+
+// newHTTPClient returns the client every request to LINE goes through, including the ones made by the SDK
+func newHTTPClient() *http.Client {
+	return &http.Client{
+		Timeout:   time.Second * defaultTimeout,
+		Transport: tracing.NewTransport("line", requestEndpointName, metrics.NewTransport("line", requestEndpointName, nil)),
+	}
+}
+
+// makeRequest calls a Messaging API endpoint the SDK does not cover and returns the response body
+func makeRequest(ctx context.Context, client *http.Client, token, method, url string, reqBody io.Reader) ([]byte, error) {
+	start := time.Now()
+	log := logger.FromContext(ctx).WithField(logger.FieldLINEEndpoint, endpointName(method, url))
+
+	req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
+	if err != nil {
+		return nil, errors.Wrap(err, "[makeRequest]: unable to create request")
+	}
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+	req.Header.Add("Content-Type", "application/json")
+
+	res, err := client.Do(req)
+	if err != nil {
+		log.WithError(err).WithField(logger.FieldLatency, time.Since(start).Milliseconds()).Warn("line request failed")
+		return nil, errors.Wrap(err, "[makeRequest]: unable to get response from line")
+	}
+	defer func() {
+		err := res.Body.Close()
+		if err != nil {
+			log.WithError(err).Warn("unable to close line response body")
+		}
+	}()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "[makeRequest]: unable to read response body")
+	}
+
+	log = log.WithFields(logrus.Fields{
+		logger.FieldStatus:  res.StatusCode,
+		logger.FieldLatency: time.Since(start).Milliseconds(),
+	})
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		err := &RequestError{StatusCode: res.StatusCode, Body: string(body)}
+		log.WithError(err).Warn("line request failed")
+		return nil, errors.Wrap(err, "[makeRequest]: unable to make a success request")
+	}
+	log.Debug("line request succeeded")
+
+	return body, nil
+}
@@ -0,0 +1,451 @@
+package line
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/line/line-bot-sdk-go/linebot"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+
+	"github.com/bbkbbbk/sapo/pkg/logger"
+)
+
+const (
+	RichMenuKeyLogin   = "login"
+	RichMenuKeyDefault = "default"
+
+	RichMenuActionTypeSwitch = "richmenuswitch"
+
+	fieldRichMenu      = "rich_menu"
+	fieldRichMenuID    = "rich_menu_id"
+	fieldRichMenuAlias = "rich_menu_alias"
+)
+
+var (
+	errorInvalidRichMenuDefinition = errors.New("invalid rich menu definition")
+	errorMissingRichMenu           = errors.New("missing rich menu, run the richmenu command to create it")
+)
+
+// RichMenuDefinitions is the file format rich menus are declared in, either JSON or YAML
+type RichMenuDefinitions struct {
+	Menus []RichMenuDefinition `json:"menus" yaml:"menus"`
+}
+
+// RichMenuDefinition describes a rich menu and the image uploaded for it,
+// Key is how sapo refers to the menu and SetDefault makes it the menu of users without a linked one.
+// Alias registers a rich menu alias for the menu so richmenuswitch actions of other menus can switch to it.
+type RichMenuDefinition struct {
+	Key         string         `json:"key" yaml:"key"`
+	Alias       string         `json:"alias" yaml:"alias"`
+	Image       string         `json:"image" yaml:"image"`
+	SetDefault  bool           `json:"setDefault" yaml:"setDefault"`
+	Size        RichMenuSize   `json:"size" yaml:"size"`
+	Selected    bool           `json:"selected" yaml:"selected"`
+	Name        string         `json:"name" yaml:"name"`
+	ChatBarText string         `json:"chatBarText" yaml:"chatBarText"`
+	Areas       []RichMenuArea `json:"areas" yaml:"areas"`
+}
+
+type RichMenuSize struct {
+	Width  int `json:"width" yaml:"width"`
+	Height int `json:"height" yaml:"height"`
+}
+
+type RichMenuArea struct {
+	Bounds RichMenuBounds `json:"bounds" yaml:"bounds"`
+	Action RichMenuAction `json:"action" yaml:"action"`
+}
+
+type RichMenuBounds struct {
+	X      int `json:"x" yaml:"x"`
+	Y      int `json:"y" yaml:"y"`
+	Width  int `json:"width" yaml:"width"`
+	Height int `json:"height" yaml:"height"`
+}
+
+type RichMenuAction struct {
+	Type        string `json:"type" yaml:"type"`
+	Label       string `json:"label,omitempty" yaml:"label"`
+	URI         string `json:"uri,omitempty" yaml:"uri"`
+	Text        string `json:"text,omitempty" yaml:"text"`
+	Data        string `json:"data,omitempty" yaml:"data"`
+	DisplayText string `json:"displayText,omitempty" yaml:"displayText"`
+	// RichMenuAliasID is the alias a richmenuswitch action switches to, its Data is sent back as a postback
+	RichMenuAliasID string `json:"richMenuAliasId,omitempty" yaml:"richMenuAliasId"`
+}
+
+// richMenuObject is the request body of the create rich menu endpoint
+type richMenuObject struct {
+	Size        RichMenuSize   `json:"size"`
+	Selected    bool           `json:"selected"`
+	Name        string         `json:"name"`
+	ChatBarText string         `json:"chatBarText"`
+	Areas       []RichMenuArea `json:"areas"`
+}
+
+type responseCreateRichMenu struct {
+	RichMenuID string `json:"richMenuId"`
+}
+
+type richMenuAlias struct {
+	RichMenuAliasID string `json:"richMenuAliasId,omitempty"`
+	RichMenuID      string `json:"richMenuId"`
+}
+
+// RichMenuState records the rich menus created from definitions so syncing again only touches what changed
+type RichMenuState struct {
+	Menus map[string]RichMenuStateEntry `json:"menus"`
+}
+
+type RichMenuStateEntry struct {
+	ID        string     `json:"id"`
+	Alias     string     `json:"alias,omitempty"`
+	Hash      string     `json:"hash"`
+	CreatedAt *time.Time `json:"createdAt"`
+}
+
+// Metadata returns the rich menu ids the bot links users to, the state has to hold both the login and default menu
+func (st *RichMenuState) Metadata() (RichMenuMetadata, error) {
+	for _, key := range []string{RichMenuKeyLogin, RichMenuKeyDefault} {
+		if st.Menus[key].ID == "" {
+			return RichMenuMetadata{}, errors.Wrapf(errorMissingRichMenu, "[Metadata]: no %s rich menu in state", key)
+		}
+	}
+
+	aliases := map[string]string{}
+	for _, entry := range st.Menus {
+		if entry.Alias != "" {
+			aliases[entry.Alias] = entry.ID
+		}
+	}
+
+	return RichMenuMetadata{
+		Login:   st.Menus[RichMenuKeyLogin].ID,
+		Default: st.Menus[RichMenuKeyDefault].ID,
+		Aliases: aliases,
+	}, nil
+}
+
+func (st *RichMenuState) Save(path string) error {
+	content, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "[Save]: unable to marshal rich menu state")
+	}
+
+	if err := ioutil.WriteFile(path, content, 0644); err != nil {
+		return errors.Wrap(err, "[Save]: unable to write rich menu state")
+	}
+
+	return nil
+}
+
+func LoadRichMenuDefinitions(path string) (*RichMenuDefinitions, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "[LoadRichMenuDefinitions]: unable to read file")
+	}
+
+	var defs RichMenuDefinitions
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalStrict(content, &defs)
+	default:
+		err = json.Unmarshal(content, &defs)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "[LoadRichMenuDefinitions]: unable to unmarshal definitions")
+	}
+
+	// images are resolved relative to the definitions file
+	dir := filepath.Dir(path)
+	keys := map[string]bool{}
+	missing := []string{}
+	for i, def := range defs.Menus {
+		if def.Key == "" || keys[def.Key] {
+			return nil, errors.Wrapf(errorInvalidRichMenuDefinition, "[LoadRichMenuDefinitions]: menu %d has an empty or duplicated key", i)
+		}
+		keys[def.Key] = true
+
+		if def.Image == "" {
+			return nil, errors.Wrapf(errorInvalidRichMenuDefinition, "[LoadRichMenuDefinitions]: menu %s has no image", def.Key)
+		}
+		if !filepath.IsAbs(def.Image) {
+			defs.Menus[i].Image = filepath.Join(dir, def.Image)
+		}
+		if _, err := os.Stat(defs.Menus[i].Image); err != nil {
+			missing = append(missing, defs.Menus[i].Image)
+		}
+	}
+	// report every missing image at once rather than failing on the first menu that gets hashed
+	if len(missing) > 0 {
+		return nil, errors.Wrapf(errorInvalidRichMenuDefinition, "[LoadRichMenuDefinitions]: missing images %s", strings.Join(missing, ", "))
+	}
+
+	if err := validateRichMenuSwitches(defs); err != nil {
+		return nil, err
+	}
+
+	return &defs, nil
+}
+
+// validateRichMenuSwitches makes sure every richmenuswitch action switches to an alias declared in the same file
+func validateRichMenuSwitches(defs RichMenuDefinitions) error {
+	aliases := map[string]bool{}
+	for _, def := range defs.Menus {
+		if def.Alias != "" {
+			if aliases[def.Alias] {
+				return errors.Wrapf(errorInvalidRichMenuDefinition, "[validateRichMenuSwitches]: alias %s is declared twice", def.Alias)
+			}
+			aliases[def.Alias] = true
+		}
+	}
+
+	for _, def := range defs.Menus {
+		for _, area := range def.Areas {
+			if area.Action.Type != RichMenuActionTypeSwitch {
+				continue
+			}
+			if !aliases[area.Action.RichMenuAliasID] {
+				return errors.Wrapf(errorInvalidRichMenuDefinition, "[validateRichMenuSwitches]: menu %s switches to unknown alias %s", def.Key, area.Action.RichMenuAliasID)
+			}
+			if area.Action.Data == "" {
+				return errors.Wrapf(errorInvalidRichMenuDefinition, "[validateRichMenuSwitches]: menu %s has a richmenuswitch action without data", def.Key)
+			}
+		}
+	}
+
+	return nil
+}
+
+// LoadRichMenuState returns an empty state when the file does not exist yet, Metadata of which fails
+func LoadRichMenuState(path string) (*RichMenuState, error) {
+	st := &RichMenuState{
+		Menus: map[string]RichMenuStateEntry{},
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return st, nil
+		}
+		return nil, errors.Wrap(err, "[LoadRichMenuState]: unable to read file")
+	}
+
+	if err := json.Unmarshal(content, st); err != nil {
+		return nil, errors.Wrap(err, "[LoadRichMenuState]: unable to unmarshal rich menu state")
+	}
+	if st.Menus == nil {
+		st.Menus = map[string]RichMenuStateEntry{}
+	}
+
+	return st, nil
+}
+
+// RichMenuSyncer creates rich menus from definitions through the Messaging API
+type RichMenuSyncer struct {
+	lineClient   *linebot.Client
+	httpClient   *http.Client
+	channelToken string
+	prune        bool
+}
+
+// NewRichMenuSyncer creates a syncer, prune deletes rich menus replaced by a changed definition
+func NewRichMenuSyncer(secret, token string, prune bool) (*RichMenuSyncer, error) {
+	httpClient := newHTTPClient()
+	bot, err := linebot.New(secret, token, linebot.WithHTTPClient(httpClient))
+	if err != nil {
+		return nil, errors.Wrap(err, "[NewRichMenuSyncer]: unable to initialize line client")
+	}
+
+	return &RichMenuSyncer{
+		lineClient:   bot,
+		httpClient:   httpClient,
+		channelToken: token,
+		prune:        prune,
+	}, nil
+}
+
+// Sync creates every menu whose definition or image changed since the state was recorded,
+// or that no longer exists on LINE, then sets the default menu. st is updated in place.
+func (r *RichMenuSyncer) Sync(ctx context.Context, defs *RichMenuDefinitions, st *RichMenuState) error {
+	for _, def := range defs.Menus {
+		log := logger.FromContext(ctx).WithField(fieldRichMenu, def.Key)
+
+		hash, err := r.hashDefinition(def)
+		if err != nil {
+			return errors.Wrapf(err, "[Sync]: unable to hash menu %s", def.Key)
+		}
+
+		entry, ok := st.Menus[def.Key]
+		if ok && entry.Hash == hash && r.exists(ctx, entry.ID) {
+			log.WithField(fieldRichMenuID, entry.ID).Info("rich menu is up to date")
+			entry.Alias = def.Alias
+			st.Menus[def.Key] = entry
+			continue
+		}
+
+		id, err := r.create(ctx, def)
+		if err != nil {
+			return errors.Wrapf(err, "[Sync]: unable to create menu %s", def.Key)
+		}
+		log.WithField(fieldRichMenuID, id).Info("created rich menu")
+
+		if ok && entry.ID != "" && r.prune {
+			if _, err := r.lineClient.DeleteRichMenu(entry.ID).WithContext(ctx).Do(); err != nil {
+				log.WithError(err).WithField(fieldRichMenuID, entry.ID).Warn("unable to delete replaced rich menu")
+			}
+		}
+
+		now := time.Now()
+		st.Menus[def.Key] = RichMenuStateEntry{
+			ID:        id,
+			Alias:     def.Alias,
+			Hash:      hash,
+			CreatedAt: &now,
+		}
+	}
+
+	for _, def := range defs.Menus {
+		if def.Alias == "" {
+			continue
+		}
+
+		if err := r.upsertAlias(ctx, def.Alias, st.Menus[def.Key].ID); err != nil {
+			return errors.Wrapf(err, "[Sync]: unable to point alias %s to menu %s", def.Alias, def.Key)
+		}
+	}
+
+	for _, def := range defs.Menus {
+		if !def.SetDefault {
+			continue
+		}
+
+		id := st.Menus[def.Key].ID
+		current, err := r.lineClient.GetDefaultRichMenu().WithContext(ctx).Do()
+		if err == nil && current.RichMenuID == id {
+			break
+		}
+
+		if _, err := r.lineClient.SetDefaultRichMenu(id).WithContext(ctx).Do(); err != nil {
+			return errors.Wrapf(err, "[Sync]: unable to set menu %s as default", def.Key)
+		}
+		logger.FromContext(ctx).WithField(fieldRichMenu, def.Key).WithField(fieldRichMenuID, id).Info("set rich menu as default")
+		break
+	}
+
+	return nil
+}
+
+func (r *RichMenuSyncer) hashDefinition(def RichMenuDefinition) (string, error) {
+	obj, err := json.Marshal(r.toObject(def))
+	if err != nil {
+		return "", errors.Wrap(err, "[hashDefinition]: unable to marshal definition")
+	}
+
+	img, err := ioutil.ReadFile(def.Image)
+	if err != nil {
+		return "", errors.Wrap(err, "[hashDefinition]: unable to read image")
+	}
+
+	h := sha256.New()
+	h.Write(obj)
+	h.Write(img)
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (r *RichMenuSyncer) exists(ctx context.Context, id string) bool {
+	if id == "" {
+		return false
+	}
+
+	_, err := r.lineClient.GetRichMenu(id).WithContext(ctx).Do()
+	return err == nil
+}
+
+func (r *RichMenuSyncer) toObject(def RichMenuDefinition) richMenuObject {
+	return richMenuObject{
+		Size:        def.Size,
+		Selected:    def.Selected,
+		Name:        def.Name,
+		ChatBarText: def.ChatBarText,
+		Areas:       def.Areas,
+	}
+}
+
+// upsertAlias creates the alias or points the existing one to id
+func (r *RichMenuSyncer) upsertAlias(ctx context.Context, alias, id string) error {
+	aliasURL := fmt.Sprintf("https://api.line.me/v2/bot/richmenu/alias/%s", alias)
+	log := logger.FromContext(ctx).WithField(fieldRichMenuAlias, alias).WithField(fieldRichMenuID, id)
+
+	res, err := makeRequest(ctx, r.httpClient, r.channelToken, http.MethodGet, aliasURL, nil)
+	if err == nil {
+		var current richMenuAlias
+		if err := json.Unmarshal(res, &current); err != nil {
+			return errors.Wrap(err, "[upsertAlias]: unable to unmarshal response body")
+		}
+		if current.RichMenuID == id {
+			return nil
+		}
+
+		body, err := json.Marshal(richMenuAlias{RichMenuID: id})
+		if err != nil {
+			return errors.Wrap(err, "[upsertAlias]: unable to marshal alias")
+		}
+		if _, err := makeRequest(ctx, r.httpClient, r.channelToken, http.MethodPost, aliasURL, bytes.NewReader(body)); err != nil {
+			return errors.Wrap(err, "[upsertAlias]: unable to update alias")
+		}
+		log.Info("pointed rich menu alias to the menu")
+
+		return nil
+	}
+
+	body, err := json.Marshal(richMenuAlias{RichMenuAliasID: alias, RichMenuID: id})
+	if err != nil {
+		return errors.Wrap(err, "[upsertAlias]: unable to marshal alias")
+	}
+	if _, err := makeRequest(ctx, r.httpClient, r.channelToken, http.MethodPost, "https://api.line.me/v2/bot/richmenu/alias", bytes.NewReader(body)); err != nil {
+		return errors.Wrap(err, "[upsertAlias]: unable to create alias")
+	}
+	log.Info("created rich menu alias")
+
+	return nil
+}
+
+func (r *RichMenuSyncer) create(ctx context.Context, def RichMenuDefinition) (string, error) {
+	body, err := json.Marshal(r.toObject(def))
+	if err != nil {
+		return "", errors.Wrap(err, "[create]: unable to marshal rich menu")
+	}
+
+	res, err := makeRequest(ctx, r.httpClient, r.channelToken, http.MethodPost, "https://api.line.me/v2/bot/richmenu", bytes.NewReader(body))
+	if err != nil {
+		return "", errors.Wrap(err, "[create]: unable to make request")
+	}
+
+	var created responseCreateRichMenu
+	if err := json.Unmarshal(res, &created); err != nil {
+		return "", errors.Wrap(err, "[create]: unable to unmarshal response body")
+	}
+
+	if _, err := r.lineClient.UploadRichMenuImage(created.RichMenuID, def.Image).WithContext(ctx).Do(); err != nil {
+		// a rich menu without an image cannot be used, do not leave it behind
+		if _, err := r.lineClient.DeleteRichMenu(created.RichMenuID).WithContext(ctx).Do(); err != nil {
+			logger.FromContext(ctx).WithError(err).WithField(fieldRichMenuID, created.RichMenuID).Warn("unable to delete rich menu without image")
+		}
+		return "", errors.Wrap(err, "[create]: unable to upload image")
+	}
+
+	return created.RichMenuID, nil
+}
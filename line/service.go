@@ -2,69 +2,78 @@ package line
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"net/http"
-	"time"
 
 	"github.com/line/line-bot-sdk-go/linebot"
 	"github.com/pkg/errors"
-	"github.com/sirupsen/logrus"
 
 	"github.com/bbkbbbk/sapo/line/message"
+	"github.com/bbkbbbk/sapo/pkg/logger"
 )
 
 const (
 	defaultTimeout = 30
 )
 
+var (
+	ErrorUnknownRichMenuAlias = errors.New("unknown rich menu alias")
+)
+
 type Service interface {
 	ParseRequest(req *http.Request) ([]*linebot.Event, error)
-	SendTextMessage(msg, token string) error
-	SendTextMessageWithQuickReplies(token, msg string, quickReplies *linebot.QuickReplyItems) error
-	SendFlexMessage(token string, msg *linebot.FlexMessage) error
-	LinkUserToLoginRichMenu(uid string) error
-	LinkUserToDefaultRichMenu(uid string) error
-	ReplyFlexMsg(replyToken string, flex message.Flex) error
-	PushFlexMsg(uid string, flex message.Flex) error
+	SendTextMessage(ctx context.Context, token, msg string) error
+	SendTextMessageWithQuickReplies(ctx context.Context, token, msg string, quickReplies *linebot.QuickReplyItems) error
+	SendFlexMessage(ctx context.Context, token string, msg *linebot.FlexMessage) error
+	LinkUserToLoginRichMenu(ctx context.Context, uid string) error
+	LinkUserToDefaultRichMenu(ctx context.Context, uid string) error
+	LinkUserToRichMenuAlias(ctx context.Context, uid, alias string) error
+	ReplyFlexMsg(ctx context.Context, replyToken string, flex message.Flex) error
+	ReplyMessages(ctx context.Context, replyToken string, first message.Flex, rest ...message.Flex) error
+	ReplyFlexMsgWithQuickReplies(ctx context.Context, replyToken string, flex message.Flex, quickReplies *linebot.QuickReplyItems) error
+	PushFlexMsg(ctx context.Context, uid string, flex message.Flex) error
+	PushTextMessage(ctx context.Context, uid, msg string) error
 }
 
 type service struct {
 	lineClient   *linebot.Client
+	httpClient   *http.Client
 	richMenu     RichMenuMetadata
 	channelToken string
 }
 
+// RichMenuMetadata holds the rich menu ids users are linked to,
+// Aliases maps the rich menu aliases tabs switch between to their rich menu ids
 type RichMenuMetadata struct {
 	Login   string
 	Default string
+	Aliases map[string]string
 }
 
-func NewLINEService(secret, token string, menu RichMenuMetadata) Service {
-	bot, err := linebot.New(secret, token)
+func NewLINEService(secret, token string, menu RichMenuMetadata) (Service, error) {
+	httpClient := newHTTPClient()
+	bot, err := linebot.New(secret, token, linebot.WithHTTPClient(httpClient))
 	if err != nil {
-		logrus.Warnf("[NewLINEService]: unable to initialize line line client %v", err)
+		return nil, errors.Wrap(err, "[NewLINEService]: unable to initialize line client")
 	}
 
 	return &service{
 		lineClient:   bot,
+		httpClient:   httpClient,
 		channelToken: token,
 		richMenu:     menu,
-	}
-}
-
-func (s *service) newAuthHeader() string {
-	return fmt.Sprintf("Bearer %s", s.channelToken)
+	}, nil
 }
 
 func (s *service) ParseRequest(req *http.Request) ([]*linebot.Event, error) {
 	return s.lineClient.ParseRequest(req)
 }
 
-func (s *service) SendTextMessage(token, msg string) error {
+func (s *service) SendTextMessage(ctx context.Context, token, msg string) error {
 	replyMsg := linebot.NewTextMessage(msg)
-	_, err := s.lineClient.ReplyMessage(token, replyMsg).Do()
+	_, err := s.lineClient.ReplyMessage(token, replyMsg).WithContext(ctx).Do()
 	if err != nil {
 		return errors.Wrap(err, "[SendTextMessage]: unable to send a reply text message")
 	}
@@ -72,9 +81,19 @@ func (s *service) SendTextMessage(token, msg string) error {
 	return nil
 }
 
-func (s *service) SendTextMessageWithQuickReplies(token, msg string, quickReplies *linebot.QuickReplyItems) error {
+func (s *service) PushTextMessage(ctx context.Context, uid, msg string) error {
+	pushMsg := linebot.NewTextMessage(msg)
+	_, err := s.lineClient.PushMessage(uid, pushMsg).WithContext(ctx).Do()
+	if err != nil {
+		return errors.Wrap(err, "[PushTextMessage]: unable to push a text message")
+	}
+
+	return nil
+}
+
+func (s *service) SendTextMessageWithQuickReplies(ctx context.Context, token, msg string, quickReplies *linebot.QuickReplyItems) error {
 	replyMsg := linebot.NewTextMessage(msg).WithQuickReplies(quickReplies)
-	_, err := s.lineClient.ReplyMessage(token, replyMsg).Do()
+	_, err := s.lineClient.ReplyMessage(token, replyMsg).WithContext(ctx).Do()
 	if err != nil {
 		return errors.Wrap(err, "[SendTextMessageWithQuickReplies]: unable to send a reply text message")
 	}
@@ -82,8 +101,8 @@ func (s *service) SendTextMessageWithQuickReplies(token, msg string, quickReplie
 	return nil
 }
 
-func (s *service) SendFlexMessage(token string, msg *linebot.FlexMessage) error {
-	_, err := s.lineClient.ReplyMessage(token, msg).Do()
+func (s *service) SendFlexMessage(ctx context.Context, token string, msg *linebot.FlexMessage) error {
+	_, err := s.lineClient.ReplyMessage(token, msg).WithContext(ctx).Do()
 	if err != nil {
 		return errors.Wrap(err, "[SendFlexMessage]: unable to send a reply flex message")
 	}
@@ -91,9 +110,9 @@ func (s *service) SendFlexMessage(token string, msg *linebot.FlexMessage) error
 	return nil
 }
 
-func (s *service) LinkUserToLoginRichMenu(uid string) error {
+func (s *service) LinkUserToLoginRichMenu(ctx context.Context, uid string) error {
 	rid := s.richMenu.Login
-	err := s.linkUserToRichMenu(uid, rid)
+	err := s.linkUserToRichMenu(ctx, uid, rid)
 	if err != nil {
 		return err
 	}
@@ -101,9 +120,9 @@ func (s *service) LinkUserToLoginRichMenu(uid string) error {
 	return nil
 }
 
-func (s *service) LinkUserToDefaultRichMenu(uid string) error {
+func (s *service) LinkUserToDefaultRichMenu(ctx context.Context, uid string) error {
 	rid := s.richMenu.Default
-	err := s.linkUserToRichMenu(uid, rid)
+	err := s.linkUserToRichMenu(ctx, uid, rid)
 	if err != nil {
 		return err
 	}
@@ -111,82 +130,92 @@ func (s *service) LinkUserToDefaultRichMenu(uid string) error {
 	return nil
 }
 
-func (s *service) linkUserToRichMenu(uid, rid string) error {
+// LinkUserToRichMenuAlias links the user to the menu behind a tab alias, e.g. to open a specific tab
+func (s *service) LinkUserToRichMenuAlias(ctx context.Context, uid, alias string) error {
+	rid, ok := s.richMenu.Aliases[alias]
+	if !ok {
+		return errors.Wrapf(ErrorUnknownRichMenuAlias, "[LinkUserToRichMenuAlias]: alias %s", alias)
+	}
+
+	return s.linkUserToRichMenu(ctx, uid, rid)
+}
+
+func (s *service) linkUserToRichMenu(ctx context.Context, uid, rid string) error {
 	lineURL := fmt.Sprintf("https://api.line.me/v2/bot/user/%s/richmenu/%s", uid, rid)
 
-	req, err := http.NewRequest("POST", lineURL, nil)
+	_, err := makeRequest(ctx, s.httpClient, s.channelToken, http.MethodPost, lineURL, nil)
 	if err != nil {
-		return errors.Wrap(err, "[linkUserToRichMenu]: unable to create request")
+		return errors.Wrapf(err, "[linkUserToRichMenu]: unable to request rich menu change for user id %s and rich menu id %s", uid, rid)
 	}
 
-	req.Header.Add("Authorization", s.newAuthHeader())
+	return nil
+}
+
+func (s *service) ReplyFlexMsg(ctx context.Context, replyToken string, flex message.Flex) error {
+	lineURL := "https://api.line.me/v2/bot/message/reply"
 
-	client := &http.Client{
-		Timeout: time.Second * defaultTimeout,
+	msg := message.Reply{
+		ReplyToken: replyToken,
+		Message:    flex,
 	}
-	res, err := client.Do(req)
-	defer func() {
-		err := res.Body.Close()
-		if err != nil {
-			logrus.Warn("[linkUserToRichMenu]: unable to close response body", err)
-		}
-	}()
+
+	_, err := makeRequest(ctx, s.httpClient, s.channelToken, http.MethodPost, lineURL, bytes.NewBuffer(msg.ToJson()))
 	if err != nil {
-		return errors.Wrapf(err, "[linkUserToRichMenu]: unable to request rich menu change for user id %s and rich menu id %s", uid, rid)
+		logger.FromContext(ctx).WithError(err).WithField("flex", flex.ToFlex()).Debug("rejected flex message")
+		return errors.Wrap(err, "[ReplyFlexMsg]: unable to make a success request")
 	}
 
 	return nil
 }
 
-func (s *service) ReplyFlexMsg(replyToken string, flex message.Flex) error {
+// ReplyMessages replies several messages at once, e.g. a flex message and an audio message of the same track
+func (s *service) ReplyMessages(ctx context.Context, replyToken string, first message.Flex, rest ...message.Flex) error {
 	lineURL := "https://api.line.me/v2/bot/message/reply"
 
 	msg := message.Reply{
 		ReplyToken: replyToken,
-		Message:    flex,
+		Message:    first,
+		Messages:   rest,
 	}
 
-	req, err := http.NewRequest("POST", lineURL, bytes.NewBuffer(msg.ToJson()))
+	_, err := makeRequest(ctx, s.httpClient, s.channelToken, http.MethodPost, lineURL, bytes.NewBuffer(msg.ToJson()))
 	if err != nil {
-		return errors.Wrap(err, "[SendReplyFlexMsg]: unable to create request")
+		logger.FromContext(ctx).WithError(err).WithField("messages", string(msg.ToJson())).Debug("rejected messages")
+		return errors.Wrap(err, "[ReplyMessages]: unable to make a success request")
 	}
-	req.Header.Add("Authorization", s.newAuthHeader())
-	req.Header.Add("Content-Type", "application/json")
 
-	client := &http.Client{
-		Timeout: time.Second * defaultTimeout,
+	return nil
+}
+
+// ReplyFlexMsgWithQuickReplies replies a flex message offering quick replies under it, a reply token is good for
+// a single reply so the quick replies cannot come in a message of their own
+func (s *service) ReplyFlexMsgWithQuickReplies(ctx context.Context, replyToken string, flex message.Flex, quickReplies *linebot.QuickReplyItems) error {
+	lineURL := "https://api.line.me/v2/bot/message/reply"
+
+	var msg map[string]interface{}
+	if err := json.Unmarshal([]byte(flex.ToFlex()), &msg); err != nil {
+		return errors.Wrap(err, "[ReplyFlexMsgWithQuickReplies]: unable to unmarshal flex message")
 	}
-	res, err := client.Do(req)
-	defer func() {
-		err := res.Body.Close()
-		if err != nil {
-			logrus.Warn("[SendReplyFlexMsg]: unable to close response body", err)
-		}
-	}()
+	msg["quickReply"] = quickReplies
+
+	body, err := json.Marshal(map[string]interface{}{
+		"replyToken": replyToken,
+		"messages":   []interface{}{msg},
+	})
 	if err != nil {
-		return errors.Wrap(err, "[SendReplyFlexMsg]: unable to make a success request")
+		return errors.Wrap(err, "[ReplyFlexMsgWithQuickReplies]: unable to marshal request body")
 	}
 
-	if res.StatusCode < 200 || res.StatusCode > 299 {
-		logrus.Info(res.StatusCode)
-		body, err := ioutil.ReadAll(res.Body)
-		if err != nil {
-			logrus.Warn(err)
-		}
-
-		var b interface{}
-		err = json.Unmarshal(body, &b)
-		if err != nil {
-			logrus.Warn(err)
-		}
-		logrus.Info(b)
-		logrus.Info(flex.ToFlex())
+	_, err = makeRequest(ctx, s.httpClient, s.channelToken, http.MethodPost, lineURL, bytes.NewBuffer(body))
+	if err != nil {
+		logger.FromContext(ctx).WithError(err).WithField("flex", flex.ToFlex()).Debug("rejected flex message")
+		return errors.Wrap(err, "[ReplyFlexMsgWithQuickReplies]: unable to make a success request")
 	}
 
 	return nil
 }
 
-func (s *service) PushFlexMsg(uid string, flex message.Flex) error {
+func (s *service) PushFlexMsg(ctx context.Context, uid string, flex message.Flex) error {
 	lineURL := "https://api.line.me/v2/bot/message/push"
 
 	msg := message.Push{
@@ -194,41 +223,10 @@ func (s *service) PushFlexMsg(uid string, flex message.Flex) error {
 		Message: flex,
 	}
 
-	req, err := http.NewRequest("POST", lineURL, bytes.NewBuffer(msg.ToJson()))
-	if err != nil {
-		return errors.Wrap(err, "[SendReplyFlexMsg]: unable to create request")
-	}
-	req.Header.Add("Authorization", s.newAuthHeader())
-	req.Header.Add("Content-Type", "application/json")
-
-	client := &http.Client{
-		Timeout: time.Second * defaultTimeout,
-	}
-	res, err := client.Do(req)
-	defer func() {
-		err := res.Body.Close()
-		if err != nil {
-			logrus.Warn("[SendReplyFlexMsg]: unable to close response body", err)
-		}
-	}()
+	_, err := makeRequest(ctx, s.httpClient, s.channelToken, http.MethodPost, lineURL, bytes.NewBuffer(msg.ToJson()))
 	if err != nil {
-		return errors.Wrap(err, "[SendReplyFlexMsg]: unable to make a success request")
-	}
-
-	if res.StatusCode < 200 || res.StatusCode > 299 {
-		logrus.Info(res.StatusCode)
-		body, err := ioutil.ReadAll(res.Body)
-		if err != nil {
-			logrus.Warn(err)
-		}
-
-		var b interface{}
-		err = json.Unmarshal(body, &b)
-		if err != nil {
-			logrus.Warn(err)
-		}
-		logrus.Info(b)
-		logrus.Info(flex.ToFlex())
+		logger.FromContext(ctx).WithError(err).WithField("flex", flex.ToFlex()).Debug("rejected flex message")
+		return errors.Wrap(err, "[PushFlexMsg]: unable to make a success request")
 	}
 
 	return nil
@@ -1,78 +1,172 @@
 package main
 
 import (
+	"context"
 	"net/http"
 	"os"
-	"strings"
+	"os/signal"
+	"syscall"
+	"time"
+	// cron specs may name a time zone, which the container image may not have data for
+	_ "time/tzdata"
 
 	"github.com/labstack/echo"
 	"github.com/labstack/echo/middleware"
-	"go.mongodb.org/mongo-driver/mongo"
+	"github.com/sirupsen/logrus"
 
+	"github.com/bbkbbbk/sapo/config"
 	"github.com/bbkbbbk/sapo/line"
+	"github.com/bbkbbbk/sapo/pkg/logger"
 	pkgMongo "github.com/bbkbbbk/sapo/pkg/mongo"
+	"github.com/bbkbbbk/sapo/pkg/scheduler"
+	"github.com/bbkbbbk/sapo/pkg/tracing"
 	"github.com/bbkbbbk/sapo/server"
 	"github.com/bbkbbbk/sapo/spotify"
 )
 
-var (
-	basedURL       string
-	db             *mongo.Database
-	lineService    line.Service
-	richMenu       line.RichMenuMetadata
-	spotifyService spotify.Service
-)
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "richmenu" {
+		if err := runRichMenu(os.Args[2:]); err != nil {
+			logrus.Fatal(err)
+		}
+		return
+	}
 
-func init() {
-	basedURL = os.Getenv("BASED_URL_APP")
-}
+	cfg, err := config.Load(os.Getenv("CONFIG_FILE"))
+	if err != nil {
+		logrus.Fatal(err)
+	}
 
-func init() {
-	db = pkgMongo.NewMongo(pkgMongo.Config{
-		AuthSource: os.Getenv("MONGO_AUTH_SOURCE"),
-		Database:   os.Getenv("MONGO_DATABASE"),
-		Host:       os.Getenv("MONGO_HOST"),
-		Username:   os.Getenv("MONGO_USERNAME"),
-		Password:   os.Getenv("MONGO_PASSWORD"),
+	if err := logger.Setup(cfg.Log.Level, cfg.Log.Format); err != nil {
+		logrus.Fatal(err)
+	}
+
+	shutdownTracing, err := tracing.Setup(tracing.Config{
+		Exporter:     cfg.Tracing.Exporter,
+		ServiceName:  cfg.Tracing.ServiceName,
+		OTLPEndpoint: cfg.Tracing.OTLPEndpoint,
+		OTLPInsecure: cfg.Tracing.OTLPInsecure,
 	})
-}
+	if err != nil {
+		logrus.Fatal(err)
+	}
 
-func init() {
-	richMenu = line.RichMenuMetadata{
-		Login:   os.Getenv("RICH_MENU_LOGIN"),
-		Default: os.Getenv("RICH_MENU_DEFAULT"),
+	db, err := pkgMongo.NewMongo(pkgMongo.Config{
+		AuthSource: cfg.Mongo.AuthSource,
+		Database:   cfg.Mongo.Database,
+		Host:       cfg.Mongo.Host,
+		Username:   cfg.Mongo.Username,
+		Password:   cfg.Mongo.Password,
+	})
+	if err != nil {
+		logrus.Fatal(err)
 	}
-}
 
-func init() {
-	lineService = line.NewLINEService(
-		os.Getenv("CHANNEL_SECRET"),
-		os.Getenv("CHANNEL_TOKEN"),
-		richMenu,
-	)
-}
+	richMenu := line.RichMenuMetadata{
+		Login:   cfg.LINE.RichMenuLogin,
+		Default: cfg.LINE.RichMenuDefault,
+	}
+	if cfg.LINE.RichMenuStateFile != "" {
+		st, err := line.LoadRichMenuState(cfg.LINE.RichMenuStateFile)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		richMenu, err = st.Metadata()
+		if err != nil {
+			logrus.Fatal(err)
+		}
+	}
+	lineService, err := line.NewLINEService(cfg.LINE.ChannelSecret, cfg.LINE.ChannelToken, richMenu)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	lineVerifier := line.NewIDTokenVerifier(cfg.LINE.LoginChannelID, cfg.LINE.IDTokenVerifyURL)
 
-func init() {
-	spotifyService = spotify.NewSpotifyService(
-		os.Getenv("MY_CLIENT_ID"),
-		os.Getenv("MY_CLIENT_SECRET"),
-		basedURL,
-	)
-}
+	spotifyService := spotify.NewSpotifyService(cfg.Spotify.ClientID, cfg.Spotify.ClientSecret, cfg.App.BasedURL)
 
-func main() {
 	e := echo.New()
+	e.HideBanner = true
+	e.Use(logger.Middleware())
+	e.Use(tracing.Middleware())
 	e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
-		AllowOrigins: strings.Split(os.Getenv("CORS_ALLOW_ORIGIN"), ","),
+		AllowOrigins: cfg.App.CORSAllowOrigins,
 		AllowHeaders: []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept},
 		AllowMethods: []string{http.MethodOptions, http.MethodGet, http.MethodPost, http.MethodPut},
 	}))
 
 	repository := server.NewRepository(db)
-	service := server.NewService(basedURL, lineService, spotifyService, repository)
-	serverHandler := server.NewHandler(service, os.Getenv("LIFF_LOGIN_CALLBACK_URL"))
+	if err := repository.EnsureIndexes(context.Background()); err != nil {
+		logrus.Fatal(err)
+	}
+	service := server.NewService(cfg.App.BasedURL, cfg.App.LIFFLoginURL, lineService, lineVerifier, spotifyService, repository)
+	queue := server.NewEventQueue(service, cfg.App.WebhookWorkers, cfg.App.WebhookQueueSize)
+	serverHandler := server.NewHandler(service, queue, cfg.App.LIFFLoginCallbackURL, cfg.Spotify.PKCE)
+	serverHandler.AddReadinessCheck("mongo", func(ctx context.Context) error {
+		return db.Client().Ping(ctx, nil)
+	})
+	// the rich menu state file is rewritten whenever the menus are synced, it must still name both menus users are linked to
+	serverHandler.AddReadinessCheck("config", func(ctx context.Context) error {
+		if err := cfg.Validate(); err != nil {
+			return err
+		}
+		if cfg.LINE.RichMenuStateFile == "" {
+			return nil
+		}
+		st, err := line.LoadRichMenuState(cfg.LINE.RichMenuStateFile)
+		if err != nil {
+			return err
+		}
+		_, err = st.Metadata()
+
+		return err
+	})
+
+	jobs := scheduler.NewScheduler(db)
+	if err := jobs.Register(context.Background(), server.JobWeeklyPlaylist, cfg.Scheduler.WeeklyPlaylist, service.DeliverWeeklyPlaylists); err != nil {
+		logrus.Fatal(err)
+	}
+	if err := jobs.Register(context.Background(), server.JobMonthlyRecap, cfg.Scheduler.MonthlyRecap, service.RunMonthlyRecap); err != nil {
+		logrus.Fatal(err)
+	}
+	if err := jobs.Register(context.Background(), server.JobIngestPlays, cfg.Scheduler.IngestPlays, service.IngestPlays); err != nil {
+		logrus.Fatal(err)
+	}
+	if cfg.Scheduler.Enabled {
+		jobs.Start()
+	}
+
 	server.RoutesRegister(e, serverHandler)
+	server.AdminRoutesRegister(e, server.NewAdminHandler(service, jobs), cfg.App.AdminToken)
 
-	port := ":" + os.Getenv("APP_PORT")
-	e.Logger.Fatal(e.Start(port))
+	go func() {
+		port := ":" + cfg.App.Port
+		if err := e.Start(port); err != nil && err != http.ErrServerClosed {
+			logrus.Fatal(err)
+		}
+	}()
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+	logrus.Info("shutting down")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*time.Duration(cfg.App.ShutdownTimeout))
+	defer cancel()
+
+	if err := e.Shutdown(ctx); err != nil {
+		logrus.WithError(err).Error("unable to shut down server")
+	}
+	if err := queue.Shutdown(ctx); err != nil {
+		logrus.WithError(err).Error("unable to drain webhook queue")
+	}
+	// manual runs are started from the admin api even when the scheduler is disabled
+	if err := jobs.Shutdown(ctx); err != nil {
+		logrus.WithError(err).Error("unable to stop scheduler")
+	}
+	if err := db.Client().Disconnect(ctx); err != nil {
+		logrus.WithError(err).Error("unable to disconnect mongo")
+	}
+	if err := shutdownTracing(ctx); err != nil {
+		logrus.WithError(err).Error("unable to flush traces")
+	}
 }
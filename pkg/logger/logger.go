@@ -0,0 +1,117 @@
+package logger
+
+import (
+	"context"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/labstack/echo/middleware"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+
+	FieldRequestID       = "request_id"
+	FieldTraceID         = "trace_id"
+	FieldLINEUID         = "line_uid"
+	FieldCommand         = "command"
+	FieldJob             = "job"
+	FieldSpotifyEndpoint = "spotify_endpoint"
+	FieldLINEEndpoint    = "line_endpoint"
+	FieldLatency         = "latency"
+	FieldStatus          = "status"
+	FieldMethod          = "method"
+	FieldPath            = "path"
+)
+
+type contextKey struct{}
+
+// Setup configures the global logger every request scoped logger derives from
+func Setup(level, format string) error {
+	lvl, err := logrus.ParseLevel(level)
+	if err != nil {
+		return errors.Wrapf(err, "[Setup]: invalid log level %s", level)
+	}
+	logrus.SetLevel(lvl)
+
+	switch format {
+	case FormatJSON:
+		logrus.SetFormatter(&logrus.JSONFormatter{})
+	case FormatText:
+		logrus.SetFormatter(&logrus.TextFormatter{FullTimestamp: true})
+	default:
+		return errors.Errorf("[Setup]: invalid log format %s", format)
+	}
+
+	return nil
+}
+
+// FromContext returns the logger carried by ctx, or the global logger when there is none
+func FromContext(ctx context.Context) *logrus.Entry {
+	if ctx != nil {
+		if entry, ok := ctx.Value(contextKey{}).(*logrus.Entry); ok {
+			return entry
+		}
+	}
+
+	return logrus.NewEntry(logrus.StandardLogger())
+}
+
+func WithLogger(ctx context.Context, entry *logrus.Entry) context.Context {
+	return context.WithValue(ctx, contextKey{}, entry)
+}
+
+// WithFields returns a context whose logger has fields added to the ones already in ctx
+func WithFields(ctx context.Context, fields logrus.Fields) context.Context {
+	return WithLogger(ctx, FromContext(ctx).WithFields(fields))
+}
+
+func WithField(ctx context.Context, key string, value interface{}) context.Context {
+	return WithLogger(ctx, FromContext(ctx).WithField(key, value))
+}
+
+// Detach keeps the logger of ctx but drops its deadline and cancellation,
+// for work that continues after the request that started it has been answered
+func Detach(ctx context.Context) context.Context {
+	return WithLogger(context.Background(), FromContext(ctx))
+}
+
+// Middleware tags every request with a request id, puts a logger carrying it into the request context
+// and logs the request once it is handled. It answers a failed request through the echo error handler,
+// so it has to be the outermost middleware and every middleware inside it must return the handler error.
+func Middleware() echo.MiddlewareFunc {
+	requestID := middleware.RequestID()
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return requestID(func(c echo.Context) error {
+			start := time.Now()
+			req := c.Request()
+
+			id := c.Response().Header().Get(echo.HeaderXRequestID)
+			ctx := WithField(req.Context(), FieldRequestID, id)
+			c.SetRequest(req.WithContext(ctx))
+
+			err := next(c)
+			if err != nil {
+				c.Error(err)
+			}
+
+			entry := FromContext(ctx).WithFields(logrus.Fields{
+				FieldMethod:  req.Method,
+				FieldPath:    c.Path(),
+				FieldStatus:  c.Response().Status,
+				FieldLatency: time.Since(start).Milliseconds(),
+			})
+			if err != nil {
+				entry.WithError(err).Warn("request failed")
+			} else {
+				entry.Info("request handled")
+			}
+
+			return nil
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.mongodb.org/mongo-driver/event"
+)
+
+const (
+	namespace = "sapo"
+
+	OutcomeSuccess = "success"
+	OutcomeError   = "error"
+)
+
+var (
+	WebhookEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webhook_events_total",
+		Help:      "LINE webhook events received, by event type.",
+	}, []string{"type"})
+
+	Commands = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "commands_total",
+		Help:      "Bot commands handled, by command name and outcome.",
+	}, []string{"command", "outcome"})
+
+	CommandDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "command_duration_seconds",
+		Help:      "Time taken to handle a bot command, by command name.",
+		Buckets:   []float64{0.1, 0.25, 0.5, 1, 2, 4, 8, 16},
+	}, []string{"command"})
+
+	OutboundRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "outbound_requests_total",
+		Help:      "Requests sent to Spotify and LINE, by service, endpoint and response status.",
+	}, []string{"service", "endpoint", "status"})
+
+	OutboundRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "outbound_request_duration_seconds",
+		Help:      "Latency of requests sent to Spotify and LINE, by service and endpoint.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"service", "endpoint"})
+
+	TokenRefreshes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "spotify_token_refreshes_total",
+		Help:      "Spotify access tokens requested from refresh tokens, by outcome.",
+	}, []string{"outcome"})
+
+	MongoOperationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "mongo_operation_duration_seconds",
+		Help:      "Latency of mongo commands, by command name and outcome.",
+		Buckets:   []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1},
+	}, []string{"command", "outcome"})
+)
+
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+func Outcome(err error) string {
+	if err != nil {
+		return OutcomeError
+	}
+
+	return OutcomeSuccess
+}
+
+// EndpointNamer names the endpoint of a request, it must keep the number of distinct names small
+type EndpointNamer func(req *http.Request) string
+
+type transport struct {
+	service  string
+	endpoint EndpointNamer
+	next     http.RoundTripper
+}
+
+// NewTransport counts and times every request sent through next as a request to service
+func NewTransport(service string, endpoint EndpointNamer, next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	return &transport{
+		service:  service,
+		endpoint: endpoint,
+		next:     next,
+	}
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	endpoint := t.endpoint(req)
+
+	res, err := t.next.RoundTrip(req)
+
+	status := OutcomeError
+	if err == nil {
+		status = strconv.Itoa(res.StatusCode)
+	}
+	OutboundRequests.WithLabelValues(t.service, endpoint, status).Inc()
+	OutboundRequestDuration.WithLabelValues(t.service, endpoint).Observe(time.Since(start).Seconds())
+
+	return res, err
+}
+
+// NewMongoMonitor observes the latency of every command sent to mongo
+func NewMongoMonitor() *event.CommandMonitor {
+	return &event.CommandMonitor{
+		Succeeded: func(ctx context.Context, e *event.CommandSucceededEvent) {
+			MongoOperationDuration.WithLabelValues(e.CommandName, OutcomeSuccess).Observe(time.Duration(e.DurationNanos).Seconds())
+		},
+		Failed: func(ctx context.Context, e *event.CommandFailedEvent) {
+			MongoOperationDuration.WithLabelValues(e.CommandName, OutcomeError).Observe(time.Duration(e.DurationNanos).Seconds())
+		},
+	}
+}
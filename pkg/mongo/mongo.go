@@ -3,15 +3,20 @@ package mongo
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/event"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/bbkbbbk/sapo/pkg/metrics"
+	"github.com/bbkbbbk/sapo/pkg/tracing"
 )
 
-var (
-	errorUnableToConnectToDatabase = errors.New("unable to connect database")
+const (
+	defaultConnectTimeout = 10
 )
 
 type Config struct {
@@ -22,16 +27,54 @@ type Config struct {
 	Password   string
 }
 
-func NewMongo(c Config) *mongo.Database {
-	logrus.Print("[main]: initializing mongo connection")
+func NewMongo(c Config) (*mongo.Database, error) {
+	logrus.WithField("host", c.Host).Info("initializing mongo connection")
 
 	uri := fmt.Sprintf("mongodb://%s:%s@%s:27017/?authSource=%v", c.Username, c.Password, c.Host, c.AuthSource)
 
-	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(uri))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*defaultConnectTimeout)
+	defer cancel()
+
+	opts := options.Client().
+		ApplyURI(uri).
+		SetMonitor(combineMonitors(metrics.NewMongoMonitor(), tracing.NewMongoMonitor()))
+
+	client, err := mongo.Connect(ctx, opts)
 	if err != nil {
-		logrus.Warn(errorUnableToConnectToDatabase)
+		return nil, errors.Wrap(err, "[NewMongo]: unable to connect database")
+	}
+
+	if err := client.Ping(ctx, nil); err != nil {
+		return nil, errors.Wrap(err, "[NewMongo]: unable to ping database")
 	}
 	db := client.Database(c.Database)
 
-	return db
+	return db, nil
+}
+
+// combineMonitors fans every command event out to monitors, the driver only accepts a single one
+func combineMonitors(monitors ...*event.CommandMonitor) *event.CommandMonitor {
+	return &event.CommandMonitor{
+		Started: func(ctx context.Context, e *event.CommandStartedEvent) {
+			for _, m := range monitors {
+				if m.Started != nil {
+					m.Started(ctx, e)
+				}
+			}
+		},
+		Succeeded: func(ctx context.Context, e *event.CommandSucceededEvent) {
+			for _, m := range monitors {
+				if m.Succeeded != nil {
+					m.Succeeded(ctx, e)
+				}
+			}
+		},
+		Failed: func(ctx context.Context, e *event.CommandFailedEvent) {
+			for _, m := range monitors {
+				if m.Failed != nil {
+					m.Failed(ctx, e)
+				}
+			}
+		},
+	}
 }
@@ -0,0 +1,450 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/robfig/cron/v3"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/bbkbbbk/sapo/pkg/logger"
+	"github.com/bbkbbbk/sapo/pkg/tracing"
+)
+
+const (
+	collNameJobs  = "jobs"
+	collNameLocks = "locks"
+
+	leaderLockID        = "scheduler"
+	duplicateKeyCode    = 11000
+	defaultTickInterval = 15 * time.Second
+	defaultLeaseTTL     = time.Minute
+	defaultJobTimeout   = 30 * time.Minute
+	// cancelGracePeriod is how long Shutdown still waits for cancelled jobs to record their outcome
+	cancelGracePeriod = 5 * time.Second
+	recordTimeout     = 10 * time.Second
+)
+
+var (
+	ErrorUnknownJob = errors.New("unknown job")
+	ErrorJobRunning = errors.New("job is already running")
+)
+
+// Handler is the work a job does each time it is due
+type Handler func(ctx context.Context) error
+
+// Job is the persisted state of a registered job
+type Job struct {
+	Name      string     `json:"name" bson:"_id"`
+	Schedule  string     `json:"schedule" bson:"schedule"`
+	NextRunAt time.Time  `json:"nextRunAt" bson:"nextRunAt"`
+	LastRunAt *time.Time `json:"lastRunAt,omitempty" bson:"lastRunAt,omitempty"`
+	LastError string     `json:"lastError,omitempty" bson:"lastError,omitempty"`
+	// RunningUntil is set while the job runs and expires with the job timeout in case the replica running it dies
+	RunningUntil *time.Time `json:"runningUntil,omitempty" bson:"runningUntil,omitempty"`
+}
+
+type job struct {
+	schedule cron.Schedule
+	handler  Handler
+}
+
+// Scheduler runs jobs on cron schedules. Job state lives in mongo so a restart does not skip or repeat a run,
+// and replicas compete for a leader lock so only one of them runs jobs at a time.
+type Scheduler struct {
+	db       *mongo.Database
+	owner    string
+	tick     time.Duration
+	leaseTTL time.Duration
+
+	mu   sync.Mutex
+	jobs map[string]job
+
+	// ctx is cancelled when Shutdown gives up waiting, every run derives from it
+	ctx     context.Context
+	cancel  context.CancelFunc
+	started bool
+	stop    chan struct{}
+	done    chan struct{}
+	manual  sync.WaitGroup
+}
+
+func NewScheduler(db *mongo.Database) *Scheduler {
+	hostname, _ := os.Hostname()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Scheduler{
+		db:       db,
+		owner:    fmt.Sprintf("%s-%d-%d", hostname, os.Getpid(), time.Now().UnixNano()),
+		tick:     defaultTickInterval,
+		leaseTTL: defaultLeaseTTL,
+		jobs:     map[string]job{},
+		ctx:      ctx,
+		cancel:   cancel,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Register adds a job running handler on a standard cron spec, e.g. "CRON_TZ=Asia/Bangkok 0 9 * * 1".
+// The next run is rescheduled when spec differs from the one the job was persisted with.
+func (s *Scheduler) Register(ctx context.Context, name, spec string, handler Handler) error {
+	schedule, err := cron.ParseStandard(spec)
+	if err != nil {
+		return errors.Wrapf(err, "[Register]: invalid schedule %q for job %s", spec, name)
+	}
+
+	var existing Job
+	err = s.db.Collection(collNameJobs).FindOne(ctx, bson.M{"_id": name}).Decode(&existing)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return errors.Wrapf(err, "[Register]: unable to find job %s", name)
+	}
+
+	if err == mongo.ErrNoDocuments || existing.Schedule != spec {
+		update := bson.M{
+			"$set": bson.M{
+				"schedule":  spec,
+				"nextRunAt": schedule.Next(time.Now()),
+			},
+		}
+		opts := options.Update().SetUpsert(true)
+		if _, err := s.db.Collection(collNameJobs).UpdateOne(ctx, bson.M{"_id": name}, update, opts); err != nil {
+			return errors.Wrapf(err, "[Register]: unable to save job %s", name)
+		}
+	}
+
+	s.mu.Lock()
+	s.jobs[name] = job{schedule: schedule, handler: handler}
+	s.mu.Unlock()
+
+	return nil
+}
+
+// Jobs returns the persisted state of every job
+func (s *Scheduler) Jobs(ctx context.Context) ([]Job, error) {
+	cursor, err := s.db.Collection(collNameJobs).Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, errors.Wrap(err, "[Jobs]: unable to find jobs")
+	}
+
+	jobs := []Job{}
+	if err := cursor.All(ctx, &jobs); err != nil {
+		return nil, errors.Wrap(err, "[Jobs]: unable to decode jobs")
+	}
+
+	return jobs, nil
+}
+
+// RunNow starts a registered job in the background on this replica, regardless of its schedule and the leader lock.
+// The run is detached from ctx, only its logger is kept, and its outcome is recorded on the job like a scheduled run.
+// Shutdown waits for it, or cancels it, like a scheduled run.
+// ErrorJobRunning is returned when a scheduled or another manual run of the job has not finished yet
+func (s *Scheduler) RunNow(ctx context.Context, name string) error {
+	s.mu.Lock()
+	j, ok := s.jobs[name]
+	s.mu.Unlock()
+	if !ok {
+		return errors.Wrapf(ErrorUnknownJob, "[RunNow]: job %s", name)
+	}
+
+	running, err := s.markRunning(ctx, name)
+	if err != nil {
+		return errors.Wrap(err, "[RunNow]: unable to mark job as running")
+	}
+	if !running {
+		return errors.Wrapf(ErrorJobRunning, "[RunNow]: job %s", name)
+	}
+
+	ctx = logger.WithLogger(s.ctx, logger.FromContext(ctx))
+	s.manual.Add(1)
+	go func() {
+		defer s.manual.Done()
+		if err := s.execute(ctx, name, j); err != nil {
+			logger.FromContext(ctx).WithError(err).WithField(logger.FieldJob, name).Error("job failed")
+		}
+	}()
+
+	return nil
+}
+
+// Start checks for due jobs every tick until Shutdown is called
+func (s *Scheduler) Start() {
+	ctx := s.ctx
+	s.started = true
+
+	go func() {
+		defer close(s.done)
+
+		ticker := time.NewTicker(s.tick)
+		defer ticker.Stop()
+
+		for {
+			s.runDueJobs(ctx)
+
+			select {
+			case <-ticker.C:
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Shutdown stops checking for due jobs, waits for the running scheduled and manual jobs to finish
+// and gives up the leader lock. Jobs still running when ctx is done are cancelled and given
+// a short grace period to record their outcome, so they do not stay marked as running.
+func (s *Scheduler) Shutdown(ctx context.Context) error {
+	close(s.stop)
+
+	finished := make(chan struct{})
+	go func() {
+		if s.started {
+			<-s.done
+		}
+		s.manual.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+	case <-ctx.Done():
+		s.cancel()
+		select {
+		case <-finished:
+		case <-time.After(cancelGracePeriod):
+		}
+		return errors.Wrap(ctx.Err(), "[Shutdown]: running jobs did not finish in time")
+	}
+	s.cancel()
+
+	_, err := s.db.Collection(collNameLocks).DeleteOne(ctx, bson.M{"_id": leaderLockID, "owner": s.owner})
+	if err != nil {
+		return errors.Wrap(err, "[Shutdown]: unable to release leader lock")
+	}
+
+	return nil
+}
+
+func (s *Scheduler) runDueJobs(ctx context.Context) {
+	log := logger.FromContext(ctx)
+
+	leader, err := s.acquireLeaderLock(ctx)
+	if err != nil {
+		log.WithError(err).Warn("unable to acquire scheduler leader lock")
+		return
+	}
+	if !leader {
+		return
+	}
+
+	s.mu.Lock()
+	jobs := make(map[string]job, len(s.jobs))
+	for name, j := range s.jobs {
+		jobs[name] = j
+	}
+	s.mu.Unlock()
+
+	for name, j := range jobs {
+		claimed, err := s.claim(ctx, name, j)
+		if err != nil {
+			log.WithError(err).WithField(logger.FieldJob, name).Warn("unable to claim job")
+			continue
+		}
+		if !claimed {
+			continue
+		}
+
+		stopRenewing := s.renewLeaderLock(ctx)
+		err = s.run(ctx, name, j)
+		stopRenewing()
+		if errors.Cause(err) == ErrorJobRunning {
+			log.WithField(logger.FieldJob, name).Info("job skipped, a manual run has not finished yet")
+			continue
+		}
+		if err != nil {
+			log.WithError(err).WithField(logger.FieldJob, name).Error("job failed")
+		}
+	}
+}
+
+// renewLeaderLock keeps renewing the lease in the background while a job runs longer than the lease,
+// calling the returned func stops renewing
+func (s *Scheduler) renewLeaderLock(ctx context.Context) func() {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(s.leaseTTL / 3)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				leader, err := s.acquireLeaderLock(ctx)
+				if err != nil {
+					logger.FromContext(ctx).WithError(err).Warn("unable to renew scheduler leader lock")
+				} else if !leader {
+					logger.FromContext(ctx).Warn("scheduler leader lock taken over while a job is running")
+				}
+			case <-stop:
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-done
+	}
+}
+
+// claim moves the next run of a due job forward before it runs, so a replica taking over the leader lock
+// while the job is still running does not run it a second time
+func (s *Scheduler) claim(ctx context.Context, name string, j job) (bool, error) {
+	now := time.Now()
+	filter := bson.M{
+		"_id":       name,
+		"nextRunAt": bson.M{"$lte": now},
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"nextRunAt": j.schedule.Next(now),
+		},
+	}
+
+	res, err := s.db.Collection(collNameJobs).UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, errors.Wrapf(err, "[claim]: unable to update job %s", name)
+	}
+
+	return res.ModifiedCount == 1, nil
+}
+
+// markRunning sets the job as running unless a run that has not timed out yet already did,
+// so a manual run never overlaps a scheduled one on any replica
+func (s *Scheduler) markRunning(ctx context.Context, name string) (bool, error) {
+	now := time.Now()
+	filter := bson.M{
+		"_id": name,
+		"$or": bson.A{
+			bson.M{"runningUntil": bson.M{"$exists": false}},
+			bson.M{"runningUntil": bson.M{"$lt": now}},
+		},
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"runningUntil": now.Add(defaultJobTimeout),
+		},
+	}
+
+	res, err := s.db.Collection(collNameJobs).UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, errors.Wrapf(err, "[markRunning]: unable to update job %s", name)
+	}
+
+	return res.ModifiedCount == 1, nil
+}
+
+// run runs the job and records the outcome, ErrorJobRunning is returned without running it when it is already running
+func (s *Scheduler) run(ctx context.Context, name string, j job) error {
+	running, err := s.markRunning(ctx, name)
+	if err != nil {
+		return errors.Wrap(err, "[run]: unable to mark job as running")
+	}
+	if !running {
+		return errors.Wrapf(ErrorJobRunning, "[run]: job %s", name)
+	}
+
+	return s.execute(ctx, name, j)
+}
+
+// execute runs a job already marked as running, within the job timeout, and records the outcome
+func (s *Scheduler) execute(ctx context.Context, name string, j job) error {
+	start := time.Now()
+	ctx = logger.WithField(ctx, logger.FieldJob, name)
+	log := logger.FromContext(ctx)
+	log.Info("job started")
+
+	runCtx, cancel := context.WithTimeout(ctx, defaultJobTimeout)
+	runCtx, span := tracing.Start(runCtx, fmt.Sprintf("job %s", name))
+	err := j.handler(runCtx)
+	tracing.End(span, err)
+	cancel()
+
+	set := bson.M{
+		"lastRunAt": start,
+		"lastError": "",
+	}
+	if err != nil {
+		set["lastError"] = err.Error()
+	}
+	update := bson.M{
+		"$set":   set,
+		"$unset": bson.M{"runningUntil": ""},
+	}
+	// a cancelled run is recorded too, otherwise it would stay marked as running until the job timeout
+	recordCtx, cancel := context.WithTimeout(logger.Detach(ctx), recordTimeout)
+	defer cancel()
+	if _, updateErr := s.db.Collection(collNameJobs).UpdateOne(recordCtx, bson.M{"_id": name}, update); updateErr != nil {
+		log.WithError(updateErr).Warn("unable to record job run")
+	}
+
+	log.WithField(logger.FieldLatency, time.Since(start).Milliseconds()).Info("job finished")
+
+	return err
+}
+
+// acquireLeaderLock takes over the lock when it expired or renews it when this replica holds it,
+// another replica holding a live lock makes the upsert collide with its document
+func (s *Scheduler) acquireLeaderLock(ctx context.Context) (bool, error) {
+	now := time.Now()
+	filter := bson.M{
+		"_id": leaderLockID,
+		"$or": bson.A{
+			bson.M{"owner": s.owner},
+			bson.M{"expiresAt": bson.M{"$lt": now}},
+		},
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"owner":     s.owner,
+			"expiresAt": now.Add(s.leaseTTL),
+		},
+	}
+	opts := options.Update().SetUpsert(true)
+
+	_, err := s.db.Collection(collNameLocks).UpdateOne(ctx, filter, update, opts)
+	if isDuplicateKeyError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrap(err, "[acquireLeaderLock]: unable to update lock")
+	}
+
+	return true, nil
+}
+
+func isDuplicateKeyError(err error) bool {
+	switch e := err.(type) {
+	case mongo.WriteException:
+		for _, we := range e.WriteErrors {
+			if we.Code == duplicateKeyCode {
+				return true
+			}
+		}
+	case mongo.CommandError:
+		return e.Code == duplicateKeyCode
+	}
+
+	return false
+}
@@ -0,0 +1,184 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// testMongoURIEnv names the mongo the tests run against, they are skipped without it
+const testMongoURIEnv = "SAPO_TEST_MONGO_URI"
+
+// newTestScheduler returns a scheduler on a database of its own, dropped once the test ends
+func newTestScheduler(t *testing.T) *Scheduler {
+	t.Helper()
+
+	uri := os.Getenv(testMongoURIEnv)
+	if uri == "" {
+		t.Skipf("%s is not set", testMongoURIEnv)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := client.Database(fmt.Sprintf("sapo_scheduler_test_%d", time.Now().UnixNano()))
+	t.Cleanup(func() {
+		_ = db.Drop(context.Background())
+		_ = client.Disconnect(context.Background())
+	})
+
+	return NewScheduler(db)
+}
+
+func setJob(t *testing.T, s *Scheduler, name string, set bson.M) {
+	t.Helper()
+
+	opts := options.Update().SetUpsert(true)
+	if _, err := s.db.Collection(collNameJobs).UpdateOne(context.Background(), bson.M{"_id": name}, bson.M{"$set": set}, opts); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClaim(t *testing.T) {
+	s := newTestScheduler(t)
+	ctx := context.Background()
+	schedule, err := cron.ParseStandard("0 9 * * 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	j := job{schedule: schedule}
+
+	setJob(t, s, "due", bson.M{"nextRunAt": time.Now().Add(-time.Minute)})
+	setJob(t, s, "not-due", bson.M{"nextRunAt": time.Now().Add(time.Hour)})
+
+	tests := []struct {
+		name    string
+		job     string
+		claimed bool
+	}{
+		{"due job", "due", true},
+		{"due job claimed already", "due", false},
+		{"job not due yet", "not-due", false},
+		{"unknown job", "unknown", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claimed, err := s.claim(ctx, tt.job, j)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if claimed != tt.claimed {
+				t.Errorf("claim() = %v, want %v", claimed, tt.claimed)
+			}
+		})
+	}
+}
+
+func TestMarkRunning(t *testing.T) {
+	s := newTestScheduler(t)
+	ctx := context.Background()
+
+	setJob(t, s, "idle", bson.M{"schedule": "0 9 * * 1"})
+	setJob(t, s, "running", bson.M{"runningUntil": time.Now().Add(time.Hour)})
+	setJob(t, s, "timed-out", bson.M{"runningUntil": time.Now().Add(-time.Minute)})
+
+	tests := []struct {
+		name    string
+		job     string
+		running bool
+	}{
+		{"idle job", "idle", true},
+		{"idle job marked already", "idle", false},
+		{"job still running", "running", false},
+		{"run that timed out", "timed-out", true},
+		{"unknown job", "unknown", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			running, err := s.markRunning(ctx, tt.job)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if running != tt.running {
+				t.Errorf("markRunning() = %v, want %v", running, tt.running)
+			}
+		})
+	}
+}
+
+func TestAcquireLeaderLock(t *testing.T) {
+	leader := newTestScheduler(t)
+	other := NewScheduler(leader.db)
+	ctx := context.Background()
+
+	acquire := func(s *Scheduler, want bool) {
+		t.Helper()
+		got, err := s.acquireLeaderLock(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("acquireLeaderLock() = %v, want %v", got, want)
+		}
+	}
+
+	acquire(leader, true)
+	acquire(other, false)
+	// renewing keeps the lock
+	acquire(leader, true)
+	acquire(other, false)
+
+	// a lease renewed already expired lets the other replica take the lock over
+	leader.leaseTTL = -time.Second
+	acquire(leader, true)
+	acquire(other, true)
+	acquire(leader, false)
+}
+
+func TestShutdownCancelsManualRun(t *testing.T) {
+	s := newTestScheduler(t)
+	ctx := context.Background()
+
+	started := make(chan struct{})
+	handler := func(ctx context.Context) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	if err := s.Register(ctx, "manual", "0 9 * * 1", handler); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RunNow(ctx, "manual"); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+
+	shutdownCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	if err := s.Shutdown(shutdownCtx); err == nil {
+		t.Error("Shutdown() = nil, want the run not finishing in time")
+	}
+
+	var j Job
+	if err := s.db.Collection(collNameJobs).FindOne(ctx, bson.M{"_id": "manual"}).Decode(&j); err != nil {
+		t.Fatal(err)
+	}
+	if j.RunningUntil != nil {
+		t.Errorf("RunningUntil = %v, want the cancelled run no longer marked as running", j.RunningUntil)
+	}
+	if j.LastError == "" {
+		t.Error("LastError is empty, want the cancelled run recorded")
+	}
+}
@@ -0,0 +1,182 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/labstack/echo"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/event"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/bbkbbbk/sapo/pkg/logger"
+)
+
+const (
+	ExporterNone   = "none"
+	ExporterStdout = "stdout"
+	ExporterOTLP   = "otlp"
+
+	instrumentationName = "github.com/bbkbbbk/sapo"
+)
+
+type Config struct {
+	Exporter     string
+	ServiceName  string
+	OTLPEndpoint string
+	OTLPInsecure bool
+}
+
+// Setup installs the global tracer provider for the configured exporter and returns a func flushing pending spans.
+// With ExporterNone the global no-op provider is kept, so every span started through this package costs nothing.
+func Setup(c Config) (func(ctx context.Context) error, error) {
+	var exporter sdktrace.SpanExporter
+	var err error
+
+	switch c.Exporter {
+	case ExporterNone, "":
+		return func(ctx context.Context) error { return nil }, nil
+	case ExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout), stdouttrace.WithPrettyPrint())
+	case ExporterOTLP:
+		opts := []otlptracehttp.Option{}
+		if c.OTLPEndpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpoint(c.OTLPEndpoint))
+		}
+		if c.OTLPInsecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		exporter, err = otlptracehttp.New(context.Background(), opts...)
+	default:
+		return nil, errors.Errorf("[Setup]: unknown exporter %s", c.Exporter)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "[Setup]: unable to create %s exporter", c.Exporter)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceNameKey.String(c.ServiceName),
+		)),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	return provider.Shutdown, nil
+}
+
+// Start starts a span named name as a child of the span in ctx
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End records err on span, if any, and ends it
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Middleware starts a server span for every request, continuing the trace of the caller if it sent one,
+// and adds the trace id to the request logger so log lines can be matched to traces.
+func Middleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			ctx := otel.GetTextMapPropagator().Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+
+			name := fmt.Sprintf("%s %s", req.Method, c.Path())
+			ctx, span := otel.Tracer(instrumentationName).Start(ctx, name,
+				trace.WithSpanKind(trace.SpanKindServer),
+				trace.WithAttributes(semconv.HTTPServerAttributesFromHTTPRequest("sapo", c.Path(), req)...),
+			)
+			defer span.End()
+
+			if sc := span.SpanContext(); sc.IsValid() {
+				ctx = logger.WithField(ctx, logger.FieldTraceID, sc.TraceID().String())
+			}
+			c.SetRequest(req.WithContext(ctx))
+
+			// the error is left for the outermost middleware to handle, so the status it will be answered with is derived here
+			err := next(c)
+			status := c.Response().Status
+			if err != nil {
+				span.RecordError(err)
+				status = http.StatusInternalServerError
+				if he, ok := err.(*echo.HTTPError); ok {
+					status = he.Code
+				}
+			}
+
+			span.SetAttributes(semconv.HTTPStatusCodeKey.Int(status))
+			spanStatus, msg := semconv.SpanStatusFromHTTPStatusCode(status)
+			span.SetStatus(spanStatus, msg)
+
+			return err
+		}
+	}
+}
+
+// NewTransport creates a client span for every request sent through next to service, named after its endpoint
+func NewTransport(service string, endpoint func(*http.Request) string, next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	return otelhttp.NewTransport(next,
+		otelhttp.WithSpanNameFormatter(func(operation string, req *http.Request) string {
+			return fmt.Sprintf("%s %s", service, endpoint(req))
+		}),
+		otelhttp.WithSpanOptions(trace.WithAttributes(semconv.PeerServiceKey.String(service))),
+	)
+}
+
+// NewMongoMonitor creates a client span for every command sent to mongo
+func NewMongoMonitor() *event.CommandMonitor {
+	var spans sync.Map
+
+	end := func(requestID int64, err error) {
+		span, ok := spans.LoadAndDelete(requestID)
+		if !ok {
+			return
+		}
+		End(span.(trace.Span), err)
+	}
+
+	return &event.CommandMonitor{
+		Started: func(ctx context.Context, e *event.CommandStartedEvent) {
+			_, span := otel.Tracer(instrumentationName).Start(ctx, fmt.Sprintf("mongo %s", e.CommandName),
+				trace.WithSpanKind(trace.SpanKindClient),
+				trace.WithAttributes(
+					semconv.DBSystemMongoDB,
+					semconv.DBNameKey.String(e.DatabaseName),
+					semconv.DBOperationKey.String(e.CommandName),
+				),
+			)
+			spans.Store(e.RequestID, span)
+		},
+		Succeeded: func(ctx context.Context, e *event.CommandSucceededEvent) {
+			end(e.RequestID, nil)
+		},
+		Failed: func(ctx context.Context, e *event.CommandFailedEvent) {
+			end(e.RequestID, errors.New(e.Failure))
+		},
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+
+	"github.com/pkg/errors"
+
+	"github.com/bbkbbbk/sapo/config"
+	"github.com/bbkbbbk/sapo/line"
+)
+
+const (
+	defaultRichMenuDefinitions = "richmenu/richmenu.yaml"
+	defaultRichMenuState       = "richmenu/richmenu.state.json"
+)
+
+// runRichMenu creates the rich menus declared in the definitions file and writes their ids to the state file
+func runRichMenu(args []string) error {
+	flags := flag.NewFlagSet("richmenu", flag.ExitOnError)
+	definitionsPath := flags.String("definitions", defaultRichMenuDefinitions, "rich menu definitions file, JSON or YAML")
+	statePath := flags.String("state", "", "file the created rich menu ids are written to, defaults to RICH_MENU_STATE_FILE")
+	prune := flags.Bool("prune", false, "delete rich menus replaced by a changed definition, users linked to them are unlinked")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := config.Read(os.Getenv("CONFIG_FILE"))
+	if err != nil {
+		return err
+	}
+	if cfg.LINE.ChannelSecret == "" || cfg.LINE.ChannelToken == "" {
+		return errors.New("[runRichMenu]: CHANNEL_SECRET and CHANNEL_TOKEN are required")
+	}
+
+	if *statePath == "" {
+		*statePath = cfg.LINE.RichMenuStateFile
+	}
+	if *statePath == "" {
+		*statePath = defaultRichMenuState
+	}
+
+	defs, err := line.LoadRichMenuDefinitions(*definitionsPath)
+	if err != nil {
+		return err
+	}
+
+	st, err := line.LoadRichMenuState(*statePath)
+	if err != nil {
+		return err
+	}
+
+	syncer, err := line.NewRichMenuSyncer(cfg.LINE.ChannelSecret, cfg.LINE.ChannelToken, *prune)
+	if err != nil {
+		return err
+	}
+
+	// save whatever was created even when a later menu fails, so a rerun does not create it again
+	syncErr := syncer.Sync(context.Background(), defs, st)
+	if err := st.Save(*statePath); err != nil {
+		return err
+	}
+
+	return syncErr
+}
@@ -0,0 +1,239 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/bbkbbbk/sapo/line"
+	"github.com/bbkbbbk/sapo/pkg/logger"
+	"github.com/bbkbbbk/sapo/pkg/scheduler"
+)
+
+const (
+	defaultAdminPageSize = 20
+	maxAdminPageSize     = 100
+	defaultStatsPeriod   = 24 * time.Hour
+
+	richMenuLogin   = "login"
+	richMenuDefault = "default"
+)
+
+var (
+	errorInvalidPagination = errors.New("invalid pagination")
+	errorInvalidPushText   = errors.New("invalid push message text")
+	errorInvalidRichMenu   = errors.New("invalid rich menu, must be login or default or an alias")
+	errorAccountNotFound   = errors.New("account not found")
+	errorInvalidPeriod     = errors.New("invalid since, must be a positive duration such as 24h")
+)
+
+type AdminHandler struct {
+	service   Service
+	scheduler JobScheduler
+}
+
+// JobScheduler is the part of the scheduler the admin api inspects and triggers jobs through
+type JobScheduler interface {
+	Jobs(ctx context.Context) ([]scheduler.Job, error)
+	RunNow(ctx context.Context, name string) error
+}
+
+type requestPushMessage struct {
+	Text string `json:"text"`
+}
+
+// requestRelinkRichMenu links either the login or default menu, or the tab behind a rich menu alias
+type requestRelinkRichMenu struct {
+	Menu  string `json:"menu"`
+	Alias string `json:"alias"`
+}
+
+func NewAdminHandler(s Service, jobs JobScheduler) AdminHandler {
+	return AdminHandler{
+		service:   s,
+		scheduler: jobs,
+	}
+}
+
+func (h *AdminHandler) returnError(c echo.Context, status int, err error) error {
+	logger.FromContext(c.Request().Context()).WithError(err).Error("admin request failed")
+	return echo.NewHTTPError(status, errors.Cause(err).Error())
+}
+
+func (h *AdminHandler) accountError(c echo.Context, err error) error {
+	if errors.Cause(err) == mongo.ErrNoDocuments {
+		return h.returnError(c, http.StatusNotFound, errors.Wrap(errorAccountNotFound, err.Error()))
+	}
+
+	return h.returnError(c, http.StatusInternalServerError, err)
+}
+
+func (h *AdminHandler) intQueryParam(c echo.Context, name string, fallback int) (int, error) {
+	param := c.QueryParam(name)
+	if param == "" {
+		return fallback, nil
+	}
+
+	n, err := strconv.Atoi(param)
+	if err != nil || n < 0 {
+		return 0, errors.Wrapf(errorInvalidPagination, "[intQueryParam]: %s must be a positive number", name)
+	}
+
+	return n, nil
+}
+
+// limitQueryParam defaults to defaultAdminPageSize and caps the limit at maxAdminPageSize, a zero limit is rejected
+func (h *AdminHandler) limitQueryParam(c echo.Context) (int, error) {
+	limit, err := h.intQueryParam(c, "limit", defaultAdminPageSize)
+	if err != nil {
+		return 0, err
+	}
+	if limit == 0 {
+		return 0, errors.Wrap(errorInvalidPagination, "[limitQueryParam]: limit must be greater than zero")
+	}
+	if limit > maxAdminPageSize {
+		limit = maxAdminPageSize
+	}
+
+	return limit, nil
+}
+
+func (h *AdminHandler) ListAccounts(c echo.Context) error {
+	limit, err := h.limitQueryParam(c)
+	if err != nil {
+		return h.returnError(c, http.StatusBadRequest, err)
+	}
+	offset, err := h.intQueryParam(c, "offset", 0)
+	if err != nil {
+		return h.returnError(c, http.StatusBadRequest, err)
+	}
+
+	accounts, err := h.service.GetAccounts(c.Request().Context(), limit, offset)
+	if err != nil {
+		return h.returnError(c, http.StatusInternalServerError, errors.Wrap(err, "[ListAccounts]: unable to get accounts"))
+	}
+
+	return c.JSON(http.StatusOK, accounts)
+}
+
+func (h *AdminHandler) GetAccount(c echo.Context) error {
+	uid := c.Param("uid")
+
+	acc, err := h.service.GetAccount(c.Request().Context(), uid)
+	if err != nil {
+		return h.accountError(c, errors.Wrap(err, "[GetAccount]: unable to get account"))
+	}
+
+	return c.JSON(http.StatusOK, acc)
+}
+
+func (h *AdminHandler) PushMessage(c echo.Context) error {
+	uid := c.Param("uid")
+
+	var req requestPushMessage
+	if err := c.Bind(&req); err != nil || req.Text == "" {
+		return h.returnError(c, http.StatusBadRequest, errorInvalidPushText)
+	}
+
+	if _, err := h.service.GetAccount(c.Request().Context(), uid); err != nil {
+		return h.accountError(c, errors.Wrap(err, "[PushMessage]: unable to get account"))
+	}
+
+	if err := h.service.LINEPushTextMessage(c.Request().Context(), uid, req.Text); err != nil {
+		return h.returnError(c, http.StatusBadGateway, errors.Wrap(err, "[PushMessage]: unable to push message"))
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *AdminHandler) RelinkRichMenu(c echo.Context) error {
+	uid := c.Param("uid")
+
+	var req requestRelinkRichMenu
+	if err := c.Bind(&req); err != nil {
+		return h.returnError(c, http.StatusBadRequest, errorInvalidRichMenu)
+	}
+
+	var err error
+	switch {
+	case req.Alias != "":
+		err = h.service.LINELinkUserToRichMenuAlias(c.Request().Context(), uid, req.Alias)
+		if errors.Cause(err) == line.ErrorUnknownRichMenuAlias {
+			return h.returnError(c, http.StatusBadRequest, err)
+		}
+	case req.Menu == richMenuLogin:
+		err = h.service.LINELinkUserToLoginRichMenu(c.Request().Context(), uid)
+	case req.Menu == richMenuDefault:
+		err = h.service.LINELinkUserToDefaultRichMenu(c.Request().Context(), uid)
+	default:
+		return h.returnError(c, http.StatusBadRequest, errorInvalidRichMenu)
+	}
+	if err != nil {
+		return h.returnError(c, http.StatusBadGateway, errors.Wrap(err, "[RelinkRichMenu]: unable to link rich menu"))
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *AdminHandler) ListCommands(c echo.Context) error {
+	limit, err := h.limitQueryParam(c)
+	if err != nil {
+		return h.returnError(c, http.StatusBadRequest, err)
+	}
+
+	commands, err := h.service.GetRecentCommands(c.Request().Context(), c.QueryParam("uid"), limit)
+	if err != nil {
+		return h.returnError(c, http.StatusInternalServerError, errors.Wrap(err, "[ListCommands]: unable to get commands"))
+	}
+
+	return c.JSON(http.StatusOK, commands)
+}
+
+// CommandStats summarizes the commands handled in the period given by ?since, e.g. 168h for the last week
+func (h *AdminHandler) CommandStats(c echo.Context) error {
+	period := defaultStatsPeriod
+	if raw := c.QueryParam("since"); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil || d <= 0 {
+			return h.returnError(c, http.StatusBadRequest, errorInvalidPeriod)
+		}
+		period = d
+	}
+
+	stats, err := h.service.GetCommandStats(c.Request().Context(), time.Now().Add(-period))
+	if err != nil {
+		return h.returnError(c, http.StatusInternalServerError, errors.Wrap(err, "[CommandStats]: unable to get command stats"))
+	}
+
+	return c.JSON(http.StatusOK, stats)
+}
+
+func (h *AdminHandler) ListJobs(c echo.Context) error {
+	jobs, err := h.scheduler.Jobs(c.Request().Context())
+	if err != nil {
+		return h.returnError(c, http.StatusInternalServerError, errors.Wrap(err, "[ListJobs]: unable to get jobs"))
+	}
+
+	return c.JSON(http.StatusOK, jobs)
+}
+
+// RunJob starts a job right away without waiting for it, ListJobs reports its outcome once it finishes
+func (h *AdminHandler) RunJob(c echo.Context) error {
+	err := h.scheduler.RunNow(c.Request().Context(), c.Param("name"))
+	if errors.Cause(err) == scheduler.ErrorUnknownJob {
+		return h.returnError(c, http.StatusNotFound, err)
+	}
+	if errors.Cause(err) == scheduler.ErrorJobRunning {
+		return h.returnError(c, http.StatusConflict, err)
+	}
+	if err != nil {
+		return h.returnError(c, http.StatusInternalServerError, errors.Wrap(err, "[RunJob]: unable to start job"))
+	}
+
+	return c.NoContent(http.StatusAccepted)
+}
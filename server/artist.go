@@ -0,0 +1,174 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/bbkbbbk/sapo/line/message"
+	"github.com/bbkbbbk/sapo/pkg/logger"
+	"github.com/bbkbbbk/sapo/spotify"
+)
+
+const (
+	artistDetailSize = 5
+)
+
+// ArtistDetail is an artist with its most popular tracks, latest albums and the artists similar to it
+type ArtistDetail struct {
+	Artist    *spotify.Artist
+	TopTracks []spotify.Track
+	Albums    []spotify.Album
+	Related   []spotify.Artist
+}
+
+func (s *service) getArtistDetail(ctx context.Context, uid, id string) (*ArtistDetail, error) {
+	acc, err := s.getAccountByUID(ctx, uid)
+	if err != nil {
+		return nil, errors.Wrap(err, "[getArtistDetail]: unable to get user profile")
+	}
+
+	accessToken, err := s.spotifyService.RequestAccessTokenFromRefreshToken(ctx, acc.RefreshToken)
+	if err != nil {
+		return nil, errors.Wrap(err, "[getArtistDetail]: unable to request access token")
+	}
+
+	artist, err := s.spotifyService.GetArtist(ctx, accessToken, id)
+	if err != nil {
+		return nil, errors.Wrap(err, "[getArtistDetail]: unable to get artist")
+	}
+
+	tracks, err := s.spotifyService.GetArtistTopTracks(ctx, accessToken, id, spotify.MarketFromToken)
+	if err != nil {
+		return nil, errors.Wrap(err, "[getArtistDetail]: unable to get artist top tracks")
+	}
+
+	albums, err := s.spotifyService.GetArtistAlbums(ctx, accessToken, id, artistDetailSize, spotify.MarketFromToken)
+	if err != nil {
+		return nil, errors.Wrap(err, "[getArtistDetail]: unable to get artist albums")
+	}
+
+	// related artists are the least important section, the rest of the detail is still worth sending without them
+	related, err := s.spotifyService.GetRelatedArtists(ctx, accessToken, id)
+	if err != nil {
+		logger.FromContext(ctx).WithError(err).Warn("unable to get related artists")
+		related = nil
+	}
+
+	if len(tracks) > artistDetailSize {
+		tracks = tracks[:artistDetailSize]
+	}
+	if len(related) > artistDetailSize {
+		related = related[:artistDetailSize]
+	}
+
+	return &ArtistDetail{
+		Artist:    artist,
+		TopTracks: tracks,
+		Albums:    albums,
+		Related:   related,
+	}, nil
+}
+
+// createArtistPlaylistForUser creates a playlist seeded on the artist
+func (s *service) createArtistPlaylistForUser(ctx context.Context, uid, id string) (*spotify.Playlist, error) {
+	acc, err := s.getAccountByUID(ctx, uid)
+	if err != nil {
+		return nil, errors.Wrap(err, "[createArtistPlaylistForUser]: unable to get user profile")
+	}
+
+	accessToken, err := s.spotifyService.RequestAccessTokenFromRefreshToken(ctx, acc.RefreshToken)
+	if err != nil {
+		return nil, errors.Wrap(err, "[createArtistPlaylistForUser]: unable to request access token")
+	}
+
+	artist, err := s.spotifyService.GetArtist(ctx, accessToken, id)
+	if err != nil {
+		return nil, errors.Wrap(err, "[createArtistPlaylistForUser]: unable to get artist")
+	}
+
+	name := fmt.Sprintf("%s radio by sapo", artist.Name)
+	recommended, err := s.spotifyService.CreateArtistsPlaylistForUser(ctx, accessToken, acc.SpotifyID, name, []string{artist.ID})
+	if err != nil {
+		return nil, errors.Wrap(err, "[createArtistPlaylistForUser]: unable to create playlist")
+	}
+
+	playlist, err := s.spotifyService.GetPlaylist(ctx, accessToken, recommended.ID)
+	if err != nil {
+		return nil, errors.Wrap(err, "[createArtistPlaylistForUser]: unable to playlist detail")
+	}
+
+	s.recordPlaylist(ctx, uid, playlist, recommended)
+
+	return playlist, nil
+}
+
+// createCarouselArtistDetail shows the artist then its top tracks, albums and related artists, sections without items are left out
+func (s *service) createCarouselArtistDetail(detail *ArtistDetail) *message.Flex {
+	artist := detail.Artist
+
+	text := fmt.Sprintf("%d followers", artist.Followers.Total)
+	if len(artist.Genres) > 0 {
+		text = fmt.Sprintf("%s, %s", strings.Join(artist.Genres, ", "), text)
+	}
+	actions := []message.Action{
+		message.NewURIAction("open in Spotify", artist.ExternalURLs.URL),
+		message.NewPostbackAction("make me a playlist", postbackData(postbackActionArtistPlaylist, artist.ID), fmt.Sprintf("Playlist from %s", artist.Name)),
+	}
+	bubbles := []message.Flex{
+		message.NewBubbleWithActions(artist.Name, artist.Name, text, imageURL(artist.Images), actions),
+	}
+
+	if len(detail.TopTracks) > 0 {
+		boxes := []message.BubbleReceiptBox{}
+		for _, track := range detail.TopTracks {
+			action := message.NewPostbackAction(actionLabel(track.Name), postbackData(postbackActionTrack, track.ID), track.Name)
+			boxes = append(boxes, message.BubbleReceiptBox{
+				Header:   track.Name,
+				Text:     track.Album.Name,
+				LeftText: formatDuration(track.Duration),
+				ImageURL: imageURL(track.Album.Images),
+				URL:      track.ExternalURLs.URL,
+				Action:   &action,
+			})
+		}
+		bubbles = append(bubbles, message.NewBubbleReceipt("Top Tracks", artist.Name, "Top Tracks", "The most played right now", boxes))
+	}
+
+	if len(detail.Albums) > 0 {
+		boxes := []message.BubbleReceiptBox{}
+		for _, album := range detail.Albums {
+			boxes = append(boxes, message.BubbleReceiptBox{
+				Header:   album.Name,
+				Text:     album.ReleaseDate,
+				LeftText: album.AlbumType,
+				ImageURL: imageURL(album.Images),
+				URL:      album.ExternalURLs.URL,
+			})
+		}
+		bubbles = append(bubbles, message.NewBubbleReceipt("Albums", artist.Name, "Albums", "The latest releases", boxes))
+	}
+
+	if len(detail.Related) > 0 {
+		boxes := []message.BubbleReceiptBox{}
+		for _, related := range detail.Related {
+			action := message.NewPostbackAction(actionLabel(related.Name), postbackData(postbackActionArtist, related.ID), related.Name)
+			boxes = append(boxes, message.BubbleReceiptBox{
+				Header:   related.Name,
+				Text:     fmt.Sprintf("%d followers", related.Followers.Total),
+				ImageURL: imageURL(related.Images),
+				Action:   &action,
+			})
+		}
+		bubbles = append(bubbles, message.NewBubbleReceipt("Fans Also Like", artist.Name, "Fans Also Like", "Tap one to dive in", boxes))
+	}
+
+	carousel := message.NewCarousel(
+		artist.Name,
+		bubbles,
+	)
+
+	return &carousel
+}
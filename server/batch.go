@@ -0,0 +1,76 @@
+package server
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/bbkbbbk/sapo/pkg/logger"
+	"github.com/bbkbbbk/sapo/spotify"
+)
+
+const (
+	accountBatchSize = 50
+)
+
+var (
+	errorAccountsFailed = errors.New("some accounts failed")
+)
+
+// accountFilter narrows down the accounts a job goes through
+type accountFilter struct {
+	// weeklyPlaylist keeps the accounts subscribed to the weekly playlist only
+	weeklyPlaylist bool
+	// scopes keeps the accounts that granted every one of them, the others are skipped without counting as failed
+	scopes []string
+}
+
+// forEachAccount calls fn for every account matching filter, a batch at a time so a job over every user
+// never loads them all at once. A failed account is logged and does not stop the others,
+// errorAccountsFailed is returned once every account was handled when any of them failed.
+func (s *service) forEachAccount(ctx context.Context, filter accountFilter, fn func(ctx context.Context, acc *Account) error) (int, int, error) {
+	page := s.repository.GetAccountsAfter
+	if filter.weeklyPlaylist {
+		page = s.repository.GetWeeklyPlaylistSubscribers
+	}
+
+	done, failed := 0, 0
+	var after primitive.ObjectID
+	for {
+		accounts, err := page(ctx, after, accountBatchSize)
+		if err != nil {
+			return done, failed, errors.Wrap(err, "[forEachAccount]: unable to get accounts")
+		}
+
+		for i := range accounts {
+			if ctx.Err() != nil {
+				return done, failed, errors.Wrap(ctx.Err(), "[forEachAccount]: interrupted")
+			}
+
+			acc := &accounts[i]
+			if len(spotify.MissingScopes(acc.GrantedScopes(), filter.scopes)) > 0 {
+				continue
+			}
+
+			userCtx := logger.WithField(ctx, logger.FieldLINEUID, acc.UID)
+			if err := fn(userCtx, acc); err != nil {
+				logger.FromContext(userCtx).WithError(err).Warn("unable to handle account")
+				failed++
+				continue
+			}
+			done++
+		}
+
+		if len(accounts) < accountBatchSize {
+			break
+		}
+		after = accounts[len(accounts)-1].ID
+	}
+
+	if failed > 0 {
+		return done, failed, errors.Wrapf(errorAccountsFailed, "[forEachAccount]: %d of %d", failed, done+failed)
+	}
+
+	return done, failed, nil
+}
@@ -0,0 +1,177 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/line/line-bot-sdk-go/linebot"
+	"github.com/pkg/errors"
+
+	"github.com/bbkbbbk/sapo/line/message"
+	"github.com/bbkbbbk/sapo/spotify"
+)
+
+const (
+	genreArtistLimit    = 50
+	genreDisplaySize    = 6
+	genreQuickReplySize = 5
+	// quickReplyLabelSize is the longest label LINE accepts on a quick reply button
+	quickReplyLabelSize = 20
+)
+
+var (
+	errorUnknownGenre = errors.New("genre not found among top artists")
+)
+
+// GenreShare is how much of a user's top artists a genre covers, an artist weighs more the higher it ranks
+// and counts for each of its genres. ArtistIDs are in the order of their rank
+type GenreShare struct {
+	Name      string
+	Weight    int
+	Percent   int
+	ArtistIDs []string
+}
+
+// computeGenreBreakdown weighs the genres of artists ranked from the top, the heaviest genre first
+func computeGenreBreakdown(artists []spotify.Artist) []GenreShare {
+	shares := map[string]*GenreShare{}
+	total := 0
+	for i, artist := range artists {
+		weight := len(artists) - i
+		for _, genre := range artist.Genres {
+			share, ok := shares[genre]
+			if !ok {
+				share = &GenreShare{Name: genre}
+				shares[genre] = share
+			}
+			share.Weight += weight
+			share.ArtistIDs = append(share.ArtistIDs, artist.ID)
+			total += weight
+		}
+	}
+
+	genres := []GenreShare{}
+	for _, share := range shares {
+		share.Percent = share.Weight * 100 / total
+		genres = append(genres, *share)
+	}
+	sort.Slice(genres, func(i, j int) bool {
+		if genres[i].Weight != genres[j].Weight {
+			return genres[i].Weight > genres[j].Weight
+		}
+		return genres[i].Name < genres[j].Name
+	})
+
+	return genres
+}
+
+func (s *service) getGenreArtists(ctx context.Context, uid string) ([]spotify.Artist, error) {
+	acc, err := s.getAccountByUID(ctx, uid)
+	if err != nil {
+		return nil, errors.Wrap(err, "[getGenreArtists]: unable to get user profile")
+	}
+
+	accessToken, err := s.spotifyService.RequestAccessTokenFromRefreshToken(ctx, acc.RefreshToken)
+	if err != nil {
+		return nil, errors.Wrap(err, "[getGenreArtists]: unable to request access token")
+	}
+
+	artists, err := s.spotifyService.GetTopArtists(ctx, accessToken, spotify.TimeRangeMedium, genreArtistLimit)
+	if err != nil {
+		return nil, errors.Wrap(err, "[getGenreArtists]: unable to get user's top artists")
+	}
+
+	return artists, nil
+}
+
+// createGenrePlaylistForUser creates a playlist seeded on the top artists of the genre,
+// errorUnknownGenre is returned when none of the user's top artists play it
+func (s *service) createGenrePlaylistForUser(ctx context.Context, uid, genre string) (*spotify.Playlist, error) {
+	acc, err := s.getAccountByUID(ctx, uid)
+	if err != nil {
+		return nil, errors.Wrap(err, "[createGenrePlaylistForUser]: unable to get user profile")
+	}
+
+	accessToken, err := s.spotifyService.RequestAccessTokenFromRefreshToken(ctx, acc.RefreshToken)
+	if err != nil {
+		return nil, errors.Wrap(err, "[createGenrePlaylistForUser]: unable to request access token")
+	}
+
+	artists, err := s.spotifyService.GetTopArtists(ctx, accessToken, spotify.TimeRangeMedium, genreArtistLimit)
+	if err != nil {
+		return nil, errors.Wrap(err, "[createGenrePlaylistForUser]: unable to get user's top artists")
+	}
+
+	var seeds []string
+	for _, share := range computeGenreBreakdown(artists) {
+		if share.Name == genre {
+			seeds = share.ArtistIDs
+			break
+		}
+	}
+	if len(seeds) == 0 {
+		return nil, errors.Wrapf(errorUnknownGenre, "[createGenrePlaylistForUser]: genre %s", genre)
+	}
+	if len(seeds) > spotify.LimitSeedSize {
+		seeds = seeds[:spotify.LimitSeedSize]
+	}
+
+	name := fmt.Sprintf("%s mix by sapo", genre)
+	recommended, err := s.spotifyService.CreateArtistsPlaylistForUser(ctx, accessToken, acc.SpotifyID, name, seeds)
+	if err != nil {
+		return nil, errors.Wrap(err, "[createGenrePlaylistForUser]: unable to create playlist")
+	}
+
+	playlist, err := s.spotifyService.GetPlaylist(ctx, accessToken, recommended.ID)
+	if err != nil {
+		return nil, errors.Wrap(err, "[createGenrePlaylistForUser]: unable to playlist detail")
+	}
+
+	s.recordPlaylist(ctx, uid, playlist, recommended)
+
+	return playlist, nil
+}
+
+// createGenresFlexMsg draws the heaviest genres as bars relative to the heaviest one
+func (s *service) createGenresFlexMsg(genres []GenreShare) *message.Flex {
+	if len(genres) > genreDisplaySize {
+		genres = genres[:genreDisplaySize]
+	}
+
+	bars := []message.BubbleBar{}
+	for _, genre := range genres {
+		bars = append(bars, message.BubbleBar{
+			Label:   genre.Name,
+			Value:   fmt.Sprintf("%d%%", genre.Percent),
+			Percent: genre.Weight * 100 / genres[0].Weight,
+		})
+	}
+
+	flex := message.NewBubbleBarChart(
+		"My genres",
+		"My Genres",
+		"What your top artists of the last 6 months play",
+		bars,
+	)
+
+	return &flex
+}
+
+func (s *service) createGenreQuickReplies(genres []GenreShare) *linebot.QuickReplyItems {
+	if len(genres) > genreQuickReplySize {
+		genres = genres[:genreQuickReplySize]
+	}
+
+	buttons := []*linebot.QuickReplyButton{}
+	for _, genre := range genres {
+		label := genre.Name
+		if runes := []rune(label); len(runes) > quickReplyLabelSize {
+			label = string(runes[:quickReplyLabelSize])
+		}
+		text := fmt.Sprintf("%s %s", textEventGenrePlaylist, genre.Name)
+		buttons = append(buttons, linebot.NewQuickReplyButton("", linebot.NewMessageAction(label, text)))
+	}
+
+	return linebot.NewQuickReplyItems(buttons...)
+}
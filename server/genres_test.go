@@ -0,0 +1,70 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bbkbbbk/sapo/spotify"
+)
+
+func TestComputeGenreBreakdown(t *testing.T) {
+	tests := []struct {
+		name    string
+		artists []spotify.Artist
+		want    []GenreShare
+	}{
+		{
+			name:    "no artists",
+			artists: []spotify.Artist{},
+			want:    []GenreShare{},
+		},
+		{
+			name: "artists without genres",
+			artists: []spotify.Artist{
+				{ID: "a"},
+				{ID: "b", Genres: []string{}},
+			},
+			want: []GenreShare{},
+		},
+		{
+			name: "higher ranked artists weigh more",
+			artists: []spotify.Artist{
+				{ID: "a", Genres: []string{"k-pop"}},
+				{ID: "b", Genres: []string{"indie"}},
+				{ID: "c", Genres: []string{"k-pop"}},
+			},
+			want: []GenreShare{
+				{Name: "k-pop", Weight: 4, Percent: 66, ArtistIDs: []string{"a", "c"}},
+				{Name: "indie", Weight: 2, Percent: 33, ArtistIDs: []string{"b"}},
+			},
+		},
+		{
+			name: "an artist counts for each of its genres",
+			artists: []spotify.Artist{
+				{ID: "a", Genres: []string{"rock", "pop"}},
+			},
+			want: []GenreShare{
+				{Name: "pop", Weight: 1, Percent: 50, ArtistIDs: []string{"a"}},
+				{Name: "rock", Weight: 1, Percent: 50, ArtistIDs: []string{"a"}},
+			},
+		},
+		{
+			name: "artists without genres still weigh by rank",
+			artists: []spotify.Artist{
+				{ID: "a"},
+				{ID: "b", Genres: []string{"jazz"}},
+			},
+			want: []GenreShare{
+				{Name: "jazz", Weight: 1, Percent: 100, ArtistIDs: []string{"b"}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := computeGenreBreakdown(tt.artists); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("computeGenreBreakdown() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
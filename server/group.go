@@ -0,0 +1,228 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/line/line-bot-sdk-go/linebot"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/bbkbbbk/sapo/line/message"
+	"github.com/bbkbbbk/sapo/pkg/logger"
+	"github.com/bbkbbbk/sapo/spotify"
+)
+
+const (
+	groupTopTrackLimit   = 10
+	groupMemberTrackSize = 2
+	groupPlaylistSize    = 30
+	groupIntroMsg        = "Hi everyone! Say \"join\" to add your Spotify taste, then \"group playlist\" and sapo makes a playlist for all of you"
+)
+
+var (
+	errorEmptyGroupSession = errors.New("no member of the group session has top tracks")
+
+	groupCommands = map[string]bool{
+		textEventJoin:          true,
+		textEventLeave:         true,
+		textEventGroupPlaylist: true,
+	}
+)
+
+// sourceChatID is the group or room id of the event source, empty for a one-on-one chat
+func sourceChatID(source *linebot.EventSource) string {
+	switch source.Type {
+	case linebot.EventSourceTypeGroup:
+		return source.GroupID
+	case linebot.EventSourceTypeRoom:
+		return source.RoomID
+	}
+
+	return ""
+}
+
+// groupEventsHandler only answers group commands, everything else said in a group is left alone
+func (s *service) groupEventsHandler(ctx context.Context, uid, chatID, msg, token string) error {
+	command, args := parseCommand(strings.ToLower(msg))
+	if !groupCommands[command] || uid == "" {
+		return nil
+	}
+
+	return s.handleCommand(ctx, uid, chatID, command, args, token)
+}
+
+func (s *service) groupCommandHandler(ctx context.Context, uid, chatID, command, token string) error {
+	switch command {
+	case textEventJoin:
+		session, err := s.repository.JoinGroupSession(ctx, chatID, uid)
+		if err != nil {
+			return errors.Wrap(err, "[groupCommandHandler]: unable to join group session")
+		}
+
+		replyMsg := fmt.Sprintf("You're in! %s joined so far, say \"group playlist\" when everyone is ready", memberCountText(len(session.Members)))
+		if err := s.lineService.SendTextMessage(ctx, token, replyMsg); err != nil {
+			return errors.Wrap(err, "[groupCommandHandler]: unable to send message")
+		}
+	case textEventLeave:
+		replyMsg := "You left, the next group playlist won't include your taste"
+		if _, err := s.repository.LeaveGroupSession(ctx, chatID, uid); err != nil {
+			if errors.Cause(err) != mongo.ErrNoDocuments {
+				return errors.Wrap(err, "[groupCommandHandler]: unable to leave group session")
+			}
+			replyMsg = "Nobody has joined yet, say \"join\" to start"
+		}
+
+		if err := s.lineService.SendTextMessage(ctx, token, replyMsg); err != nil {
+			return errors.Wrap(err, "[groupCommandHandler]: unable to send message")
+		}
+	case textEventGroupPlaylist:
+		playlist, err := s.createGroupPlaylist(ctx, chatID, uid)
+		if errors.Cause(err) == errorEmptyGroupSession {
+			replyMsg := "sapo couldn't find enough listening to mix yet, ask your friends to say \"join\" first"
+			if err := s.lineService.SendTextMessage(ctx, token, replyMsg); err != nil {
+				return errors.Wrap(err, "[groupCommandHandler]: unable to send message")
+			}
+			break
+		}
+		if err != nil {
+			return errors.Wrap(err, "[groupCommandHandler]: unable to create group playlist")
+		}
+
+		flex := s.createGroupPlaylistFlexMsg(playlist)
+		if err := s.lineService.ReplyFlexMsg(ctx, token, *flex); err != nil {
+			return errors.Wrap(err, "[groupCommandHandler]: unable to reply message")
+		}
+	}
+
+	return nil
+}
+
+// createGroupPlaylist mixes the recent top tracks of everyone in the group session, the initiator included, with
+// recommendations seeded on them. The playlist is collaborative on the initiator's account so the group can edit it
+func (s *service) createGroupPlaylist(ctx context.Context, chatID, uid string) (*spotify.Playlist, error) {
+	members := []string{uid}
+	session, err := s.repository.GetGroupSession(ctx, chatID)
+	if err != nil && errors.Cause(err) != mongo.ErrNoDocuments {
+		return nil, errors.Wrap(err, "[createGroupPlaylist]: unable to get group session")
+	}
+	if session != nil {
+		for _, member := range session.Members {
+			if member != uid {
+				members = append(members, member)
+			}
+		}
+	}
+
+	accessToken, err := s.getAccessTokenByUID(ctx, uid)
+	if err != nil {
+		return nil, errors.Wrap(err, "[createGroupPlaylist]: unable to get access token")
+	}
+
+	memberTracks := [][]spotify.Track{}
+	for _, member := range members {
+		memberToken := accessToken
+		if member != uid {
+			memberToken, err = s.getAccessTokenByUID(ctx, member)
+			if err != nil {
+				logger.FromContext(ctx).WithError(err).WithField(logger.FieldLINEUID, member).Warn("unable to get group member access token")
+				continue
+			}
+		}
+
+		tracks, err := s.spotifyService.GetTopTracks(ctx, memberToken, spotify.TimeRangeShort, groupTopTrackLimit)
+		if err != nil {
+			logger.FromContext(ctx).WithError(err).WithField(logger.FieldLINEUID, member).Warn("unable to get group member top tracks")
+			continue
+		}
+		if len(tracks) > 0 {
+			memberTracks = append(memberTracks, tracks)
+		}
+	}
+	if len(memberTracks) == 0 {
+		return nil, errors.Wrapf(errorEmptyGroupSession, "[createGroupPlaylist]: chat %s", chatID)
+	}
+
+	// take turns between members so everyone gets a seed and a couple of their favourites in
+	seeds := []string{}
+	uris := []string{}
+	added := map[string]bool{}
+	for i := 0; i < groupTopTrackLimit; i++ {
+		for _, tracks := range memberTracks {
+			if i >= len(tracks) {
+				continue
+			}
+			if len(seeds) < spotify.LimitSeedSize {
+				seeds = append(seeds, tracks[i].ID)
+			}
+			if i < groupMemberTrackSize && !added[tracks[i].ID] {
+				added[tracks[i].ID] = true
+				uris = append(uris, spotify.TrackURI(tracks[i].ID))
+			}
+		}
+	}
+
+	recommendations, err := s.spotifyService.GetTracksBasedOnSeeds(ctx, accessToken, seeds, groupPlaylistSize)
+	if err != nil {
+		return nil, errors.Wrap(err, "[createGroupPlaylist]: unable to get recommendations")
+	}
+	for _, track := range recommendations {
+		if len(uris) >= groupPlaylistSize {
+			break
+		}
+		if !added[track.ID] {
+			added[track.ID] = true
+			uris = append(uris, spotify.TrackURI(track.ID))
+		}
+	}
+
+	acc, err := s.getAccountByUID(ctx, uid)
+	if err != nil {
+		return nil, errors.Wrap(err, "[createGroupPlaylist]: unable to get user profile")
+	}
+
+	name := fmt.Sprintf("sapo group mix %s", time.Now().Format("2 Jan 2006"))
+	description := fmt.Sprintf("Mixed by sapo from the taste of %s", memberCountText(len(memberTracks)))
+	id, err := s.spotifyService.CreateCollaborativePlaylistForUser(ctx, accessToken, acc.SpotifyID, name, description, uris)
+	if err != nil {
+		return nil, errors.Wrap(err, "[createGroupPlaylist]: unable to create playlist")
+	}
+
+	playlist, err := s.spotifyService.GetPlaylist(ctx, accessToken, id)
+	if err != nil {
+		return nil, errors.Wrap(err, "[createGroupPlaylist]: unable to playlist detail")
+	}
+
+	s.recordPlaylist(ctx, uid, playlist, &spotify.RecommendedPlaylist{
+		ID:        id,
+		Seeds:     seeds,
+		TrackURIs: uris,
+		Created:   true,
+	})
+
+	return playlist, nil
+}
+
+func (s *service) createGroupPlaylistFlexMsg(playlist *spotify.Playlist) *message.Flex {
+	flex := message.NewBubbleWithButton(
+		"Playlist for the group",
+		playlist.Name,
+		playlist.Description,
+		"go to playlist",
+		playlist.ExternalURLs.URL,
+		playlistImageURL(playlist),
+		defaultFlexColor,
+	)
+
+	return &flex
+}
+
+func memberCountText(count int) string {
+	if count == 1 {
+		return "1 friend"
+	}
+
+	return fmt.Sprintf("%d friends", count)
+}
@@ -1,45 +1,83 @@
 package server
 
 import (
+	"context"
 	"net/http"
 	"time"
 
 	"github.com/labstack/echo"
 	"github.com/pkg/errors"
-	"github.com/sirupsen/logrus"
 
+	"github.com/bbkbbbk/sapo/pkg/logger"
+	"github.com/bbkbbbk/sapo/pkg/metrics"
 	"github.com/bbkbbbk/sapo/spotify"
 )
 
 const (
-	defaultCookieExpires = 60
+	defaultCookieExpires    = 60
+	defaultReadinessTimeout = 5
 )
 
 var (
-	errorInvalidUID              = errors.New("invalid LINE user id")
+	errorInvalidIDToken          = errors.New("invalid LINE id token")
 	errorInvalidSpotifyAuthCode  = errors.New("invalid spotify authorization code")
 	errorInvalidSpotifyAuthState = errors.New("invalid spotify auth state")
 	errorUnableToGetCookie       = errors.New("unable to get cookie")
 	errorUnableLogIn             = errors.New("unable to login to spotify")
+	errorInvalidSpotifyScope     = errors.New("invalid spotify scope")
 )
 
+// ReadinessCheck reports why a dependency is not ready to serve traffic
+type ReadinessCheck func(ctx context.Context) error
+
 type Handler struct {
 	service          Service
+	queue            *EventQueue
 	loginCallBackURL string
+	usePKCE          bool
+	readinessChecks  map[string]ReadinessCheck
 }
 
-func NewHandler(s Service, callbackUrl string) Handler {
+func NewHandler(s Service, queue *EventQueue, callbackUrl string, usePKCE bool) Handler {
 	return Handler{
 		service:          s,
+		queue:            queue,
 		loginCallBackURL: callbackUrl,
+		usePKCE:          usePKCE,
+		readinessChecks:  map[string]ReadinessCheck{},
 	}
 }
 
-func (h *Handler) returnError(err error) error {
-	logrus.Error(err.Error())
+func (h *Handler) AddReadinessCheck(name string, check ReadinessCheck) {
+	h.readinessChecks[name] = check
+}
+
+func (h *Handler) newCookie(name, value string) *http.Cookie {
+	cookie := new(http.Cookie)
+	cookie.Name = name
+	cookie.Value = value
+	cookie.Expires = time.Now().Add(defaultCookieExpires * time.Second)
+	cookie.HttpOnly = true
+
+	return cookie
+}
+
+func (h *Handler) returnError(c echo.Context, err error) error {
+	logger.FromContext(c.Request().Context()).WithError(err).Error("request failed")
 	return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 }
 
+func (h *Handler) parseScopes(scope string) ([]string, error) {
+	scopes := spotify.ParseScopes(scope)
+	for _, s := range scopes {
+		if !spotify.IsKnownScope(s) {
+			return nil, errors.Wrapf(errorInvalidSpotifyScope, "[parseScopes]: unknown scope %s", s)
+		}
+	}
+
+	return scopes, nil
+}
+
 func (h *Handler) HomePage(c echo.Context) error {
 	return c.JSON(http.StatusOK, "Hello this is sapo")
 }
@@ -47,32 +85,88 @@ func (h *Handler) HomePage(c echo.Context) error {
 func (h *Handler) LINECallback(c echo.Context) error {
 	events, err := h.service.ParseLINERequest(c.Request())
 	if err != nil {
-		return h.returnError(err)
+		return h.returnError(c, err)
+	}
+
+	for _, event := range events {
+		metrics.WebhookEvents.WithLabelValues(string(event.Type)).Inc()
 	}
 
-	err = h.service.LINEEventsHandler(events)
+	err = h.queue.Enqueue(c.Request().Context(), events)
 	if err != nil {
-		return h.returnError(err)
+		logger.FromContext(c.Request().Context()).WithError(err).Error("unable to enqueue events")
+		return echo.NewHTTPError(http.StatusServiceUnavailable, err.Error())
 	}
 
 	return c.JSON(http.StatusOK, "")
 }
 
+func (h *Handler) Healthz(c echo.Context) error {
+	return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func (h *Handler) Readyz(c echo.Context) error {
+	ctx, cancel := context.WithTimeout(c.Request().Context(), time.Second*defaultReadinessTimeout)
+	defer cancel()
+
+	status := http.StatusOK
+	checks := map[string]string{}
+	if h.queue.Closed() {
+		status = http.StatusServiceUnavailable
+		checks["webhookQueue"] = errorQueueClosed.Error()
+	}
+
+	for name, check := range h.readinessChecks {
+		if err := check(ctx); err != nil {
+			status = http.StatusServiceUnavailable
+			checks[name] = err.Error()
+			continue
+		}
+		checks[name] = "ok"
+	}
+
+	res := map[string]interface{}{
+		"status": "ok",
+		"checks": checks,
+	}
+	if status != http.StatusOK {
+		res["status"] = "unavailable"
+	}
+
+	return c.JSON(status, res)
+}
+
 func (h *Handler) SignUp(c echo.Context) error {
-	uid := c.QueryParam("uid")
-	if uid == "" {
-		return h.returnError(errorInvalidUID)
+	idToken := c.QueryParam("id_token")
+	if idToken == "" {
+		return h.returnError(c, errorInvalidIDToken)
 	}
 
-	cookie := new(http.Cookie)
-	cookie.Name = spotify.AuthState
-	cookie.Value = uid
-	cookie.Expires = time.Now().Add(defaultCookieExpires * time.Second)
-	c.SetCookie(cookie)
+	uid, err := h.service.VerifyLINEIDToken(c.Request().Context(), idToken)
+	if err != nil {
+		return h.returnError(c, errors.Wrap(err, "[SignUp]: unable to verify id token"))
+	}
 
-	err := c.Redirect(302, h.service.GetSpotifyAuthURL(uid))
+	c.SetCookie(h.newCookie(spotify.AuthState, uid))
+
+	codeChallenge := ""
+	if h.usePKCE {
+		pkce, err := spotify.NewPKCE()
+		if err != nil {
+			return h.returnError(c, errors.Wrap(err, "[SignUp]: unable to generate pkce"))
+		}
+		c.SetCookie(h.newCookie(spotify.AuthCodeVerifier, pkce.Verifier))
+		codeChallenge = pkce.Challenge
+	}
+
+	scopes, err := h.parseScopes(c.QueryParam("scope"))
 	if err != nil {
-		return h.returnError(errors.Wrap(err, "[SignUp]: unable to redirect"))
+		return h.returnError(c, errors.Wrap(err, "[SignUp]: unable to parse requested scopes"))
+	}
+
+	err = c.Redirect(302, h.service.GetSpotifyAuthURL(c.Request().Context(), uid, codeChallenge, scopes))
+	if err != nil {
+		return h.returnError(c, errors.Wrap(err, "[SignUp]: unable to redirect"))
 	}
 
 	return c.JSON(http.StatusOK, "")
@@ -81,46 +175,46 @@ func (h *Handler) SignUp(c echo.Context) error {
 func (h *Handler) SpotifyCallback(c echo.Context) error {
 	errParam := c.QueryParam("error")
 	if errParam != "" {
-		return h.returnError(errors.Wrapf(errorUnableLogIn, "[SpotifyLoginCallback]: unable to login to spotify due to %v", errParam))
+		return h.returnError(c, errors.Wrapf(errorUnableLogIn, "[SpotifyLoginCallback]: unable to login to spotify due to %v", errParam))
 	}
 
 	code := c.QueryParam("code")
 	if code == "" {
-		return h.returnError(errorInvalidSpotifyAuthCode)
+		return h.returnError(c, errorInvalidSpotifyAuthCode)
 	}
 
 	uid := c.QueryParam("state")
 	if uid == "" {
-		return h.returnError(errorInvalidSpotifyAuthState)
+		return h.returnError(c, errorInvalidSpotifyAuthState)
 	}
 
 	storedState, err := c.Cookie(spotify.AuthState)
 	if err != nil {
-		return h.returnError(errorUnableToGetCookie)
+		return h.returnError(c, errorUnableToGetCookie)
 	}
 
 	if uid != storedState.Value {
-		return h.returnError(errorInvalidSpotifyAuthState)
+		return h.returnError(c, errorInvalidSpotifyAuthState)
 	}
 
-	err = h.service.CreateAccount(uid, code)
-	if err != nil {
-		return h.returnError(errors.Wrap(err, "[SpotifyLoginCallback]: unable to create account"))
+	codeVerifier := ""
+	if h.usePKCE {
+		storedVerifier, err := c.Cookie(spotify.AuthCodeVerifier)
+		if err != nil {
+			return h.returnError(c, errorUnableToGetCookie)
+		}
+		codeVerifier = storedVerifier.Value
 	}
 
-	err = h.service.LINELinkUserToDefaultRichMenu(uid)
+	err = h.service.CreateAccount(c.Request().Context(), uid, code, codeVerifier)
 	if err != nil {
-		return h.returnError(errors.Wrap(err, "[SpotifyLoginCallback]: unable to link user to rich menu"))
+		return h.returnError(c, errors.Wrap(err, "[SpotifyLoginCallback]: unable to create account"))
 	}
 
-	return c.Redirect(302, h.loginCallBackURL)
-}
-
-func (h *Handler) Test(c echo.Context) error {
-	uid := "Ub62b8d059314f8cdc0c57a34b53634ff"
-	err := h.service.Test(uid)
+	err = h.service.LINELinkUserToDefaultRichMenu(c.Request().Context(), uid)
 	if err != nil {
-		return h.returnError(err)
+		return h.returnError(c, errors.Wrap(err, "[SpotifyLoginCallback]: unable to link user to rich menu"))
 	}
-	return c.NoContent(http.StatusOK)
+
+	return c.Redirect(302, h.loginCallBackURL)
 }
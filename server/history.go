@@ -0,0 +1,92 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/line/line-bot-sdk-go/linebot"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/bbkbbbk/sapo/line"
+	"github.com/bbkbbbk/sapo/pkg/logger"
+	"github.com/bbkbbbk/sapo/spotify"
+)
+
+const (
+	defaultHistoryLimit = 10
+
+	errorClassSpotify  = "spotify"
+	errorClassLINE     = "line"
+	errorClassNotFound = "not_found"
+	errorClassTimeout  = "timeout"
+	errorClassInternal = "internal"
+)
+
+// errorClass groups the error a command failed with into a few classes, so failures can be counted without their messages
+func errorClass(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	switch cause := errors.Cause(err).(type) {
+	case *spotify.RequestError:
+		return errorClassSpotify
+	case *line.RequestError, *linebot.APIError:
+		return errorClassLINE
+	default:
+		if cause == mongo.ErrNoDocuments {
+			return errorClassNotFound
+		}
+		if cause == context.DeadlineExceeded {
+			return errorClassTimeout
+		}
+	}
+
+	return errorClassInternal
+}
+
+func (s *service) recordCommand(ctx context.Context, cmd Command) {
+	now := time.Now()
+	cmd.CreatedAt = &now
+
+	if _, err := s.repository.CreateCommand(ctx, cmd); err != nil {
+		logger.FromContext(ctx).WithError(err).Warn("unable to record command")
+	}
+}
+
+func (s *service) createHistoryMsg(history []Command) string {
+	if len(history) == 0 {
+		return "You have not asked sapo for anything yet"
+	}
+
+	lines := []string{"Your recent commands"}
+	for _, cmd := range history {
+		text := cmd.Command
+		if cmd.Command == unknownCommand {
+			text = "something sapo didn't understand"
+		}
+		if cmd.CreatedAt != nil {
+			text = fmt.Sprintf("%s, %s", text, timeAgo(*cmd.CreatedAt))
+		}
+		lines = append(lines, fmt.Sprintf("- %s", text))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func timeAgo(t time.Time) string {
+	d := time.Since(t)
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return fmt.Sprintf("%dm ago", int(d.Minutes()))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh ago", int(d.Hours()))
+	default:
+		return fmt.Sprintf("%dd ago", int(d.Hours()/24))
+	}
+}
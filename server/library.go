@@ -0,0 +1,125 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/bbkbbbk/sapo/line/message"
+	"github.com/bbkbbbk/sapo/spotify"
+)
+
+const (
+	maxActionLabelLength = 20
+)
+
+func (s *service) getAccessTokenByUID(ctx context.Context, uid string) (string, error) {
+	acc, err := s.getAccountByUID(ctx, uid)
+	if err != nil {
+		return "", errors.Wrap(err, "[getAccessTokenByUID]: unable to get user profile")
+	}
+
+	accessToken, err := s.spotifyService.RequestAccessTokenFromRefreshToken(ctx, acc.RefreshToken)
+	if err != nil {
+		return "", errors.Wrap(err, "[getAccessTokenByUID]: unable to request access token")
+	}
+
+	return accessToken, nil
+}
+
+func (s *service) getTrack(ctx context.Context, uid, id string) (*spotify.Track, error) {
+	accessToken, err := s.getAccessTokenByUID(ctx, uid)
+	if err != nil {
+		return nil, errors.Wrap(err, "[getTrack]: unable to get access token")
+	}
+
+	track, err := s.spotifyService.GetTrack(ctx, accessToken, id)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[getTrack]: unable to get track %s", id)
+	}
+
+	return track, nil
+}
+
+// saveTrack adds the track to the user's Liked Songs
+func (s *service) saveTrack(ctx context.Context, uid, id string) error {
+	accessToken, err := s.getAccessTokenByUID(ctx, uid)
+	if err != nil {
+		return errors.Wrap(err, "[saveTrack]: unable to get access token")
+	}
+
+	if err := s.spotifyService.SaveTracks(ctx, accessToken, []string{id}); err != nil {
+		return errors.Wrapf(err, "[saveTrack]: unable to save track %s", id)
+	}
+
+	return nil
+}
+
+func (s *service) followArtist(ctx context.Context, uid, id string) error {
+	accessToken, err := s.getAccessTokenByUID(ctx, uid)
+	if err != nil {
+		return errors.Wrap(err, "[followArtist]: unable to get access token")
+	}
+
+	if err := s.spotifyService.FollowArtists(ctx, accessToken, []string{id}); err != nil {
+		return errors.Wrapf(err, "[followArtist]: unable to follow artist %s", id)
+	}
+
+	return nil
+}
+
+// actionLabel shortens name to the length LINE allows for the label of an action
+func actionLabel(name string) string {
+	label := []rune(name)
+	if len(label) > maxActionLabelLength {
+		return string(label[:maxActionLabelLength-1]) + "…"
+	}
+
+	return name
+}
+
+// trackActions are the buttons of a track bubble, preview is only offered when spotify has one
+// and follow acts on the first artist of the track
+func trackActions(track *spotify.Track) []message.Action {
+	actions := []message.Action{
+		message.NewURIAction("open in Spotify", track.ExternalURLs.URL),
+		message.NewPostbackAction("❤ Save", postbackData(postbackActionSave, track.ID), ""),
+		message.NewPostbackAction("Add to playlist", postbackData(postbackActionAddToPlaylist, track.ID), ""),
+	}
+	if track.PreviewURL != "" {
+		actions = append(actions, message.NewPostbackAction("▶ Preview", postbackData(postbackActionPreview, track.ID), ""))
+	}
+	if len(track.Artists) > 0 {
+		artist := track.Artists[0]
+		actions = append(actions, message.NewPostbackAction("Follow artist", postbackData(postbackActionFollow, artist.ID), ""))
+	}
+
+	return actions
+}
+
+func (s *service) createTrackDetailFlexMsg(track *spotify.Track) *message.Flex {
+	artists := []string{}
+	for _, a := range track.Artists {
+		artists = append(artists, a.Name)
+	}
+
+	flex := message.NewBubbleWithActions(
+		track.Name,
+		track.Name,
+		strings.Join(artists, ", "),
+		imageURL(track.Album.Images),
+		trackActions(track),
+	)
+
+	return &flex
+}
+
+// createTrackPreviewMsg is an audio message of the track's preview, or a link to the track when spotify has no preview
+func (s *service) createTrackPreviewMsg(track *spotify.Track) *message.Flex {
+	fallback := fmt.Sprintf("Spotify has no preview of %s, listen to it here %s", track.Name, track.ExternalURLs.URL)
+	audio := message.NewAudio(track.PreviewURL, spotify.PreviewDurationMs, fallback)
+
+	return &audio
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/bbkbbbk/sapo/line/message"
+	"github.com/bbkbbbk/sapo/spotify"
+)
+
+var (
+	errorTrackNotFound = errors.New("track not found")
+)
+
+// playbackReplies confirm a playback control went through
+var playbackReplies = map[string]string{
+	postbackEventNext:     "⏭ Skipped",
+	postbackEventPrevious: "⏮ Back to the previous track",
+	postbackEventPause:    "⏸ Paused",
+	postbackEventPlay:     "▶ Playing",
+}
+
+// getNowPlaying returns nil when spotify is not open on any of the user's devices
+func (s *service) getNowPlaying(ctx context.Context, uid string) (*spotify.PlaybackState, error) {
+	accessToken, err := s.getAccessTokenByUID(ctx, uid)
+	if err != nil {
+		return nil, errors.Wrap(err, "[getNowPlaying]: unable to get access token")
+	}
+
+	playing, err := s.spotifyService.GetPlaybackState(ctx, accessToken)
+	if err != nil {
+		return nil, errors.Wrap(err, "[getNowPlaying]: unable to get playback state")
+	}
+
+	return playing, nil
+}
+
+// controlPlayback runs one of the playback postback commands on the user's active device
+func (s *service) controlPlayback(ctx context.Context, uid, command string) error {
+	accessToken, err := s.getAccessTokenByUID(ctx, uid)
+	if err != nil {
+		return errors.Wrap(err, "[controlPlayback]: unable to get access token")
+	}
+
+	switch command {
+	case postbackEventNext:
+		err = s.spotifyService.SkipToNext(ctx, accessToken)
+	case postbackEventPrevious:
+		err = s.spotifyService.SkipToPrevious(ctx, accessToken)
+	case postbackEventPause:
+		err = s.spotifyService.Pause(ctx, accessToken)
+	case postbackEventPlay:
+		err = s.spotifyService.Play(ctx, accessToken)
+	default:
+		return errors.Errorf("[controlPlayback]: unknown playback command %s", command)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "[controlPlayback]: unable to %s", command)
+	}
+
+	return nil
+}
+
+// queueTrack queues the best match of query, errorTrackNotFound is returned when nothing matches
+func (s *service) queueTrack(ctx context.Context, uid, query string) (*spotify.Track, error) {
+	accessToken, err := s.getAccessTokenByUID(ctx, uid)
+	if err != nil {
+		return nil, errors.Wrap(err, "[queueTrack]: unable to get access token")
+	}
+
+	result, err := s.spotifyService.Search(ctx, accessToken, query, []string{spotify.SearchTypeTrack}, 1, spotify.MarketFromToken)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[queueTrack]: unable to search %s", query)
+	}
+	if len(result.Tracks) == 0 {
+		return nil, errors.Wrapf(errorTrackNotFound, "[queueTrack]: query %s", query)
+	}
+	track := result.Tracks[0]
+
+	if err := s.spotifyService.AddToQueue(ctx, accessToken, track.URI); err != nil {
+		return nil, errors.Wrap(err, "[queueTrack]: unable to add to queue")
+	}
+
+	return &track, nil
+}
+
+// playbackErrorMsg explains the player errors the user can fix, ok is false for any other error
+func playbackErrorMsg(err error) (string, bool) {
+	switch spotify.Reason(err) {
+	case spotify.ReasonNoActiveDevice:
+		return "sapo could not find Spotify playing anywhere, open Spotify on one of your devices, play something and try again", true
+	case spotify.ReasonPremiumRequired:
+		return "Sorry, Spotify only lets Premium users control playback from other apps", true
+	}
+
+	return "", false
+}
+
+// createNowPlayingFlexMsg shows the track with its progress, the device it plays on and whether shuffle is on
+func (s *service) createNowPlayingFlexMsg(playing *spotify.PlaybackState) *message.Flex {
+	track := playing.Item
+
+	artists := []string{}
+	for _, a := range track.Artists {
+		artists = append(artists, a.Name)
+	}
+
+	percent := 0
+	if track.Duration > 0 {
+		percent = playing.ProgressMs * 100 / track.Duration
+	}
+	progress := fmt.Sprintf("%s / %s", formatDuration(playing.ProgressMs), formatDuration(track.Duration))
+	if playing.Device.Name != "" {
+		progress = fmt.Sprintf("%s · on %s", progress, playing.Device.Name)
+	}
+	if playing.ShuffleState {
+		progress = fmt.Sprintf("%s · shuffle on", progress)
+	}
+
+	toggle := message.NewPostbackAction("▶", postbackData(postbackActionPlay, ""), "")
+	if playing.IsPlaying {
+		toggle = message.NewPostbackAction("⏸", postbackData(postbackActionPause, ""), "")
+	}
+	actions := []message.Action{
+		message.NewPostbackAction("⏮", postbackData(postbackActionPrevious, ""), ""),
+		toggle,
+		message.NewPostbackAction("⏭", postbackData(postbackActionNext, ""), ""),
+	}
+
+	flex := message.NewBubbleNowPlaying(
+		fmt.Sprintf("Now playing %s", track.Name),
+		track.Name,
+		strings.Join(artists, ", "),
+		imageURL(track.Album.Images),
+		percent,
+		progress,
+		actions,
+	)
+
+	return &flex
+}
+
+func formatDuration(ms int) string {
+	minute := (ms / 1000) / 60
+	second := (ms / 1000) % 60
+
+	return fmt.Sprintf("%d:%02d", minute, second)
+}
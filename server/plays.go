@@ -0,0 +1,109 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/bbkbbbk/sapo/pkg/logger"
+	"github.com/bbkbbbk/sapo/spotify"
+)
+
+const (
+	// JobIngestPlays is the scheduler job storing what every user played since it last ran
+	JobIngestPlays = "ingest-plays"
+
+	// maxIngestPages bounds how many pages of plays a single run pulls for a user
+	maxIngestPages = 5
+)
+
+// IngestPlays stores the recently played tracks of every user, spotify only remembers the last 50 plays
+// so it has to run more often than a user can play 50 tracks
+func (s *service) IngestPlays(ctx context.Context) error {
+	stored := int64(0)
+	filter := accountFilter{scopes: []string{spotify.ScopeUserReadRecentlyPlayed}}
+	users, failed, err := s.forEachAccount(ctx, filter, func(ctx context.Context, acc *Account) error {
+		n, err := s.ingestPlaysForUser(ctx, *acc)
+		stored += n
+		return err
+	})
+	logger.FromContext(ctx).WithField("users", users).WithField("plays", stored).WithField("failed", failed).Info("plays ingested")
+	if err != nil {
+		return errors.Wrap(err, "[IngestPlays]: unable to ingest plays")
+	}
+
+	return nil
+}
+
+// ingestPlaysForUser pulls the plays after the latest stored one, page by page from the oldest
+func (s *service) ingestPlaysForUser(ctx context.Context, acc Account) (int64, error) {
+	after := time.Unix(0, 0)
+	latest, err := s.repository.GetLatestPlay(ctx, acc.UID)
+	if err != nil && errors.Cause(err) != mongo.ErrNoDocuments {
+		return 0, errors.Wrap(err, "[ingestPlaysForUser]: unable to get latest play")
+	}
+	if latest != nil {
+		after = latest.PlayedAt
+	}
+
+	accessToken, err := s.spotifyService.RequestAccessTokenFromRefreshToken(ctx, acc.RefreshToken)
+	if err != nil {
+		return 0, errors.Wrap(err, "[ingestPlaysForUser]: unable to request access token")
+	}
+
+	stored := int64(0)
+	for page := 0; page < maxIngestPages; page++ {
+		histories, err := s.spotifyService.GetRecentlyPlayedAfter(ctx, accessToken, after)
+		if err != nil {
+			return stored, errors.Wrap(err, "[ingestPlaysForUser]: unable to get recently played")
+		}
+
+		plays := newPlays(acc.UID, histories)
+		n, err := s.repository.SavePlays(ctx, plays)
+		if err != nil {
+			return stored, errors.Wrap(err, "[ingestPlaysForUser]: unable to save plays")
+		}
+		stored += n
+
+		if len(histories) < spotify.LimitCurrentlyPlayedSize {
+			break
+		}
+		for _, play := range plays {
+			if play.PlayedAt.After(after) {
+				after = play.PlayedAt
+			}
+		}
+	}
+
+	return stored, nil
+}
+
+// newPlays skips the plays spotify returns with a played_at that does not parse, they cannot be deduplicated
+func newPlays(uid string, histories []spotify.PlayingHistory) []Play {
+	plays := []Play{}
+	for _, history := range histories {
+		playedAt, err := time.Parse(time.RFC3339, history.PlayedAt)
+		if err != nil {
+			continue
+		}
+
+		artists := []PlayArtist{}
+		for _, a := range history.Track.Artists {
+			artists = append(artists, PlayArtist{ID: a.ID, Name: a.Name})
+		}
+
+		plays = append(plays, Play{
+			UID:        uid,
+			TrackID:    history.Track.ID,
+			TrackName:  history.Track.Name,
+			Artists:    artists,
+			AlbumID:    history.Track.Album.ID,
+			DurationMs: history.Track.Duration,
+			PlayedAt:   playedAt.UTC(),
+		})
+	}
+
+	return plays
+}
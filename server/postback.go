@@ -0,0 +1,136 @@
+package server
+
+import (
+	"context"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/bbkbbbk/sapo/spotify"
+)
+
+const (
+	postbackKeyAction = "action"
+	postbackKeyID     = "id"
+
+	postbackActionAddToPlaylist  = "add-to-playlist"
+	postbackActionArtist         = "artist"
+	postbackActionArtistPlaylist = "artist-playlist"
+	postbackActionTrack          = "track"
+	postbackActionSave           = "save"
+	postbackActionFollow         = "follow"
+	postbackActionPreview        = "preview"
+	postbackActionNext           = "next"
+	postbackActionPrevious       = "previous"
+	postbackActionPause          = "pause"
+	postbackActionPlay           = "play"
+)
+
+var (
+	errorNoSapoPlaylist = errors.New("no sapo playlist")
+)
+
+// postbackCommands maps the action of a postback to the command handling it
+var postbackCommands = map[string]string{
+	postbackActionAddToPlaylist:  postbackEventAddToPlaylist,
+	postbackActionArtist:         postbackEventArtistDetail,
+	postbackActionArtistPlaylist: postbackEventArtistPlaylist,
+	postbackActionTrack:          postbackEventTrackDetail,
+	postbackActionSave:           postbackEventSaveTrack,
+	postbackActionFollow:         postbackEventFollowArtist,
+	postbackActionPreview:        postbackEventPreview,
+	postbackActionNext:           postbackEventNext,
+	postbackActionPrevious:       postbackEventPrevious,
+	postbackActionPause:          postbackEventPause,
+	postbackActionPlay:           postbackEventPlay,
+}
+
+// postbackActionsWithoutID are the playback controls, they act on whatever is playing rather than on an id
+var postbackActionsWithoutID = map[string]bool{
+	postbackActionNext:     true,
+	postbackActionPrevious: true,
+	postbackActionPause:    true,
+	postbackActionPlay:     true,
+}
+
+// postbackData encodes the data of a postback button acting on a spotify id, actions like the playback
+// controls act on no id and leave it empty
+func postbackData(action, id string) string {
+	values := url.Values{}
+	values.Add(postbackKeyAction, action)
+	if id != "" {
+		values.Add(postbackKeyID, id)
+	}
+
+	return values.Encode()
+}
+
+// parsePostback returns the command and the id a postback acts on, ok is false for data that is not a known action
+// or misses the id its action needs. The playback controls never get an id, even when the data carries one
+func parsePostback(data string) (string, string, bool) {
+	values, err := url.ParseQuery(data)
+	if err != nil {
+		return "", "", false
+	}
+
+	action := values.Get(postbackKeyAction)
+	command, ok := postbackCommands[action]
+	if !ok {
+		return "", "", false
+	}
+
+	if postbackActionsWithoutID[action] {
+		return command, "", true
+	}
+
+	id := values.Get(postbackKeyID)
+	if id == "" {
+		return "", "", false
+	}
+
+	return command, id, true
+}
+
+// addTrackToSapoPlaylist adds the track to the sapo playlist the user got most recently, added is false when the
+// playlist already has it. errorNoSapoPlaylist is returned when sapo has not made the user a playlist yet
+func (s *service) addTrackToSapoPlaylist(ctx context.Context, uid, trackID string) (*Playlist, bool, error) {
+	playlists, err := s.repository.GetRecentPlaylists(ctx, uid, 1)
+	if err != nil {
+		return nil, false, errors.Wrap(err, "[addTrackToSapoPlaylist]: unable to get playlists")
+	}
+	if len(playlists) == 0 {
+		return nil, false, errors.Wrap(errorNoSapoPlaylist, "[addTrackToSapoPlaylist]: nothing to add to")
+	}
+	playlist := playlists[0]
+
+	uri := spotify.TrackURI(trackID)
+	for _, existing := range playlist.TrackURIs {
+		if existing == uri {
+			return &playlist, false, nil
+		}
+	}
+
+	acc, err := s.getAccountByUID(ctx, uid)
+	if err != nil {
+		return nil, false, errors.Wrap(err, "[addTrackToSapoPlaylist]: unable to get user profile")
+	}
+
+	accessToken, err := s.spotifyService.RequestAccessTokenFromRefreshToken(ctx, acc.RefreshToken)
+	if err != nil {
+		return nil, false, errors.Wrap(err, "[addTrackToSapoPlaylist]: unable to request access token")
+	}
+
+	if err := s.spotifyService.AddTracksToPlaylist(ctx, accessToken, playlist.SpotifyID, []string{uri}); err != nil {
+		return nil, false, errors.Wrap(err, "[addTrackToSapoPlaylist]: unable to add track")
+	}
+
+	now := time.Now()
+	playlist.TrackURIs = append(playlist.TrackURIs, uri)
+	playlist.UpdatedAt = &now
+	if _, err := s.repository.SavePlaylist(ctx, playlist); err != nil {
+		return nil, false, errors.Wrap(err, "[addTrackToSapoPlaylist]: unable to save playlist")
+	}
+
+	return &playlist, true, nil
+}
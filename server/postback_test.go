@@ -0,0 +1,33 @@
+package server
+
+import "testing"
+
+func TestParsePostback(t *testing.T) {
+	tests := []struct {
+		name        string
+		data        string
+		wantCommand string
+		wantID      string
+		wantOK      bool
+	}{
+		{"action with id", postbackData(postbackActionSave, "4uLU6hMCjMI75M1A2tKUQC"), postbackEventSaveTrack, "4uLU6hMCjMI75M1A2tKUQC", true},
+		{"playback control without id", postbackData(postbackActionNext, ""), postbackEventNext, "", true},
+		{"playback control ignores an id", "action=pause&id=x", postbackEventPause, "", true},
+		{"action missing its id", postbackData(postbackActionFollow, ""), "", "", false},
+		{"action with empty id", "action=artist&id=", "", "", false},
+		{"unknown action", "action=delete&id=x", "", "", false},
+		{"no action", "id=x", "", "", false},
+		{"rich menu data that is not an action", "richmenu=stats", "", "", false},
+		{"malformed query", "action=%zz", "", "", false},
+		{"empty", "", "", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			command, id, ok := parsePostback(tt.data)
+			if command != tt.wantCommand || id != tt.wantID || ok != tt.wantOK {
+				t.Errorf("parsePostback(%q) = %q, %q, %v, want %q, %q, %v", tt.data, command, id, ok, tt.wantCommand, tt.wantID, tt.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"context"
+	"sync"
+
+	"github.com/line/line-bot-sdk-go/linebot"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/bbkbbbk/sapo/pkg/logger"
+)
+
+var (
+	errorQueueFull   = errors.New("webhook event queue is full")
+	errorQueueClosed = errors.New("webhook event queue is closed")
+)
+
+// EventHandler handles the events of a webhook, Service is the one the workers hand them to
+type EventHandler interface {
+	LINEEventsHandler(ctx context.Context, events []*linebot.Event) error
+}
+
+// EventQueue hands webhook events to a pool of workers so the webhook can be acknowledged right away
+type EventQueue struct {
+	service EventHandler
+	events  chan queuedEvent
+	wg      sync.WaitGroup
+	mu      sync.RWMutex
+	closed  bool
+}
+
+// queuedEvent keeps the context of the webhook request an event came with, for its logger
+type queuedEvent struct {
+	ctx   context.Context
+	event *linebot.Event
+}
+
+func NewEventQueue(s EventHandler, workers, size int) *EventQueue {
+	q := &EventQueue{
+		service: s,
+		events:  make(chan queuedEvent, size),
+	}
+
+	for i := 0; i < workers; i++ {
+		q.wg.Add(1)
+		go q.work()
+	}
+
+	return q
+}
+
+func (q *EventQueue) work() {
+	defer q.wg.Done()
+
+	for item := range q.events {
+		if err := q.service.LINEEventsHandler(item.ctx, []*linebot.Event{item.event}); err != nil {
+			logger.FromContext(item.ctx).WithError(err).Error("unable to handle event")
+		}
+	}
+}
+
+// Enqueue queues the events, they are handled after the request ctx belongs to is answered so only its logger is kept.
+// A batch is queued whole or not at all, LINE redelivers a rejected batch and none of it must be handled twice.
+// A batch larger than the queue would be rejected forever, it is admitted when the queue is empty
+// and waits for the workers to make room for the rest of it.
+func (q *EventQueue) Enqueue(ctx context.Context, events []*linebot.Event) error {
+	// the write lock keeps other batches out between checking the free space and queueing,
+	// workers only ever free up more of it
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return errorQueueClosed
+	}
+	free := cap(q.events) - len(q.events)
+	if free < len(events) && len(q.events) > 0 {
+		return errorQueueFull
+	}
+
+	// the events are handled after the webhook span ended, keep it as their parent so they stay in its trace
+	detached := trace.ContextWithSpanContext(logger.Detach(ctx), trace.SpanContextFromContext(ctx))
+	for _, event := range events {
+		q.events <- queuedEvent{ctx: detached, event: event}
+	}
+
+	return nil
+}
+
+// Closed reports whether the queue stopped accepting events
+func (q *EventQueue) Closed() bool {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	return q.closed
+}
+
+// Shutdown stops accepting events and waits for the queued ones to be handled or ctx to be done
+func (q *EventQueue) Shutdown(ctx context.Context) error {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.events)
+	}
+	q.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return errors.Wrapf(ctx.Err(), "[Shutdown]: %d events were not handled", len(q.events))
+	}
+}
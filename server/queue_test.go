@@ -0,0 +1,86 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+type countingHandler struct {
+	mu     sync.Mutex
+	events int
+}
+
+func (h *countingHandler) LINEEventsHandler(ctx context.Context, events []*linebot.Event) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.events += len(events)
+
+	return nil
+}
+
+func newEvents(n int) []*linebot.Event {
+	events := []*linebot.Event{}
+	for i := 0; i < n; i++ {
+		events = append(events, &linebot.Event{})
+	}
+
+	return events
+}
+
+func TestEventQueueEnqueue(t *testing.T) {
+	tests := []struct {
+		name    string
+		size    int
+		queued  int
+		batch   int
+		closed  bool
+		wantErr error
+	}{
+		{"fits", 3, 0, 3, false, nil},
+		{"fits next to queued events", 3, 1, 2, false, nil},
+		{"full queue rejects the whole batch", 3, 2, 2, false, errorQueueFull},
+		{"oversized batch is rejected next to queued events", 2, 1, 5, false, errorQueueFull},
+		{"closed queue", 3, 0, 1, true, errorQueueClosed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// without workers the queued events stay in the queue
+			q := NewEventQueue(&countingHandler{}, 0, tt.size)
+			if err := q.Enqueue(context.Background(), newEvents(tt.queued)); err != nil {
+				t.Fatalf("Enqueue() = %v, want nil", err)
+			}
+			if tt.closed {
+				if err := q.Shutdown(context.Background()); err != nil {
+					t.Fatalf("Shutdown() = %v, want nil", err)
+				}
+			}
+
+			if err := q.Enqueue(context.Background(), newEvents(tt.batch)); err != tt.wantErr {
+				t.Errorf("Enqueue() = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEventQueueEnqueueOversizedBatch(t *testing.T) {
+	handler := &countingHandler{}
+	q := NewEventQueue(handler, 1, 2)
+
+	if err := q.Enqueue(context.Background(), newEvents(5)); err != nil {
+		t.Fatalf("Enqueue() = %v, want nil", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := q.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown() = %v, want nil", err)
+	}
+	if handler.events != 5 {
+		t.Errorf("handled %d events, want 5", handler.events)
+	}
+}
@@ -0,0 +1,364 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/bbkbbbk/sapo/line/message"
+	"github.com/bbkbbbk/sapo/pkg/logger"
+	"github.com/bbkbbbk/sapo/spotify"
+)
+
+const (
+	// JobMonthlyRecap is the scheduler job snapshotting every user's month and pushing recaps to subscribers
+	JobMonthlyRecap = "monthly-recap"
+
+	recapPeriodLayout = "2006-01"
+	recapSnapshotSize = 20
+	recapDisplaySize  = 5
+	// recapTimeRange is the spotify time range closest to a month, it is the one the recap carousel shows
+	recapTimeRange = spotify.TimeRangeShort
+)
+
+// recapTimeRanges are snapshotted every month, each compared only with the month before of the same range
+var recapTimeRanges = []string{spotify.TimeRangeShort, spotify.TimeRangeMedium, spotify.TimeRangeLong}
+
+// RecapMovement is how the ranking of a month moved compared to the month before,
+// items ranked the same as the month before are in none of the lists
+type RecapMovement struct {
+	New      []RankedItem
+	Climbers []RecapMove
+	Fallers  []RecapMove
+	Dropped  []RankedItem
+}
+
+// RecapMove is an item ranked differently than the month before, From is its earlier rank
+type RecapMove struct {
+	Item RankedItem
+	From int
+}
+
+// RecapRange is a month of one time range and its movement, Tracks and Artists are nil when there is no earlier month to compare with
+type RecapRange struct {
+	Current  *Snapshot
+	Previous *Snapshot
+	Tracks   *RecapMovement
+	Artists  *RecapMovement
+}
+
+// Recap is a month of listening with a RecapRange for each of recapTimeRanges
+type Recap struct {
+	Period time.Time
+	Ranges map[string]*RecapRange
+}
+
+// computeRecapMovement compares the ranking of two months, items are returned in the order of their current rank
+// and dropped items in the order of their earlier rank
+func computeRecapMovement(previous, current []RankedItem) *RecapMovement {
+	previousRanks := map[string]int{}
+	for _, item := range previous {
+		previousRanks[item.ID] = item.Rank
+	}
+	currentIDs := map[string]bool{}
+
+	movement := &RecapMovement{
+		New:      []RankedItem{},
+		Climbers: []RecapMove{},
+		Fallers:  []RecapMove{},
+		Dropped:  []RankedItem{},
+	}
+	for _, item := range current {
+		currentIDs[item.ID] = true
+
+		from, ok := previousRanks[item.ID]
+		switch {
+		case !ok:
+			movement.New = append(movement.New, item)
+		case item.Rank < from:
+			movement.Climbers = append(movement.Climbers, RecapMove{Item: item, From: from})
+		case item.Rank > from:
+			movement.Fallers = append(movement.Fallers, RecapMove{Item: item, From: from})
+		}
+	}
+	for _, item := range previous {
+		if !currentIDs[item.ID] {
+			movement.Dropped = append(movement.Dropped, item)
+		}
+	}
+
+	return movement
+}
+
+func recapPeriod(t time.Time) string {
+	return t.Format(recapPeriodLayout)
+}
+
+// previousRecapPeriod returns the period of the month before t
+func previousRecapPeriod(t time.Time) string {
+	firstOfMonth := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+	return recapPeriod(firstOfMonth.AddDate(0, -1, 0))
+}
+
+func newRankedTracks(tracks []spotify.Track) []RankedItem {
+	items := []RankedItem{}
+	for i, track := range tracks {
+		artists := []string{}
+		for _, a := range track.Artists {
+			artists = append(artists, a.Name)
+		}
+
+		item := RankedItem{
+			ID:       track.ID,
+			Name:     track.Name,
+			Subtitle: strings.Join(artists, ", "),
+			URL:      track.ExternalURLs.URL,
+			Rank:     i + 1,
+		}
+		if len(track.Album.Images) > 0 {
+			item.ImageURL = track.Album.Images[0].URL
+		}
+		items = append(items, item)
+	}
+
+	return items
+}
+
+func newRankedArtists(artists []spotify.Artist) []RankedItem {
+	items := []RankedItem{}
+	for i, artist := range artists {
+		item := RankedItem{
+			ID:       artist.ID,
+			Name:     artist.Name,
+			Subtitle: "Artist",
+			URL:      artist.ExternalURLs.URL,
+			Rank:     i + 1,
+		}
+		if len(artist.Images) > 0 {
+			item.ImageURL = artist.Images[0].URL
+		}
+		items = append(items, item)
+	}
+
+	return items
+}
+
+// takeSnapshot records the current top tracks and artists of the user in the time range as the ranking of period
+func (s *service) takeSnapshot(ctx context.Context, accessToken string, acc *Account, period, timeRange string) (*Snapshot, error) {
+	tracks, err := s.spotifyService.GetTopTracks(ctx, accessToken, timeRange, recapSnapshotSize)
+	if err != nil {
+		return nil, errors.Wrap(err, "[takeSnapshot]: unable to get top tracks")
+	}
+
+	artists, err := s.spotifyService.GetTopArtists(ctx, accessToken, timeRange, recapSnapshotSize)
+	if err != nil {
+		return nil, errors.Wrap(err, "[takeSnapshot]: unable to get top artists")
+	}
+
+	now := time.Now()
+	snapshot, err := s.repository.SaveSnapshot(ctx, Snapshot{
+		UID:       acc.UID,
+		Period:    period,
+		TimeRange: timeRange,
+		Tracks:    newRankedTracks(tracks),
+		Artists:   newRankedArtists(artists),
+		CreatedAt: &now,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "[takeSnapshot]: unable to save snapshot")
+	}
+
+	return snapshot, nil
+}
+
+// createRecap snapshots the month of t in every time range and compares each with the snapshot of the same range
+// the month before, if there is one
+func (s *service) createRecap(ctx context.Context, acc *Account, t time.Time) (*Recap, error) {
+	accessToken, err := s.spotifyService.RequestAccessTokenFromRefreshToken(ctx, acc.RefreshToken)
+	if err != nil {
+		return nil, errors.Wrap(err, "[createRecap]: unable to request access token")
+	}
+
+	recap := &Recap{
+		Period: t,
+		Ranges: map[string]*RecapRange{},
+	}
+	for _, timeRange := range recapTimeRanges {
+		current, err := s.takeSnapshot(ctx, accessToken, acc, recapPeriod(t), timeRange)
+		if err != nil {
+			return nil, errors.Wrapf(err, "[createRecap]: unable to take %s snapshot", timeRange)
+		}
+		recapRange := &RecapRange{
+			Current: current,
+		}
+		recap.Ranges[timeRange] = recapRange
+
+		previous, err := s.repository.GetSnapshot(ctx, acc.UID, previousRecapPeriod(t), timeRange)
+		if errors.Cause(err) == mongo.ErrNoDocuments {
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "[createRecap]: unable to get previous %s snapshot", timeRange)
+		}
+
+		recapRange.Previous = previous
+		recapRange.Tracks = computeRecapMovement(previous.Tracks, current.Tracks)
+		recapRange.Artists = computeRecapMovement(previous.Artists, current.Artists)
+	}
+
+	return recap, nil
+}
+
+// RunMonthlyRecap closes the month that just ended for every user and pushes its recap to subscribers,
+// it is meant to run early on the 1st so the spotify time range still covers the month
+func (s *service) RunMonthlyRecap(ctx context.Context) error {
+	month := time.Now().AddDate(0, 0, -1)
+
+	filter := accountFilter{scopes: commandScopes[textEventMyRecap]}
+	done, failed, err := s.forEachAccount(ctx, filter, func(ctx context.Context, acc *Account) error {
+		return s.runMonthlyRecapForUser(ctx, acc, month)
+	})
+	logger.FromContext(ctx).WithField("done", done).WithField("failed", failed).Info("monthly recaps done")
+	if err != nil {
+		return errors.Wrap(err, "[RunMonthlyRecap]: unable to run monthly recaps")
+	}
+
+	return nil
+}
+
+func (s *service) runMonthlyRecapForUser(ctx context.Context, acc *Account, month time.Time) error {
+	recap, err := s.createRecap(ctx, acc, month)
+	if err != nil {
+		return errors.Wrap(err, "[runMonthlyRecapForUser]: unable to create recap")
+	}
+
+	if !acc.Settings.MonthlyRecap {
+		return nil
+	}
+
+	flex := s.createRecapFlexMsg(recap)
+	if flex == nil {
+		return nil
+	}
+	if err := s.lineService.PushFlexMsg(ctx, acc.UID, *flex); err != nil {
+		return errors.Wrap(err, "[runMonthlyRecapForUser]: unable to push flex message")
+	}
+
+	return nil
+}
+
+// createRecapFlexMsg returns nil when spotify has no top tracks or artists for the user yet
+func (s *service) createRecapFlexMsg(recap *Recap) *message.Flex {
+	month := recap.Period.Format("January 2006")
+	topText := "sapo recap"
+	shown := recap.Ranges[recapTimeRange]
+
+	trackMovement := map[string]string{}
+	artistMovement := map[string]string{}
+	if shown.Previous != nil {
+		trackMovement = recapMovementLabels(shown.Current.Tracks, shown.Tracks)
+		artistMovement = recapMovementLabels(shown.Current.Artists, shown.Artists)
+	}
+
+	bubbles := []message.Flex{}
+	if len(shown.Current.Tracks) > 0 {
+		bubbles = append(bubbles, message.NewBubbleReceipt("", topText, "Top Tracks", month, recapBoxes(shown.Current.Tracks, trackMovement)))
+	}
+	if len(shown.Current.Artists) > 0 {
+		bubbles = append(bubbles, message.NewBubbleReceipt("", topText, "Top Artists", month, recapBoxes(shown.Current.Artists, artistMovement)))
+	}
+	if len(bubbles) == 0 {
+		return nil
+	}
+
+	if shown.Previous != nil {
+		bubbles = append(bubbles, recapMovementBubbles(topText, "Tracks", shown.Tracks)...)
+		bubbles = append(bubbles, recapMovementBubbles(topText, "Artists", shown.Artists)...)
+	}
+
+	carousel := message.NewCarousel(
+		fmt.Sprintf("Your sapo recap for %s", month),
+		bubbles,
+	)
+
+	return &carousel
+}
+
+// recapMovementBubbles shows the new entries, climbers and dropped items of one kind, e.g. Tracks,
+// in a bubble each, kinds get bubbles of their own so one does not push the other out of the list
+func recapMovementBubbles(topText, kind string, movement *RecapMovement) []message.Flex {
+	bubbles := []message.Flex{}
+
+	if len(movement.New) > 0 {
+		boxes := recapBoxes(movement.New, map[string]string{})
+		bubbles = append(bubbles, message.NewBubbleReceipt("", topText, fmt.Sprintf("New %s", kind), "new in your top this month", boxes))
+	}
+
+	if len(movement.Climbers) > 0 {
+		items := []RankedItem{}
+		labels := map[string]string{}
+		for _, climb := range movement.Climbers {
+			items = append(items, climb.Item)
+			labels[climb.Item.ID] = fmt.Sprintf("#%d from #%d", climb.Item.Rank, climb.From)
+		}
+		bubbles = append(bubbles, message.NewBubbleReceipt("", topText, fmt.Sprintf("Climbing %s", kind), "moved up since last month", recapBoxes(items, labels)))
+	}
+
+	if len(movement.Dropped) > 0 {
+		labels := map[string]string{}
+		for _, item := range movement.Dropped {
+			labels[item.ID] = fmt.Sprintf("was #%d", item.Rank)
+		}
+		bubbles = append(bubbles, message.NewBubbleReceipt("", topText, fmt.Sprintf("Dropped %s", kind), "out of your top this month", recapBoxes(movement.Dropped, labels)))
+	}
+
+	return bubbles
+}
+
+// recapMovementLabels describes how each current item moved, e.g. "#1 ▲2", "#3 new" or "#4 -"
+func recapMovementLabels(current []RankedItem, movement *RecapMovement) map[string]string {
+	labels := map[string]string{}
+	for _, item := range current {
+		labels[item.ID] = fmt.Sprintf("#%d -", item.Rank)
+	}
+	for _, item := range movement.New {
+		labels[item.ID] = fmt.Sprintf("#%d new", item.Rank)
+	}
+	for _, climb := range movement.Climbers {
+		labels[climb.Item.ID] = fmt.Sprintf("#%d ▲%d", climb.Item.Rank, climb.From-climb.Item.Rank)
+	}
+	for _, fall := range movement.Fallers {
+		labels[fall.Item.ID] = fmt.Sprintf("#%d ▼%d", fall.Item.Rank, fall.Item.Rank-fall.From)
+	}
+
+	return labels
+}
+
+// recapBoxes renders the first items of a recap list, items without a label show their rank
+func recapBoxes(items []RankedItem, labels map[string]string) []message.BubbleReceiptBox {
+	if len(items) > recapDisplaySize {
+		items = items[:recapDisplaySize]
+	}
+
+	boxes := []message.BubbleReceiptBox{}
+	for _, item := range items {
+		label, ok := labels[item.ID]
+		if !ok {
+			label = fmt.Sprintf("#%d", item.Rank)
+		}
+
+		boxes = append(boxes, message.BubbleReceiptBox{
+			Header:   item.Name,
+			Text:     item.Subtitle,
+			LeftText: label,
+			ImageURL: item.ImageURL,
+			URL:      item.URL,
+		})
+	}
+
+	return boxes
+}
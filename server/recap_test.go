@@ -0,0 +1,80 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+)
+
+func rankedItems(ids ...string) []RankedItem {
+	items := []RankedItem{}
+	for i, id := range ids {
+		items = append(items, RankedItem{ID: id, Rank: i + 1})
+	}
+
+	return items
+}
+
+func TestComputeRecapMovement(t *testing.T) {
+	tests := []struct {
+		name     string
+		previous []RankedItem
+		current  []RankedItem
+		want     *RecapMovement
+	}{
+		{
+			name:     "first recap is all new",
+			previous: []RankedItem{},
+			current:  rankedItems("a", "b"),
+			want: &RecapMovement{
+				New:      rankedItems("a", "b"),
+				Climbers: []RecapMove{},
+				Fallers:  []RecapMove{},
+				Dropped:  []RankedItem{},
+			},
+		},
+		{
+			name:     "same ranks do not move",
+			previous: rankedItems("a", "b"),
+			current:  rankedItems("a", "b"),
+			want: &RecapMovement{
+				New:      []RankedItem{},
+				Climbers: []RecapMove{},
+				Fallers:  []RecapMove{},
+				Dropped:  []RankedItem{},
+			},
+		},
+		{
+			name:     "new, climbing, falling and dropped",
+			previous: rankedItems("a", "b", "c", "d"),
+			current:  rankedItems("c", "a", "e", "b"),
+			want: &RecapMovement{
+				New:      []RankedItem{{ID: "e", Rank: 3}},
+				Climbers: []RecapMove{{Item: RankedItem{ID: "c", Rank: 1}, From: 3}},
+				Fallers: []RecapMove{
+					{Item: RankedItem{ID: "a", Rank: 2}, From: 1},
+					{Item: RankedItem{ID: "b", Rank: 4}, From: 2},
+				},
+				Dropped: []RankedItem{{ID: "d", Rank: 4}},
+			},
+		},
+		{
+			name:     "everything dropped",
+			previous: rankedItems("a"),
+			current:  []RankedItem{},
+			want: &RecapMovement{
+				New:      []RankedItem{},
+				Climbers: []RecapMove{},
+				Fallers:  []RecapMove{},
+				Dropped:  rankedItems("a"),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := computeRecapMovement(tt.previous, tt.current); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("computeRecapMovement() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
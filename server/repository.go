@@ -5,18 +5,50 @@ import (
 	"time"
 
 	"github.com/pkg/errors"
-	"github.com/sirupsen/logrus"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/bbkbbbk/sapo/pkg/logger"
+	"github.com/bbkbbbk/sapo/spotify"
 )
 
 const (
-	collNameAccounts = "accounts"
+	collNameAccounts  = "accounts"
+	collNameCommands  = "commands"
+	collNamePlaylists = "playlists"
+	collNameSnapshots = "snapshots"
+	collNamePlays     = "plays"
+	collNameGroups    = "groups"
+
+	duplicateKeyCode = 11000
 )
 
 type Repository interface {
-	CreateAccount(acc Account) (*Account, error)
-	GetAccountByUID(uid string) (*Account, error)
+	EnsureIndexes(ctx context.Context) error
+	CreateAccount(ctx context.Context, acc Account) (*Account, error)
+	GetAccountByUID(ctx context.Context, uid string) (*Account, error)
+	UpdateAccount(ctx context.Context, acc Account) (*Account, error)
+	UpdateAccountSettings(ctx context.Context, uid string, settings AccountSettings) error
+	GetAccounts(ctx context.Context, limit, offset int) ([]Account, error)
+	GetAccountsAfter(ctx context.Context, after primitive.ObjectID, limit int) ([]Account, error)
+	GetWeeklyPlaylistSubscribers(ctx context.Context, after primitive.ObjectID, limit int) ([]Account, error)
+	CreateCommand(ctx context.Context, cmd Command) (*Command, error)
+	GetRecentCommands(ctx context.Context, uid string, limit int) ([]Command, error)
+	GetCommandStats(ctx context.Context, since time.Time) ([]CommandStats, error)
+	SavePlaylist(ctx context.Context, playlist Playlist) (*Playlist, error)
+	GetRecentPlaylists(ctx context.Context, uid string, limit int) ([]Playlist, error)
+	SaveSnapshot(ctx context.Context, snapshot Snapshot) (*Snapshot, error)
+	GetSnapshot(ctx context.Context, uid, period, timeRange string) (*Snapshot, error)
+	SavePlays(ctx context.Context, plays []Play) (int64, error)
+	GetLatestPlay(ctx context.Context, uid string) (*Play, error)
+	GetPlaysPerDay(ctx context.Context, uid string, since time.Time, timezone string) ([]PlayAggregate, error)
+	GetPlaysPerArtist(ctx context.Context, uid string, since time.Time, limit int) ([]PlayAggregate, error)
+	GetPlaysPerHour(ctx context.Context, uid string, since time.Time, timezone string) ([]PlayAggregate, error)
+	JoinGroupSession(ctx context.Context, chatID, uid string) (*GroupSession, error)
+	LeaveGroupSession(ctx context.Context, chatID, uid string) (*GroupSession, error)
+	GetGroupSession(ctx context.Context, chatID string) (*GroupSession, error)
 }
 
 type repository struct {
@@ -29,19 +61,151 @@ func NewRepository(db *mongo.Database) Repository {
 	}
 }
 
+// EnsureIndexes creates the indexes the queries rely on, creating an index that already exists does nothing
+func (r *repository) EnsureIndexes(ctx context.Context) error {
+	ctx, cancel := r.defaultContext(ctx)
+	defer cancel()
+
+	// a play is unique per user and time, SavePlays relies on it to never store the same play twice
+	plays := mongo.IndexModel{
+		Keys:    bson.D{{Key: "uid", Value: 1}, {Key: "playedAt", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}
+	if _, err := r.db.Collection(collNamePlays).Indexes().CreateOne(ctx, plays); err != nil {
+		return errors.Wrap(err, "[r.EnsureIndexes]: unable to create plays index")
+	}
+
+	return nil
+}
+
 type Account struct {
-	UID          string     `json:"uid" bson:"uid"`
-	SpotifyID    string     `json:"spotifyId" bson:"spotifyId"`
-	RefreshToken string     `json:"refreshToken" bson:"refreshToken"`
-	CreatedAt    *time.Time `json:"createdAt" bson:"createdAt"`
+	// ID is the id mongo assigned the account, jobs page through every account by it
+	ID           primitive.ObjectID `json:"-" bson:"_id,omitempty"`
+	UID          string             `json:"uid" bson:"uid"`
+	SpotifyID    string             `json:"spotifyId" bson:"spotifyId"`
+	RefreshToken string             `json:"-" bson:"refreshToken"`
+	Scopes       []string           `json:"scopes" bson:"scopes"`
+	Settings     AccountSettings    `json:"settings" bson:"settings"`
+	CreatedAt    *time.Time         `json:"createdAt" bson:"createdAt"`
+	UpdatedAt    *time.Time         `json:"updatedAt,omitempty" bson:"updatedAt,omitempty"`
+}
+
+// AccountSettings are the preferences a user changes through bot commands
+type AccountSettings struct {
+	// RollingPlaylist makes "playlist for me" refresh a single playlist instead of creating a new one each time
+	RollingPlaylist   bool   `json:"rollingPlaylist" bson:"rollingPlaylist"`
+	RollingPlaylistID string `json:"rollingPlaylistId,omitempty" bson:"rollingPlaylistId,omitempty"`
+	// WeeklyPlaylist subscribes the user to a recommended playlist pushed every week
+	WeeklyPlaylist bool `json:"weeklyPlaylist" bson:"weeklyPlaylist"`
+	// MonthlyRecap subscribes the user to the recap of the previous month pushed on the 1st
+	MonthlyRecap bool `json:"monthlyRecap" bson:"monthlyRecap"`
+}
+
+// GrantedScopes returns the spotify scopes the user agreed to,
+// accounts created before scopes were stored were granted the default scopes
+func (a *Account) GrantedScopes() []string {
+	if a.Scopes == nil {
+		return spotify.DefaultScopes
+	}
+
+	return a.Scopes
+}
+
+// Command is a text event a user sent to the bot and how handling it went
+type Command struct {
+	UID     string `json:"uid" bson:"uid"`
+	Command string `json:"command" bson:"command"`
+	Args    string `json:"args,omitempty" bson:"args,omitempty"`
+	// ChatID is the group or room the command was sent in, empty for a one-on-one chat
+	ChatID  string `json:"chatId,omitempty" bson:"chatId,omitempty"`
+	Outcome string `json:"outcome" bson:"outcome"`
+	// LatencyMs is how long handling the command took in milliseconds
+	LatencyMs  int64      `json:"latencyMs" bson:"latencyMs"`
+	ErrorClass string     `json:"errorClass,omitempty" bson:"errorClass,omitempty"`
+	CreatedAt  *time.Time `json:"createdAt" bson:"createdAt"`
+}
+
+// CommandStats summarizes the commands handled with the same outcome
+type CommandStats struct {
+	Command      string  `json:"command" bson:"command"`
+	Outcome      string  `json:"outcome" bson:"outcome"`
+	Count        int64   `json:"count" bson:"count"`
+	Users        int64   `json:"users" bson:"users"`
+	AvgLatencyMs float64 `json:"avgLatencyMs" bson:"avgLatencyMs"`
+	MaxLatencyMs int64   `json:"maxLatencyMs" bson:"maxLatencyMs"`
+}
+
+// Playlist is a playlist sapo generated for a user
+type Playlist struct {
+	UID       string     `json:"uid" bson:"uid"`
+	SpotifyID string     `json:"spotifyId" bson:"spotifyId"`
+	Name      string     `json:"name" bson:"name"`
+	URL       string     `json:"url" bson:"url"`
+	ImageURL  string     `json:"imageUrl,omitempty" bson:"imageUrl,omitempty"`
+	Seeds     []string   `json:"seeds" bson:"seeds"`
+	TrackURIs []string   `json:"trackUris" bson:"trackUris"`
+	CreatedAt *time.Time `json:"createdAt" bson:"createdAt"`
+	UpdatedAt *time.Time `json:"updatedAt" bson:"updatedAt"`
+}
+
+// Snapshot is the top tracks and artists of a user over a spotify time range, taken for a month like 2006-01
+type Snapshot struct {
+	UID       string       `json:"uid" bson:"uid"`
+	Period    string       `json:"period" bson:"period"`
+	TimeRange string       `json:"timeRange" bson:"timeRange"`
+	Tracks    []RankedItem `json:"tracks" bson:"tracks"`
+	Artists   []RankedItem `json:"artists" bson:"artists"`
+	CreatedAt *time.Time   `json:"createdAt" bson:"createdAt"`
+}
+
+// RankedItem is a track or an artist at its rank in a snapshot, starting from 1
+type RankedItem struct {
+	ID       string `json:"id" bson:"id"`
+	Name     string `json:"name" bson:"name"`
+	Subtitle string `json:"subtitle,omitempty" bson:"subtitle,omitempty"`
+	ImageURL string `json:"imageUrl,omitempty" bson:"imageUrl,omitempty"`
+	URL      string `json:"url" bson:"url"`
+	Rank     int    `json:"rank" bson:"rank"`
+}
+
+// Play is a track a user listened to, a user plays a single track at a time so uid and playedAt identify it
+type Play struct {
+	UID        string       `json:"uid" bson:"uid"`
+	TrackID    string       `json:"trackId" bson:"trackId"`
+	TrackName  string       `json:"trackName" bson:"trackName"`
+	Artists    []PlayArtist `json:"artists" bson:"artists"`
+	AlbumID    string       `json:"albumId" bson:"albumId"`
+	DurationMs int          `json:"durationMs" bson:"durationMs"`
+	PlayedAt   time.Time    `json:"playedAt" bson:"playedAt"`
+}
+
+type PlayArtist struct {
+	ID   string `json:"id" bson:"id"`
+	Name string `json:"name" bson:"name"`
+}
+
+// PlayAggregate counts the plays grouped under Key, a day like 2006-01-02, an hour from 0 to 23 or an artist id
+type PlayAggregate struct {
+	Key        string `json:"key" bson:"key"`
+	Name       string `json:"name,omitempty" bson:"name,omitempty"`
+	Plays      int64  `json:"plays" bson:"plays"`
+	DurationMs int64  `json:"durationMs" bson:"durationMs"`
 }
 
-func (r *repository) defaultContext() (context.Context, context.CancelFunc) {
-	return context.WithTimeout(context.Background(), time.Second*defaultTimeout)
+// GroupSession is the members of a LINE group or room who joined in on group playlists, ChatID is the group or room id
+type GroupSession struct {
+	ChatID    string     `json:"chatId" bson:"_id"`
+	Members   []string   `json:"members" bson:"members"`
+	CreatedAt *time.Time `json:"createdAt" bson:"createdAt"`
+	UpdatedAt *time.Time `json:"updatedAt" bson:"updatedAt"`
 }
 
-func (r *repository) CreateAccount(acc Account) (*Account, error) {
-	ctx, cancel := r.defaultContext()
+func (r *repository) defaultContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, time.Second*defaultTimeout)
+}
+
+func (r *repository) CreateAccount(ctx context.Context, acc Account) (*Account, error) {
+	ctx, cancel := r.defaultContext(ctx)
 	defer cancel()
 
 	doc, err := bson.Marshal(acc)
@@ -54,13 +218,13 @@ func (r *repository) CreateAccount(acc Account) (*Account, error) {
 		return nil, errors.Wrap(err, "[r.CreateAccount]: failed to insert account")
 	}
 
-	logrus.Printf("account created: %v", acc)
+	logger.FromContext(ctx).WithField(logger.FieldLINEUID, acc.UID).Info("account created")
 
 	return &acc, nil
 }
 
-func (r *repository) GetAccountByUID(uid string) (*Account, error) {
-	ctx, cancel := r.defaultContext()
+func (r *repository) GetAccountByUID(ctx context.Context, uid string) (*Account, error) {
+	ctx, cancel := r.defaultContext(ctx)
 	defer cancel()
 
 	filter := bson.M{
@@ -75,3 +239,498 @@ func (r *repository) GetAccountByUID(uid string) (*Account, error) {
 
 	return &acc, nil
 }
+
+func (r *repository) UpdateAccount(ctx context.Context, acc Account) (*Account, error) {
+	ctx, cancel := r.defaultContext(ctx)
+	defer cancel()
+
+	filter := bson.M{
+		"uid": acc.UID,
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"spotifyId":    acc.SpotifyID,
+			"refreshToken": acc.RefreshToken,
+			"scopes":       acc.Scopes,
+			"updatedAt":    acc.UpdatedAt,
+		},
+	}
+
+	_, err := r.db.Collection(collNameAccounts).UpdateOne(ctx, filter, update)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[r.UpdateAccount]: unable to update account with uid %v", acc.UID)
+	}
+
+	return &acc, nil
+}
+
+func (r *repository) UpdateAccountSettings(ctx context.Context, uid string, settings AccountSettings) error {
+	ctx, cancel := r.defaultContext(ctx)
+	defer cancel()
+
+	filter := bson.M{
+		"uid": uid,
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"settings": settings,
+		},
+	}
+
+	res, err := r.db.Collection(collNameAccounts).UpdateOne(ctx, filter, update)
+	if err != nil {
+		return errors.Wrapf(err, "[r.UpdateAccountSettings]: unable to update settings of account with uid %v", uid)
+	}
+	if res.MatchedCount == 0 {
+		return errors.Wrapf(mongo.ErrNoDocuments, "[r.UpdateAccountSettings]: no account with uid %v", uid)
+	}
+
+	return nil
+}
+
+func (r *repository) GetAccounts(ctx context.Context, limit, offset int) ([]Account, error) {
+	ctx, cancel := r.defaultContext(ctx)
+	defer cancel()
+
+	opts := options.Find().
+		SetSort(bson.M{"createdAt": -1}).
+		SetLimit(int64(limit)).
+		SetSkip(int64(offset))
+
+	cursor, err := r.db.Collection(collNameAccounts).Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, errors.Wrap(err, "[r.GetAccounts]: unable to find accounts")
+	}
+
+	accounts := []Account{}
+	if err := cursor.All(ctx, &accounts); err != nil {
+		return nil, errors.Wrap(err, "[r.GetAccounts]: unable to decode accounts")
+	}
+
+	return accounts, nil
+}
+
+// GetAccountsAfter pages through every account in the order they were created, after is the ID of the last account
+// of the previous page, zero for the first page. Accounts created or changed while paging do not shift the pages.
+func (r *repository) GetAccountsAfter(ctx context.Context, after primitive.ObjectID, limit int) ([]Account, error) {
+	accounts, err := r.findAccountsAfter(ctx, bson.M{}, after, limit)
+	if err != nil {
+		return nil, errors.Wrap(err, "[r.GetAccountsAfter]: unable to find accounts")
+	}
+
+	return accounts, nil
+}
+
+// GetWeeklyPlaylistSubscribers pages through the accounts subscribed to the weekly playlist like GetAccountsAfter
+func (r *repository) GetWeeklyPlaylistSubscribers(ctx context.Context, after primitive.ObjectID, limit int) ([]Account, error) {
+	filter := bson.M{
+		"settings.weeklyPlaylist": true,
+	}
+
+	accounts, err := r.findAccountsAfter(ctx, filter, after, limit)
+	if err != nil {
+		return nil, errors.Wrap(err, "[r.GetWeeklyPlaylistSubscribers]: unable to find accounts")
+	}
+
+	return accounts, nil
+}
+
+func (r *repository) findAccountsAfter(ctx context.Context, filter bson.M, after primitive.ObjectID, limit int) ([]Account, error) {
+	ctx, cancel := r.defaultContext(ctx)
+	defer cancel()
+
+	if !after.IsZero() {
+		filter["_id"] = bson.M{"$gt": after}
+	}
+	opts := options.Find().
+		SetSort(bson.M{"_id": 1}).
+		SetLimit(int64(limit))
+
+	cursor, err := r.db.Collection(collNameAccounts).Find(ctx, filter, opts)
+	if err != nil {
+		return nil, errors.Wrap(err, "[r.findAccountsAfter]: unable to find accounts")
+	}
+
+	accounts := []Account{}
+	if err := cursor.All(ctx, &accounts); err != nil {
+		return nil, errors.Wrap(err, "[r.findAccountsAfter]: unable to decode accounts")
+	}
+
+	return accounts, nil
+}
+
+func (r *repository) CreateCommand(ctx context.Context, cmd Command) (*Command, error) {
+	ctx, cancel := r.defaultContext(ctx)
+	defer cancel()
+
+	_, err := r.db.Collection(collNameCommands).InsertOne(ctx, cmd)
+	if err != nil {
+		return nil, errors.Wrap(err, "[r.CreateCommand]: failed to insert command")
+	}
+
+	return &cmd, nil
+}
+
+// GetRecentCommands returns the latest commands first, uid is optional and narrows them to a single user
+func (r *repository) GetRecentCommands(ctx context.Context, uid string, limit int) ([]Command, error) {
+	ctx, cancel := r.defaultContext(ctx)
+	defer cancel()
+
+	filter := bson.M{}
+	if uid != "" {
+		filter["uid"] = uid
+	}
+	opts := options.Find().
+		SetSort(bson.M{"createdAt": -1}).
+		SetLimit(int64(limit))
+
+	cursor, err := r.db.Collection(collNameCommands).Find(ctx, filter, opts)
+	if err != nil {
+		return nil, errors.Wrap(err, "[r.GetRecentCommands]: unable to find commands")
+	}
+
+	commands := []Command{}
+	if err := cursor.All(ctx, &commands); err != nil {
+		return nil, errors.Wrap(err, "[r.GetRecentCommands]: unable to decode commands")
+	}
+
+	return commands, nil
+}
+
+// GetCommandStats groups the commands handled since the given time by command and outcome, the most used first
+func (r *repository) GetCommandStats(ctx context.Context, since time.Time) ([]CommandStats, error) {
+	ctx, cancel := r.defaultContext(ctx)
+	defer cancel()
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"createdAt": bson.M{"$gte": since}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":          bson.M{"command": "$command", "outcome": "$outcome"},
+			"count":        bson.M{"$sum": 1},
+			"users":        bson.M{"$addToSet": "$uid"},
+			"avgLatencyMs": bson.M{"$avg": "$latencyMs"},
+			"maxLatencyMs": bson.M{"$max": "$latencyMs"},
+		}}},
+		{{Key: "$project", Value: bson.M{
+			"_id":          0,
+			"command":      "$_id.command",
+			"outcome":      "$_id.outcome",
+			"count":        1,
+			"users":        bson.M{"$size": "$users"},
+			"avgLatencyMs": 1,
+			"maxLatencyMs": 1,
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "count", Value: -1}, {Key: "command", Value: 1}}}},
+	}
+
+	cursor, err := r.db.Collection(collNameCommands).Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, errors.Wrap(err, "[r.GetCommandStats]: unable to aggregate commands")
+	}
+
+	stats := []CommandStats{}
+	if err := cursor.All(ctx, &stats); err != nil {
+		return nil, errors.Wrap(err, "[r.GetCommandStats]: unable to decode command stats")
+	}
+
+	return stats, nil
+}
+
+// SavePlaylist records a generated playlist, a refreshed rolling playlist replaces its earlier record
+func (r *repository) SavePlaylist(ctx context.Context, playlist Playlist) (*Playlist, error) {
+	ctx, cancel := r.defaultContext(ctx)
+	defer cancel()
+
+	filter := bson.M{
+		"uid":       playlist.UID,
+		"spotifyId": playlist.SpotifyID,
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"name":      playlist.Name,
+			"url":       playlist.URL,
+			"imageUrl":  playlist.ImageURL,
+			"seeds":     playlist.Seeds,
+			"trackUris": playlist.TrackURIs,
+			"updatedAt": playlist.UpdatedAt,
+		},
+		"$setOnInsert": bson.M{
+			"createdAt": playlist.CreatedAt,
+		},
+	}
+	opts := options.Update().SetUpsert(true)
+
+	_, err := r.db.Collection(collNamePlaylists).UpdateOne(ctx, filter, update, opts)
+	if err != nil {
+		return nil, errors.Wrap(err, "[r.SavePlaylist]: failed to save playlist")
+	}
+
+	return &playlist, nil
+}
+
+// GetRecentPlaylists returns the playlists generated for the user, the latest created or refreshed first
+func (r *repository) GetRecentPlaylists(ctx context.Context, uid string, limit int) ([]Playlist, error) {
+	ctx, cancel := r.defaultContext(ctx)
+	defer cancel()
+
+	filter := bson.M{
+		"uid": uid,
+	}
+	opts := options.Find().
+		SetSort(bson.M{"updatedAt": -1}).
+		SetLimit(int64(limit))
+
+	cursor, err := r.db.Collection(collNamePlaylists).Find(ctx, filter, opts)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[r.GetRecentPlaylists]: unable to find playlists of uid %v", uid)
+	}
+
+	playlists := []Playlist{}
+	if err := cursor.All(ctx, &playlists); err != nil {
+		return nil, errors.Wrap(err, "[r.GetRecentPlaylists]: unable to decode playlists")
+	}
+
+	return playlists, nil
+}
+
+// SaveSnapshot keeps a single snapshot per user, period and time range, a newer one replaces it
+func (r *repository) SaveSnapshot(ctx context.Context, snapshot Snapshot) (*Snapshot, error) {
+	ctx, cancel := r.defaultContext(ctx)
+	defer cancel()
+
+	filter := bson.M{
+		"uid":       snapshot.UID,
+		"period":    snapshot.Period,
+		"timeRange": snapshot.TimeRange,
+	}
+	opts := options.Replace().SetUpsert(true)
+
+	_, err := r.db.Collection(collNameSnapshots).ReplaceOne(ctx, filter, snapshot, opts)
+	if err != nil {
+		return nil, errors.Wrap(err, "[r.SaveSnapshot]: failed to save snapshot")
+	}
+
+	return &snapshot, nil
+}
+
+func (r *repository) GetSnapshot(ctx context.Context, uid, period, timeRange string) (*Snapshot, error) {
+	ctx, cancel := r.defaultContext(ctx)
+	defer cancel()
+
+	filter := bson.M{
+		"uid":       uid,
+		"period":    period,
+		"timeRange": timeRange,
+	}
+
+	var snapshot Snapshot
+	err := r.db.Collection(collNameSnapshots).FindOne(ctx, filter).Decode(&snapshot)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[r.GetSnapshot]: unable to retrieve %s snapshot of %s with uid %v", timeRange, period, uid)
+	}
+
+	return &snapshot, nil
+}
+
+// SavePlays stores the plays not stored yet and returns how many were new
+func (r *repository) SavePlays(ctx context.Context, plays []Play) (int64, error) {
+	if len(plays) == 0 {
+		return 0, nil
+	}
+
+	ctx, cancel := r.defaultContext(ctx)
+	defer cancel()
+
+	models := []mongo.WriteModel{}
+	for _, play := range plays {
+		filter := bson.M{
+			"uid":      play.UID,
+			"playedAt": play.PlayedAt,
+		}
+		model := mongo.NewUpdateOneModel().
+			SetFilter(filter).
+			SetUpdate(bson.M{"$setOnInsert": play}).
+			SetUpsert(true)
+		models = append(models, model)
+	}
+
+	// two runs ingesting at once can both try to insert a play, the unique index rejects the second as already stored
+	res, err := r.db.Collection(collNamePlays).BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	if err != nil && !isDuplicateKeyError(err) {
+		return 0, errors.Wrap(err, "[r.SavePlays]: unable to save plays")
+	}
+
+	return res.UpsertedCount, nil
+}
+
+// isDuplicateKeyError is true when the only writes of a bulk write that failed hit a unique index
+func isDuplicateKeyError(err error) bool {
+	e, ok := err.(mongo.BulkWriteException)
+	if !ok || e.WriteConcernError != nil || len(e.WriteErrors) == 0 {
+		return false
+	}
+	for _, we := range e.WriteErrors {
+		if we.Code != duplicateKeyCode {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (r *repository) GetLatestPlay(ctx context.Context, uid string) (*Play, error) {
+	ctx, cancel := r.defaultContext(ctx)
+	defer cancel()
+
+	filter := bson.M{
+		"uid": uid,
+	}
+	opts := options.FindOne().SetSort(bson.M{"playedAt": -1})
+
+	var play Play
+	err := r.db.Collection(collNamePlays).FindOne(ctx, filter, opts).Decode(&play)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[r.GetLatestPlay]: unable to retrieve latest play of uid %v", uid)
+	}
+
+	return &play, nil
+}
+
+// GetPlaysPerDay counts the plays of every day since the given time, days start at midnight in timezone
+func (r *repository) GetPlaysPerDay(ctx context.Context, uid string, since time.Time, timezone string) ([]PlayAggregate, error) {
+	aggregates, err := r.aggregatePlaysByDate(ctx, uid, since, "%Y-%m-%d", timezone)
+	if err != nil {
+		return nil, errors.Wrap(err, "[r.GetPlaysPerDay]: unable to aggregate plays")
+	}
+
+	return aggregates, nil
+}
+
+// GetPlaysPerHour counts the plays of every hour of the day since the given time, keyed 00 to 23 in timezone
+func (r *repository) GetPlaysPerHour(ctx context.Context, uid string, since time.Time, timezone string) ([]PlayAggregate, error) {
+	aggregates, err := r.aggregatePlaysByDate(ctx, uid, since, "%H", timezone)
+	if err != nil {
+		return nil, errors.Wrap(err, "[r.GetPlaysPerHour]: unable to aggregate plays")
+	}
+
+	return aggregates, nil
+}
+
+// GetPlaysPerArtist counts the plays of the most played artists since the given time, a play counts for each of its artists.
+// A limit of 0 returns every artist
+func (r *repository) GetPlaysPerArtist(ctx context.Context, uid string, since time.Time, limit int) ([]PlayAggregate, error) {
+	ctx, cancel := r.defaultContext(ctx)
+	defer cancel()
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"uid": uid, "playedAt": bson.M{"$gte": since}}}},
+		{{Key: "$unwind", Value: "$artists"}},
+		{{Key: "$group", Value: bson.M{
+			"_id":        "$artists.id",
+			"name":       bson.M{"$first": "$artists.name"},
+			"plays":      bson.M{"$sum": 1},
+			"durationMs": bson.M{"$sum": "$durationMs"},
+		}}},
+		{{Key: "$project", Value: bson.M{"_id": 0, "key": "$_id", "name": 1, "plays": 1, "durationMs": 1}}},
+		{{Key: "$sort", Value: bson.D{{Key: "plays", Value: -1}, {Key: "key", Value: 1}}}},
+	}
+	if limit > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$limit", Value: limit}})
+	}
+
+	cursor, err := r.db.Collection(collNamePlays).Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, errors.Wrap(err, "[r.GetPlaysPerArtist]: unable to aggregate plays")
+	}
+
+	aggregates := []PlayAggregate{}
+	if err := cursor.All(ctx, &aggregates); err != nil {
+		return nil, errors.Wrap(err, "[r.GetPlaysPerArtist]: unable to decode plays")
+	}
+
+	return aggregates, nil
+}
+
+// aggregatePlaysByDate groups plays by their time formatted with a $dateToString format, sorted by key
+func (r *repository) aggregatePlaysByDate(ctx context.Context, uid string, since time.Time, format, timezone string) ([]PlayAggregate, error) {
+	ctx, cancel := r.defaultContext(ctx)
+	defer cancel()
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"uid": uid, "playedAt": bson.M{"$gte": since}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":        bson.M{"$dateToString": bson.M{"format": format, "date": "$playedAt", "timezone": timezone}},
+			"plays":      bson.M{"$sum": 1},
+			"durationMs": bson.M{"$sum": "$durationMs"},
+		}}},
+		{{Key: "$project", Value: bson.M{"_id": 0, "key": "$_id", "plays": 1, "durationMs": 1}}},
+		{{Key: "$sort", Value: bson.M{"key": 1}}},
+	}
+
+	cursor, err := r.db.Collection(collNamePlays).Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, errors.Wrap(err, "[r.aggregatePlaysByDate]: unable to aggregate plays")
+	}
+
+	aggregates := []PlayAggregate{}
+	if err := cursor.All(ctx, &aggregates); err != nil {
+		return nil, errors.Wrap(err, "[r.aggregatePlaysByDate]: unable to decode plays")
+	}
+
+	return aggregates, nil
+}
+
+// JoinGroupSession adds the user to the session of the chat, starting the session when it is the first to join
+func (r *repository) JoinGroupSession(ctx context.Context, chatID, uid string) (*GroupSession, error) {
+	ctx, cancel := r.defaultContext(ctx)
+	defer cancel()
+
+	now := time.Now()
+	update := bson.M{
+		"$addToSet":    bson.M{"members": uid},
+		"$set":         bson.M{"updatedAt": now},
+		"$setOnInsert": bson.M{"createdAt": now},
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var session GroupSession
+	err := r.db.Collection(collNameGroups).FindOneAndUpdate(ctx, bson.M{"_id": chatID}, update, opts).Decode(&session)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[r.JoinGroupSession]: unable to join session of chat %s", chatID)
+	}
+
+	return &session, nil
+}
+
+// LeaveGroupSession removes the user from the session of the chat, mongo.ErrNoDocuments is returned when the chat has no session
+func (r *repository) LeaveGroupSession(ctx context.Context, chatID, uid string) (*GroupSession, error) {
+	ctx, cancel := r.defaultContext(ctx)
+	defer cancel()
+
+	update := bson.M{
+		"$pull": bson.M{"members": uid},
+		"$set":  bson.M{"updatedAt": time.Now()},
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var session GroupSession
+	err := r.db.Collection(collNameGroups).FindOneAndUpdate(ctx, bson.M{"_id": chatID}, update, opts).Decode(&session)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[r.LeaveGroupSession]: unable to leave session of chat %s", chatID)
+	}
+
+	return &session, nil
+}
+
+func (r *repository) GetGroupSession(ctx context.Context, chatID string) (*GroupSession, error) {
+	ctx, cancel := r.defaultContext(ctx)
+	defer cancel()
+
+	var session GroupSession
+	err := r.db.Collection(collNameGroups).FindOne(ctx, bson.M{"_id": chatID}).Decode(&session)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[r.GetGroupSession]: unable to retrieve session of chat %s", chatID)
+	}
+
+	return &session, nil
+}
@@ -1,12 +1,42 @@
 package server
 
-import "github.com/labstack/echo"
+import (
+	"crypto/subtle"
+
+	"github.com/labstack/echo"
+	"github.com/labstack/echo/middleware"
+
+	"github.com/bbkbbbk/sapo/pkg/metrics"
+)
 
 func RoutesRegister(e *echo.Echo, h Handler) {
 	e.GET("/", h.HomePage)
+	e.GET("/healthz", h.Healthz)
+	e.GET("/readyz", h.Readyz)
 	e.POST("/line-callback", h.LINECallback)
 	e.GET("/signup", h.SignUp)
 	e.GET("/spotify-callback", h.SpotifyCallback)
+}
+
+// AdminRoutesRegister exposes the admin api and the prometheus metrics behind a bearer token,
+// nothing is registered when token is empty
+func AdminRoutesRegister(e *echo.Echo, h AdminHandler, token string) {
+	if token == "" {
+		return
+	}
+
+	keyAuth := middleware.KeyAuth(func(key string, c echo.Context) (bool, error) {
+		return subtle.ConstantTimeCompare([]byte(key), []byte(token)) == 1, nil
+	})
+	e.GET("/metrics", echo.WrapHandler(metrics.Handler()), keyAuth)
 
-	e.GET("/test", h.Test)
+	admin := e.Group("/admin", keyAuth)
+	admin.GET("/accounts", h.ListAccounts)
+	admin.GET("/accounts/:uid", h.GetAccount)
+	admin.POST("/accounts/:uid/push", h.PushMessage)
+	admin.POST("/accounts/:uid/rich-menu", h.RelinkRichMenu)
+	admin.GET("/commands", h.ListCommands)
+	admin.GET("/commands/stats", h.CommandStats)
+	admin.GET("/jobs", h.ListJobs)
+	admin.POST("/jobs/:name/run", h.RunJob)
 }
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/bbkbbbk/sapo/line/message"
+	"github.com/bbkbbbk/sapo/spotify"
+)
+
+const (
+	// searchResultLimit is per type, a LINE carousel holds at most 12 bubbles
+	searchResultLimit = 5
+)
+
+func (s *service) search(ctx context.Context, uid, query string) (*spotify.SearchResult, error) {
+	acc, err := s.getAccountByUID(ctx, uid)
+	if err != nil {
+		return nil, errors.Wrap(err, "[search]: unable to get user profile")
+	}
+
+	accessToken, err := s.spotifyService.RequestAccessTokenFromRefreshToken(ctx, acc.RefreshToken)
+	if err != nil {
+		return nil, errors.Wrap(err, "[search]: unable to request access token")
+	}
+
+	types := []string{spotify.SearchTypeTrack, spotify.SearchTypeArtist}
+	result, err := s.spotifyService.Search(ctx, accessToken, query, types, searchResultLimit, spotify.MarketFromToken)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[search]: unable to search %s", query)
+	}
+
+	return result, nil
+}
+
+// createCarouselSearchResult shows the matching tracks then the matching artists, nil is returned when nothing matched
+func (s *service) createCarouselSearchResult(result *spotify.SearchResult) *message.Flex {
+	bubbles := []message.Flex{}
+	for _, track := range result.Tracks {
+		artists := []string{}
+		for _, a := range track.Artists {
+			artists = append(artists, a.Name)
+		}
+
+		track := track
+		bubble := message.NewBubbleWithActions(
+			track.Name,
+			track.Name,
+			strings.Join(artists, ", "),
+			imageURL(track.Album.Images),
+			trackActions(&track),
+		)
+		bubbles = append(bubbles, bubble)
+	}
+
+	for _, artist := range result.Artists {
+		actions := []message.Action{
+			message.NewURIAction("open in Spotify", artist.ExternalURLs.URL),
+			message.NewPostbackAction("more about this artist", postbackData(postbackActionArtist, artist.ID), artist.Name),
+		}
+		bubble := message.NewBubbleWithActions(
+			artist.Name,
+			artist.Name,
+			fmt.Sprintf("%d followers", artist.Followers.Total),
+			imageURL(artist.Images),
+			actions,
+		)
+		bubbles = append(bubbles, bubble)
+	}
+
+	if len(bubbles) == 0 {
+		return nil
+	}
+
+	carousel := message.NewCarousel(
+		"Search result",
+		bubbles,
+	)
+
+	return &carousel
+}
@@ -1,16 +1,23 @@
 package server
 
 import (
+	"context"
 	"fmt"
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
 
 	"github.com/bbkbbbk/sapo/line"
 	"github.com/bbkbbbk/sapo/line/message"
+	"github.com/bbkbbbk/sapo/pkg/logger"
+	"github.com/bbkbbbk/sapo/pkg/metrics"
+	"github.com/bbkbbbk/sapo/pkg/tracing"
 	"github.com/bbkbbbk/sapo/spotify"
 	"github.com/line/line-bot-sdk-go/linebot"
 	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 const (
@@ -25,46 +32,186 @@ const (
 	textEventMyTopArtists   = "my top artists"
 	textEventCreatePlaylist = "playlist for me"
 	textEventRandom         = "random"
+	textEventHistory        = "history"
+	textEventMyPlaylists    = "my playlists"
+	textEventRolling        = "rolling playlist"
+	textEventRollingOn      = "rolling playlist on"
+	textEventRollingOff     = "rolling playlist off"
+	textEventSubscribe      = "subscribe weekly"
+	textEventUnsubscribe    = "unsubscribe"
+	textEventMyRecap        = "my recap"
+	textEventSubscribeRecap = "subscribe recap"
+	textEventUnsubRecap     = "unsubscribe recap"
+	textEventMyStats        = "my stats"
+	textEventMyGenres       = "my genres"
+	textEventGenrePlaylist  = "genre playlist"
+	textEventSearch         = "search"
+	textEventFind           = "find"
+	textEventNowPlaying     = "now playing"
+	textEventQueue          = "queue"
+	textEventJoin           = "join"
+	textEventLeave          = "leave"
+	textEventGroupPlaylist  = "group playlist"
+
+	postbackEventAddToPlaylist  = "add to playlist"
+	postbackEventArtistDetail   = "artist detail"
+	postbackEventArtistPlaylist = "artist playlist"
+	postbackEventTrackDetail    = "track detail"
+	postbackEventSaveTrack      = "save track"
+	postbackEventFollowArtist   = "follow artist"
+	postbackEventPreview        = "preview"
+	postbackEventNext           = "next track"
+	postbackEventPrevious       = "previous track"
+	postbackEventPause          = "pause"
+	postbackEventPlay           = "play"
+
+	unknownCommand       = "unknown"
+	outcomeMissingScopes = "missing_scopes"
 )
 
+// commands are the text events the bot understands, anything else is reported as unknownCommand in metrics
+var commands = map[string]bool{
+	textEventEcho:           true,
+	textEventMyTop:          true,
+	textEventMyTopTracks:    true,
+	textEventMyTopArtists:   true,
+	textEventCreatePlaylist: true,
+	textEventRandom:         true,
+	textEventHistory:        true,
+	textEventMyPlaylists:    true,
+	textEventRolling:        true,
+	textEventRollingOn:      true,
+	textEventRollingOff:     true,
+	textEventSubscribe:      true,
+	textEventUnsubscribe:    true,
+	textEventMyRecap:        true,
+	textEventSubscribeRecap: true,
+	textEventUnsubRecap:     true,
+	textEventMyStats:        true,
+	textEventMyGenres:       true,
+	textEventNowPlaying:     true,
+	textEventJoin:           true,
+	textEventLeave:          true,
+	textEventGroupPlaylist:  true,
+}
+
+// argCommands are the commands followed by arguments, e.g. "genre playlist k-pop"
+var argCommands = []string{
+	textEventGenrePlaylist,
+	textEventSearch,
+	textEventFind,
+	textEventQueue,
+}
+
+// parseCommand splits a lowercased text event into the command and its arguments, text that is not a command
+// is unknownCommand without arguments so whatever the user chatted is never stored
+func parseCommand(msg string) (string, string) {
+	if commands[msg] {
+		return msg, ""
+	}
+
+	for _, command := range argCommands {
+		if args := strings.TrimPrefix(msg, command+" "); args != msg && strings.TrimSpace(args) != "" {
+			return command, strings.TrimSpace(args)
+		}
+	}
+
+	return unknownCommand, ""
+}
+
+// commandScopes declares the spotify scopes each command needs, commands not listed need none
+var commandScopes = map[string][]string{
+	textEventMyTopTracks:    {spotify.ScopeUserTopRead},
+	textEventMyTopArtists:   {spotify.ScopeUserTopRead},
+	textEventCreatePlaylist: {spotify.ScopeUserReadRecentlyPlayed, spotify.ScopePlaylistModifyPublic},
+	textEventRandom:         {spotify.ScopeUserReadRecentlyPlayed},
+	textEventSubscribe:      {spotify.ScopeUserReadRecentlyPlayed, spotify.ScopePlaylistModifyPublic},
+	textEventMyRecap:        {spotify.ScopeUserTopRead},
+	textEventSubscribeRecap: {spotify.ScopeUserTopRead},
+	textEventMyStats:        {spotify.ScopeUserReadRecentlyPlayed},
+	textEventMyGenres:       {spotify.ScopeUserTopRead},
+	textEventGenrePlaylist:  {spotify.ScopeUserTopRead, spotify.ScopePlaylistModifyPublic},
+	textEventNowPlaying:     {spotify.ScopeUserReadPlaybackState},
+	textEventJoin:           {spotify.ScopeUserTopRead},
+	textEventGroupPlaylist:  {spotify.ScopeUserTopRead, spotify.ScopePlaylistModifyPrivate},
+	textEventQueue:          {spotify.ScopeUserModifyPlaybackState},
+
+	postbackEventAddToPlaylist:  {spotify.ScopePlaylistModifyPublic},
+	postbackEventArtistPlaylist: {spotify.ScopePlaylistModifyPublic},
+	postbackEventSaveTrack:      {spotify.ScopeUserLibraryModify},
+	postbackEventFollowArtist:   {spotify.ScopeUserFollowModify},
+	postbackEventNext:           {spotify.ScopeUserModifyPlaybackState},
+	postbackEventPrevious:       {spotify.ScopeUserModifyPlaybackState},
+	postbackEventPause:          {spotify.ScopeUserModifyPlaybackState},
+	postbackEventPlay:           {spotify.ScopeUserModifyPlaybackState},
+}
+
 type Service interface {
-	Test(uid string) error
-	CreateAccount(uid, code string) error
-	GetSpotifyAuthURL(state string) string
+	CreateAccount(ctx context.Context, uid, code, codeVerifier string) error
+	VerifyLINEIDToken(ctx context.Context, idToken string) (string, error)
+	GetSpotifyAuthURL(ctx context.Context, state, codeChallenge string, scopes []string) string
 	ParseLINERequest(req *http.Request) ([]*linebot.Event, error)
-	LINEEventsHandler(events []*linebot.Event) error
-	LINELinkUserToLoginRichMenu(uid string) error
-	LINELinkUserToDefaultRichMenu(uid string) error
+	LINEEventsHandler(ctx context.Context, events []*linebot.Event) error
+	LINELinkUserToLoginRichMenu(ctx context.Context, uid string) error
+	LINELinkUserToDefaultRichMenu(ctx context.Context, uid string) error
+	LINELinkUserToRichMenuAlias(ctx context.Context, uid, alias string) error
+	LINEPushTextMessage(ctx context.Context, uid, msg string) error
+	GetAccount(ctx context.Context, uid string) (*Account, error)
+	GetAccounts(ctx context.Context, limit, offset int) ([]Account, error)
+	GetRecentCommands(ctx context.Context, uid string, limit int) ([]Command, error)
+	GetCommandStats(ctx context.Context, since time.Time) ([]CommandStats, error)
+	DeliverWeeklyPlaylists(ctx context.Context) error
+	RunMonthlyRecap(ctx context.Context) error
+	IngestPlays(ctx context.Context) error
 }
 
 type service struct {
-	basedURL       string
-	lineService    line.Service
-	spotifyService spotify.Service
-	repository     Repository
+	basedURL        string
+	liffLoginURL    string
+	lineService     line.Service
+	idTokenVerifier line.IDTokenVerifier
+	spotifyService  spotify.Service
+	repository      Repository
 }
 
-func NewService(url string, line line.Service, spotify spotify.Service, repo Repository) Service {
+func NewService(url, liffLoginURL string, line line.Service, verifier line.IDTokenVerifier, spotify spotify.Service, repo Repository) Service {
 	return &service{
-		basedURL:       url,
-		lineService:    line,
-		spotifyService: spotify,
-		repository:     repo,
+		basedURL:        url,
+		liffLoginURL:    liffLoginURL,
+		lineService:     line,
+		idTokenVerifier: verifier,
+		spotifyService:  spotify,
+		repository:      repo,
 	}
 }
 
-func (s *service) GetSpotifyAuthURL(state string) string {
-	return s.spotifyService.GetAuthURL(state)
+// GetSpotifyAuthURL asks for the default scopes, the scopes the user already granted and any extra scopes requested
+func (s *service) GetSpotifyAuthURL(ctx context.Context, state, codeChallenge string, scopes []string) string {
+	granted := []string{}
+	if acc, err := s.repository.GetAccountByUID(ctx, state); err == nil {
+		granted = acc.GrantedScopes()
+	}
+
+	return s.spotifyService.GetAuthURL(state, codeChallenge, spotify.MergeScopes(spotify.DefaultScopes, granted, scopes))
+}
+
+func (s *service) VerifyLINEIDToken(ctx context.Context, idToken string) (string, error) {
+	claims, err := s.idTokenVerifier.Verify(ctx, idToken)
+	if err != nil {
+		return "", errors.Wrap(err, "[s.VerifyLINEIDToken]: unable to verify id token")
+	}
+
+	return claims.UserID(), nil
 }
 
-func (s *service) CreateAccount(uid, code string) error {
+func (s *service) CreateAccount(ctx context.Context, uid, code, codeVerifier string) error {
 	now := time.Now()
-	accToken, refToken, err := s.spotifyService.RequestToken(code)
+	token, err := s.spotifyService.RequestToken(ctx, code, codeVerifier)
 	if err != nil {
 		return errors.Wrap(err, "[s.CreateAccount]: unable to get token from spotify")
 	}
 
-	profile, err := s.spotifyService.GetUserProfile(accToken)
+	profile, err := s.spotifyService.GetUserProfile(ctx, token.AccessToken)
 	if err != nil {
 		return errors.Wrap(err, "[s.CreateAccount]: unable to get spotify user profile")
 	}
@@ -73,11 +220,25 @@ func (s *service) CreateAccount(uid, code string) error {
 	acc := Account{
 		UID:          uid,
 		SpotifyID:    spotifyId,
-		RefreshToken: refToken,
+		RefreshToken: token.RefreshToken,
+		Scopes:       token.Scopes,
 		CreatedAt:    &now,
 	}
 
-	if _, err := s.repository.CreateAccount(acc); err != nil {
+	_, err = s.repository.GetAccountByUID(ctx, uid)
+	if err == nil {
+		acc.UpdatedAt = &now
+		if _, err := s.repository.UpdateAccount(ctx, acc); err != nil {
+			return errors.Wrap(err, "[s.CreateAccount]: unable to update account")
+		}
+
+		return nil
+	}
+	if errors.Cause(err) != mongo.ErrNoDocuments {
+		return errors.Wrap(err, "[s.CreateAccount]: unable to check existing account")
+	}
+
+	if _, err := s.repository.CreateAccount(ctx, acc); err != nil {
 		return errors.Wrap(err, "[s.CreateAccount]: unable to create account")
 	}
 
@@ -88,25 +249,47 @@ func (s *service) ParseLINERequest(req *http.Request) ([]*linebot.Event, error)
 	return s.lineService.ParseRequest(req)
 }
 
-func (s *service) LINEEventsHandler(events []*linebot.Event) error {
+func (s *service) LINEEventsHandler(ctx context.Context, events []*linebot.Event) error {
 	for _, event := range events {
+		if event.Type == linebot.EventTypeJoin {
+			if err := s.lineService.SendTextMessage(ctx, event.ReplyToken, groupIntroMsg); err != nil {
+				return errors.Wrap(err, "[LINEEventsHandler]: unable to reply join")
+			}
+		}
 		if event.Type == linebot.EventTypeMessage {
 			uid := event.Source.UserID
+			ctx := logger.WithField(ctx, logger.FieldLINEUID, uid)
 
 			switch message := event.Message.(type) {
 			case *linebot.TextMessage:
-				if err := s.textEventsHandler(uid, message.Text, event.ReplyToken); err != nil {
+				if chatID := sourceChatID(event.Source); chatID != "" {
+					if err := s.groupEventsHandler(ctx, uid, chatID, message.Text, event.ReplyToken); err != nil {
+						return errors.Wrap(err, "[LINEEventsHandler]: unable to reply group message")
+					}
+					break
+				}
+
+				if err := s.textEventsHandler(ctx, uid, message.Text, event.ReplyToken); err != nil {
 					return errors.Wrap(err, "[LINEEventsHandler]: unable to reply message")
 				}
 			}
 		}
+		// buttons sapo sends to groups only open links, a postback from a group is not meant for sapo
+		if event.Type == linebot.EventTypePostback && event.Postback != nil && sourceChatID(event.Source) == "" {
+			uid := event.Source.UserID
+			ctx := logger.WithField(ctx, logger.FieldLINEUID, uid)
+
+			if err := s.postbackEventsHandler(ctx, uid, event.Postback.Data, event.ReplyToken); err != nil {
+				return errors.Wrap(err, "[LINEEventsHandler]: unable to reply postback")
+			}
+		}
 	}
 
 	return nil
 }
 
-func (s *service) LINELinkUserToLoginRichMenu(uid string) error {
-	err := s.lineService.LinkUserToLoginRichMenu(uid)
+func (s *service) LINELinkUserToLoginRichMenu(ctx context.Context, uid string) error {
+	err := s.lineService.LinkUserToLoginRichMenu(ctx, uid)
 	if err != nil {
 		return err
 	}
@@ -114,8 +297,8 @@ func (s *service) LINELinkUserToLoginRichMenu(uid string) error {
 	return nil
 }
 
-func (s *service) LINELinkUserToDefaultRichMenu(uid string) error {
-	err := s.lineService.LinkUserToDefaultRichMenu(uid)
+func (s *service) LINELinkUserToDefaultRichMenu(ctx context.Context, uid string) error {
+	err := s.lineService.LinkUserToDefaultRichMenu(ctx, uid)
 	if err != nil {
 		return err
 	}
@@ -123,63 +306,476 @@ func (s *service) LINELinkUserToDefaultRichMenu(uid string) error {
 	return nil
 }
 
-func (s *service) textEventsHandler(uid, msg, token string) error {
-	msg = strings.ToLower(msg)
+func (s *service) LINELinkUserToRichMenuAlias(ctx context.Context, uid, alias string) error {
+	err := s.lineService.LinkUserToRichMenuAlias(ctx, uid, alias)
+	if err != nil {
+		return err
+	}
 
-	switch msg {
+	return nil
+}
+
+func (s *service) LINEPushTextMessage(ctx context.Context, uid, msg string) error {
+	return s.lineService.PushTextMessage(ctx, uid, msg)
+}
+
+func (s *service) textEventsHandler(ctx context.Context, uid, msg, token string) error {
+	command, args := parseCommand(strings.ToLower(msg))
+
+	return s.handleCommand(ctx, uid, "", command, args, token)
+}
+
+// postbackEventsHandler handles postbacks of flex message buttons, other postbacks like the ones
+// rich menu tabs send when switching are ignored
+func (s *service) postbackEventsHandler(ctx context.Context, uid, data, token string) error {
+	command, args, ok := parsePostback(data)
+	if !ok {
+		return nil
+	}
+
+	return s.handleCommand(ctx, uid, "", command, args, token)
+}
+
+// handleCommand runs a command from a text or postback event and records how it went,
+// chatID is the group or room the command was sent in and empty for a one-on-one chat
+func (s *service) handleCommand(ctx context.Context, uid, chatID, command, args, token string) error {
+	ctx = logger.WithField(ctx, logger.FieldCommand, command)
+
+	ctx, span := tracing.Start(ctx, fmt.Sprintf("command %s", command), attribute.String("command", command))
+	start := time.Now()
+	outcome, err := s.runCommand(ctx, uid, chatID, command, args, token)
+	latency := time.Since(start)
+	metrics.Commands.WithLabelValues(command, outcome).Inc()
+	metrics.CommandDuration.WithLabelValues(command).Observe(latency.Seconds())
+	span.SetAttributes(attribute.String("outcome", outcome))
+	tracing.End(span, err)
+
+	s.recordCommand(ctx, Command{
+		UID:        uid,
+		Command:    command,
+		Args:       args,
+		ChatID:     chatID,
+		Outcome:    outcome,
+		LatencyMs:  latency.Milliseconds(),
+		ErrorClass: errorClass(err),
+	})
+
+	return err
+}
+
+// runCommand checks the user granted the scopes the command needs before handling it and reports the outcome for metrics
+func (s *service) runCommand(ctx context.Context, uid, chatID, command, args, token string) (string, error) {
+	granted, err := s.checkCommandScopes(ctx, uid, command, token)
+	if err != nil {
+		return metrics.OutcomeError, errors.Wrap(err, "[runCommand]: unable to check command scopes")
+	}
+	if !granted {
+		return outcomeMissingScopes, nil
+	}
+
+	if err := s.commandHandler(ctx, uid, chatID, command, args, token); err != nil {
+		return metrics.OutcomeError, err
+	}
+
+	return metrics.OutcomeSuccess, nil
+}
+
+func (s *service) commandHandler(ctx context.Context, uid, chatID, command, args, token string) error {
+	switch command {
 	case textEventEcho:
-		if err := s.lineService.SendTextMessage(token, msg); err != nil {
+		if err := s.lineService.SendTextMessage(ctx, token, command); err != nil {
 			return errors.Wrap(err, "[textEventsHandler]: unable to send message")
 		}
 	case textEventMyTop:
 		replyMsg := "Choose My Top Tracks or My Top Artist"
 		items := s.createMyTopQuickReplies()
 
-		if err := s.lineService.SendTextMessageWithQuickReplies(token, replyMsg, items); err != nil {
+		if err := s.lineService.SendTextMessageWithQuickReplies(ctx, token, replyMsg, items); err != nil {
 			return errors.Wrap(err, "[textEventsHandler]: unable to send flex message")
 		}
 	case textEventMyTopTracks:
-		tracks, albums, err := s.getTopTracksWithAlbums(uid)
+		tracks, albums, err := s.getTopTracksWithAlbums(ctx, uid)
 		if err != nil {
 			return errors.Wrapf(err, "[textEventsHandler]: unable to get top tracks for user id %s", uid)
 		}
 
 		flex := s.createTopTracksFlexMsg(tracks, albums)
 
-		if err := s.lineService.ReplyFlexMsg(token, *flex); err != nil {
+		if err := s.lineService.ReplyFlexMsg(ctx, token, *flex); err != nil {
 			return errors.Wrap(err, "[textEventsHandler]: unable to send flex message")
 		}
 	case textEventMyTopArtists:
-		artists, err := s.getTopArtists(uid)
+		artists, err := s.getTopArtists(ctx, uid)
 		if err != nil {
 			return errors.Wrapf(err, "[textEventsHandler]: unable to get top artists for user id %s", uid)
 		}
 
 		flex := s.createCarouselTopArtists(artists)
 
-		if err := s.lineService.ReplyFlexMsg(token, *flex); err != nil {
+		if err := s.lineService.ReplyFlexMsg(ctx, token, *flex); err != nil {
 			return errors.Wrap(err, "[textEventsHandler]: unable to send flex message")
 		}
 	case textEventCreatePlaylist:
-		playlist, err := s.createRecommendedPlaylistForUser(uid)
+		playlist, err := s.createRecommendedPlaylistForUser(ctx, uid)
 		if err != nil {
 			return errors.Wrapf(err, "[textEventsHandler]: unable to create recommended playlist to user id %s", uid)
 		}
 
 		flex := s.createPlaylistFlexMsg(playlist)
 
-		if err := s.lineService.ReplyFlexMsg(token, *flex); err != nil {
+		if err := s.lineService.ReplyFlexMsg(ctx, token, *flex); err != nil {
 			return errors.Wrap(err, "[textEventsHandler]: unable to send flex message")
 		}
 	case textEventRandom:
-		track, album, err := s.getRandomTrackWithAlbum(uid)
+		track, album, err := s.getRandomTrackWithAlbum(ctx, uid)
 		if err != nil {
 			return errors.Wrapf(err, "[textEventsHandler]: unable to create get random track for user id %s", uid)
 		}
 
 		flex := s.createTrackFlexMsg(track, album)
 
-		if err := s.lineService.ReplyFlexMsg(token, *flex); err != nil {
+		if track.PreviewURL != "" {
+			if err := s.lineService.ReplyMessages(ctx, token, *flex, *s.createTrackPreviewMsg(track)); err != nil {
+				return errors.Wrap(err, "[textEventsHandler]: unable to send flex message")
+			}
+			break
+		}
+
+		if err := s.lineService.ReplyFlexMsg(ctx, token, *flex); err != nil {
+			return errors.Wrap(err, "[textEventsHandler]: unable to send flex message")
+		}
+	case textEventHistory:
+		history, err := s.repository.GetRecentCommands(ctx, uid, defaultHistoryLimit)
+		if err != nil {
+			return errors.Wrapf(err, "[textEventsHandler]: unable to get command history for user id %s", uid)
+		}
+
+		if err := s.lineService.SendTextMessage(ctx, token, s.createHistoryMsg(history)); err != nil {
+			return errors.Wrap(err, "[textEventsHandler]: unable to send message")
+		}
+	case textEventMyPlaylists:
+		playlists, err := s.repository.GetRecentPlaylists(ctx, uid, defaultCarouselLimit)
+		if err != nil {
+			return errors.Wrapf(err, "[textEventsHandler]: unable to get playlists for user id %s", uid)
+		}
+
+		if len(playlists) == 0 {
+			replyMsg := "sapo has not made you a playlist yet, try playlist for me"
+			if err := s.lineService.SendTextMessage(ctx, token, replyMsg); err != nil {
+				return errors.Wrap(err, "[textEventsHandler]: unable to send message")
+			}
+			break
+		}
+
+		flex := s.createCarouselPlaylists(playlists)
+
+		if err := s.lineService.ReplyFlexMsg(ctx, token, *flex); err != nil {
+			return errors.Wrap(err, "[textEventsHandler]: unable to send flex message")
+		}
+	case textEventRolling:
+		acc, err := s.getAccountByUID(ctx, uid)
+		if err != nil {
+			return errors.Wrapf(err, "[textEventsHandler]: unable to get account for user id %s", uid)
+		}
+
+		replyMsg := "Rolling playlist is off, playlist for me creates a new playlist every time"
+		if acc.Settings.RollingPlaylist {
+			replyMsg = "Rolling playlist is on, playlist for me refreshes the same playlist every time"
+		}
+
+		if err := s.lineService.SendTextMessageWithQuickReplies(ctx, token, replyMsg, s.createRollingQuickReplies()); err != nil {
+			return errors.Wrap(err, "[textEventsHandler]: unable to send message")
+		}
+	case textEventRollingOn, textEventRollingOff:
+		enabled := command == textEventRollingOn
+		err := s.updateSettings(ctx, uid, func(settings *AccountSettings) {
+			settings.RollingPlaylist = enabled
+		})
+		if err != nil {
+			return errors.Wrapf(err, "[textEventsHandler]: unable to change rolling playlist for user id %s", uid)
+		}
+
+		replyMsg := "Got it, playlist for me will create a new playlist every time"
+		if enabled {
+			replyMsg = "Got it, playlist for me will keep refreshing one playlist from now on"
+		}
+
+		if err := s.lineService.SendTextMessage(ctx, token, replyMsg); err != nil {
+			return errors.Wrap(err, "[textEventsHandler]: unable to send message")
+		}
+	case textEventSubscribe, textEventUnsubscribe:
+		subscribed := command == textEventSubscribe
+		err := s.updateSettings(ctx, uid, func(settings *AccountSettings) {
+			settings.WeeklyPlaylist = subscribed
+		})
+		if err != nil {
+			return errors.Wrapf(err, "[textEventsHandler]: unable to change weekly playlist for user id %s", uid)
+		}
+
+		replyMsg := "Okay, sapo will stop sending you weekly playlists"
+		if subscribed {
+			replyMsg = "Yay! sapo will send you a fresh playlist every week, say unsubscribe to stop"
+		}
+
+		if err := s.lineService.SendTextMessage(ctx, token, replyMsg); err != nil {
+			return errors.Wrap(err, "[textEventsHandler]: unable to send message")
+		}
+	case textEventSubscribeRecap, textEventUnsubRecap:
+		subscribed := command == textEventSubscribeRecap
+		err := s.updateSettings(ctx, uid, func(settings *AccountSettings) {
+			settings.MonthlyRecap = subscribed
+		})
+		if err != nil {
+			return errors.Wrapf(err, "[textEventsHandler]: unable to change monthly recap for user id %s", uid)
+		}
+
+		replyMsg := "Okay, sapo will stop sending you monthly recaps"
+		if subscribed {
+			replyMsg = "Yay! sapo will send you a recap of your month on the 1st, say unsubscribe recap to stop"
+		}
+
+		if err := s.lineService.SendTextMessage(ctx, token, replyMsg); err != nil {
+			return errors.Wrap(err, "[textEventsHandler]: unable to send message")
+		}
+	case textEventMyRecap:
+		acc, err := s.getAccountByUID(ctx, uid)
+		if err != nil {
+			return errors.Wrapf(err, "[textEventsHandler]: unable to get account for user id %s", uid)
+		}
+
+		recap, err := s.createRecap(ctx, acc, time.Now())
+		if err != nil {
+			return errors.Wrapf(err, "[textEventsHandler]: unable to create recap for user id %s", uid)
+		}
+
+		flex := s.createRecapFlexMsg(recap)
+		if flex == nil {
+			replyMsg := "sapo needs to hear a bit more of your listening before making a recap, try again later"
+			if err := s.lineService.SendTextMessage(ctx, token, replyMsg); err != nil {
+				return errors.Wrap(err, "[textEventsHandler]: unable to send message")
+			}
+			break
+		}
+
+		if err := s.lineService.ReplyFlexMsg(ctx, token, *flex); err != nil {
+			return errors.Wrap(err, "[textEventsHandler]: unable to send flex message")
+		}
+	case textEventMyGenres:
+		artists, err := s.getGenreArtists(ctx, uid)
+		if err != nil {
+			return errors.Wrapf(err, "[textEventsHandler]: unable to get top artists for user id %s", uid)
+		}
+
+		genres := computeGenreBreakdown(artists)
+		if len(genres) == 0 {
+			replyMsg := "sapo could not tell the genres of your top artists yet, listen a bit more and try again"
+			if err := s.lineService.SendTextMessage(ctx, token, replyMsg); err != nil {
+				return errors.Wrap(err, "[textEventsHandler]: unable to send message")
+			}
+			break
+		}
+
+		flex := s.createGenresFlexMsg(genres)
+		if err := s.lineService.ReplyFlexMsgWithQuickReplies(ctx, token, *flex, s.createGenreQuickReplies(genres)); err != nil {
+			return errors.Wrap(err, "[textEventsHandler]: unable to send flex message")
+		}
+	case textEventGenrePlaylist:
+		playlist, err := s.createGenrePlaylistForUser(ctx, uid, args)
+		if errors.Cause(err) == errorUnknownGenre {
+			replyMsg := fmt.Sprintf("sapo could not find %s among your top artists, try my genres to see yours", args)
+			if err := s.lineService.SendTextMessage(ctx, token, replyMsg); err != nil {
+				return errors.Wrap(err, "[textEventsHandler]: unable to send message")
+			}
+			break
+		}
+		if err != nil {
+			return errors.Wrapf(err, "[textEventsHandler]: unable to create genre playlist to user id %s", uid)
+		}
+
+		flex := s.createPlaylistFlexMsg(playlist)
+
+		if err := s.lineService.ReplyFlexMsg(ctx, token, *flex); err != nil {
+			return errors.Wrap(err, "[textEventsHandler]: unable to send flex message")
+		}
+	case textEventSearch, textEventFind:
+		result, err := s.search(ctx, uid, args)
+		if err != nil {
+			return errors.Wrapf(err, "[textEventsHandler]: unable to search for user id %s", uid)
+		}
+
+		flex := s.createCarouselSearchResult(result)
+		if flex == nil {
+			replyMsg := fmt.Sprintf("sapo could not find anything for %s", args)
+			if err := s.lineService.SendTextMessage(ctx, token, replyMsg); err != nil {
+				return errors.Wrap(err, "[textEventsHandler]: unable to send message")
+			}
+			break
+		}
+
+		if err := s.lineService.ReplyFlexMsg(ctx, token, *flex); err != nil {
+			return errors.Wrap(err, "[textEventsHandler]: unable to send flex message")
+		}
+	case postbackEventAddToPlaylist:
+		playlist, added, err := s.addTrackToSapoPlaylist(ctx, uid, args)
+		if errors.Cause(err) == errorNoSapoPlaylist {
+			replyMsg := "sapo has not made you a playlist yet, try playlist for me first"
+			if err := s.lineService.SendTextMessage(ctx, token, replyMsg); err != nil {
+				return errors.Wrap(err, "[textEventsHandler]: unable to send message")
+			}
+			break
+		}
+		if err != nil {
+			return errors.Wrapf(err, "[textEventsHandler]: unable to add track to playlist for user id %s", uid)
+		}
+
+		replyMsg := fmt.Sprintf("Added to %s", playlist.Name)
+		if !added {
+			replyMsg = fmt.Sprintf("It is already in %s", playlist.Name)
+		}
+
+		if err := s.lineService.SendTextMessage(ctx, token, replyMsg); err != nil {
+			return errors.Wrap(err, "[textEventsHandler]: unable to send message")
+		}
+	case postbackEventArtistDetail:
+		detail, err := s.getArtistDetail(ctx, uid, args)
+		if err != nil {
+			return errors.Wrapf(err, "[textEventsHandler]: unable to get artist %s for user id %s", args, uid)
+		}
+
+		flex := s.createCarouselArtistDetail(detail)
+
+		if err := s.lineService.ReplyFlexMsg(ctx, token, *flex); err != nil {
+			return errors.Wrap(err, "[textEventsHandler]: unable to send flex message")
+		}
+	case postbackEventArtistPlaylist:
+		playlist, err := s.createArtistPlaylistForUser(ctx, uid, args)
+		if err != nil {
+			return errors.Wrapf(err, "[textEventsHandler]: unable to create artist playlist to user id %s", uid)
+		}
+
+		flex := s.createPlaylistFlexMsg(playlist)
+
+		if err := s.lineService.ReplyFlexMsg(ctx, token, *flex); err != nil {
+			return errors.Wrap(err, "[textEventsHandler]: unable to send flex message")
+		}
+	case postbackEventTrackDetail:
+		track, err := s.getTrack(ctx, uid, args)
+		if err != nil {
+			return errors.Wrapf(err, "[textEventsHandler]: unable to get track %s for user id %s", args, uid)
+		}
+
+		flex := s.createTrackDetailFlexMsg(track)
+
+		if err := s.lineService.ReplyFlexMsg(ctx, token, *flex); err != nil {
+			return errors.Wrap(err, "[textEventsHandler]: unable to send flex message")
+		}
+	case postbackEventPreview:
+		track, err := s.getTrack(ctx, uid, args)
+		if err != nil {
+			return errors.Wrapf(err, "[textEventsHandler]: unable to get track %s for user id %s", args, uid)
+		}
+
+		if err := s.lineService.ReplyMessages(ctx, token, *s.createTrackPreviewMsg(track)); err != nil {
+			return errors.Wrap(err, "[textEventsHandler]: unable to send preview message")
+		}
+	case postbackEventSaveTrack:
+		if err := s.saveTrack(ctx, uid, args); err != nil {
+			return errors.Wrapf(err, "[textEventsHandler]: unable to save track for user id %s", uid)
+		}
+
+		if err := s.lineService.SendTextMessage(ctx, token, "Saved to your Liked Songs ❤"); err != nil {
+			return errors.Wrap(err, "[textEventsHandler]: unable to send message")
+		}
+	case postbackEventFollowArtist:
+		if err := s.followArtist(ctx, uid, args); err != nil {
+			return errors.Wrapf(err, "[textEventsHandler]: unable to follow artist for user id %s", uid)
+		}
+
+		if err := s.lineService.SendTextMessage(ctx, token, "Followed! You will find them under Following on Spotify"); err != nil {
+			return errors.Wrap(err, "[textEventsHandler]: unable to send message")
+		}
+	case textEventNowPlaying:
+		playing, err := s.getNowPlaying(ctx, uid)
+		if err != nil {
+			return errors.Wrapf(err, "[textEventsHandler]: unable to get now playing for user id %s", uid)
+		}
+
+		if playing == nil || playing.Item == nil {
+			replyMsg := "Nothing is playing on your Spotify right now"
+			if err := s.lineService.SendTextMessage(ctx, token, replyMsg); err != nil {
+				return errors.Wrap(err, "[textEventsHandler]: unable to send message")
+			}
+			break
+		}
+
+		flex := s.createNowPlayingFlexMsg(playing)
+
+		if err := s.lineService.ReplyFlexMsg(ctx, token, *flex); err != nil {
+			return errors.Wrap(err, "[textEventsHandler]: unable to send flex message")
+		}
+	case textEventQueue:
+		track, err := s.queueTrack(ctx, uid, args)
+		replyMsg, handled := playbackErrorMsg(err)
+		if errors.Cause(err) == errorTrackNotFound {
+			replyMsg, handled = fmt.Sprintf("sapo could not find a track for %s", args), true
+		}
+		if err != nil && !handled {
+			return errors.Wrapf(err, "[textEventsHandler]: unable to queue track for user id %s", uid)
+		}
+
+		if !handled {
+			artists := []string{}
+			for _, a := range track.Artists {
+				artists = append(artists, a.Name)
+			}
+			replyMsg = fmt.Sprintf("Up next: %s by %s", track.Name, strings.Join(artists, ", "))
+		}
+
+		if err := s.lineService.SendTextMessage(ctx, token, replyMsg); err != nil {
+			return errors.Wrap(err, "[textEventsHandler]: unable to send message")
+		}
+	case postbackEventNext, postbackEventPrevious, postbackEventPause, postbackEventPlay:
+		err := s.controlPlayback(ctx, uid, command)
+		replyMsg, handled := playbackErrorMsg(err)
+		if err != nil && !handled {
+			return errors.Wrapf(err, "[textEventsHandler]: unable to control playback for user id %s", uid)
+		}
+
+		if !handled {
+			replyMsg = playbackReplies[command]
+		}
+
+		if err := s.lineService.SendTextMessage(ctx, token, replyMsg); err != nil {
+			return errors.Wrap(err, "[textEventsHandler]: unable to send message")
+		}
+	case textEventJoin, textEventLeave, textEventGroupPlaylist:
+		if chatID == "" {
+			replyMsg := "Add sapo to a group chat with your friends to make a playlist for all of you"
+			if err := s.lineService.SendTextMessage(ctx, token, replyMsg); err != nil {
+				return errors.Wrap(err, "[textEventsHandler]: unable to send message")
+			}
+			break
+		}
+
+		if err := s.groupCommandHandler(ctx, uid, chatID, command, token); err != nil {
+			return errors.Wrapf(err, "[textEventsHandler]: unable to handle group command in chat %s", chatID)
+		}
+	case textEventMyStats:
+		stats, err := s.getListeningStats(ctx, uid, time.Now())
+		if err != nil {
+			return errors.Wrapf(err, "[textEventsHandler]: unable to get listening stats for user id %s", uid)
+		}
+
+		flex := s.createStatsFlexMsg(stats)
+		if flex == nil {
+			replyMsg := "sapo has not heard you play anything this week yet, come back after a few songs"
+			if err := s.lineService.SendTextMessage(ctx, token, replyMsg); err != nil {
+				return errors.Wrap(err, "[textEventsHandler]: unable to send message")
+			}
+			break
+		}
+
+		if err := s.lineService.ReplyFlexMsg(ctx, token, *flex); err != nil {
 			return errors.Wrap(err, "[textEventsHandler]: unable to send flex message")
 		}
 	}
@@ -187,8 +783,48 @@ func (s *service) textEventsHandler(uid, msg, token string) error {
 	return nil
 }
 
-func (s *service) getAccountByUID(uid string) (*Account, error) {
-	acc, err := s.repository.GetAccountByUID(uid)
+// checkCommandScopes replies with a consent link and returns false when the user has not granted the scopes the command needs
+func (s *service) checkCommandScopes(ctx context.Context, uid, command, token string) (bool, error) {
+	required, ok := commandScopes[command]
+	if !ok {
+		return true, nil
+	}
+
+	acc, err := s.getAccountByUID(ctx, uid)
+	if errors.Cause(err) == mongo.ErrNoDocuments {
+		// group members can talk to sapo before ever linking their account
+		replyMsg := fmt.Sprintf("Please link your Spotify with sapo first %s", s.liffLoginURL)
+		if err := s.lineService.SendTextMessage(ctx, token, replyMsg); err != nil {
+			return false, errors.Wrap(err, "[checkCommandScopes]: unable to send message")
+		}
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrap(err, "[checkCommandScopes]: unable to get user profile")
+	}
+
+	missing := spotify.MissingScopes(acc.GrantedScopes(), required)
+	if len(missing) == 0 {
+		return true, nil
+	}
+
+	replyMsg := fmt.Sprintf("sapo needs a few more permissions from your Spotify account for this, please allow them here %s", s.createConsentURL(missing))
+	if err := s.lineService.SendTextMessage(ctx, token, replyMsg); err != nil {
+		return false, errors.Wrap(err, "[checkCommandScopes]: unable to send message")
+	}
+
+	return false, nil
+}
+
+func (s *service) createConsentURL(scopes []string) string {
+	query := url.Values{}
+	query.Add("scope", strings.Join(scopes, " "))
+
+	return fmt.Sprintf("%s?%s", s.liffLoginURL, query.Encode())
+}
+
+func (s *service) getAccountByUID(ctx context.Context, uid string) (*Account, error) {
+	acc, err := s.repository.GetAccountByUID(ctx, uid)
 	if err != nil {
 		return nil, errors.Wrap(err, "[GetAccountByUID]: unable to get user account token")
 	}
@@ -196,32 +832,111 @@ func (s *service) getAccountByUID(uid string) (*Account, error) {
 	return acc, nil
 }
 
-func (s *service) createRecommendedPlaylistForUser(uid string) (*spotify.Playlist, error) {
-	acc, err := s.getAccountByUID(uid)
+func (s *service) createRecommendedPlaylistForUser(ctx context.Context, uid string) (*spotify.Playlist, error) {
+	acc, err := s.getAccountByUID(ctx, uid)
 	if err != nil {
 		return nil, errors.Wrap(err, "[createRecommendedPlaylistForUser]: unable to get user profile")
 	}
 	spotifyId := acc.SpotifyID
 	refreshToken := acc.RefreshToken
 
-	accessToken, err := s.spotifyService.RequestAccessTokenFromRefreshToken(refreshToken)
+	accessToken, err := s.spotifyService.RequestAccessTokenFromRefreshToken(ctx, refreshToken)
 	if err != nil {
 		return nil, errors.Wrap(err, "[createRecommendedPlaylistForUser]: unable to request access token")
 	}
 
-	playlistId, err := s.spotifyService.CreateRecommendedPlaylistForUser(accessToken, spotifyId)
+	var recommended *spotify.RecommendedPlaylist
+	if acc.Settings.RollingPlaylist {
+		recommended, err = s.spotifyService.RefreshRecommendedPlaylistForUser(ctx, accessToken, spotifyId, acc.Settings.RollingPlaylistID)
+	} else {
+		recommended, err = s.spotifyService.CreateRecommendedPlaylistForUser(ctx, accessToken, spotifyId)
+	}
 	if err != nil {
 		return nil, errors.Wrap(err, "[createRecommendedPlaylistForUser]: unable to create playlist")
 	}
 
-	playlist, err := s.spotifyService.GetPlaylist(accessToken, playlistId)
+	if acc.Settings.RollingPlaylist && recommended.ID != acc.Settings.RollingPlaylistID {
+		settings := acc.Settings
+		settings.RollingPlaylistID = recommended.ID
+		if err := s.repository.UpdateAccountSettings(ctx, uid, settings); err != nil {
+			return nil, errors.Wrap(err, "[createRecommendedPlaylistForUser]: unable to save rolling playlist")
+		}
+	}
+
+	playlist, err := s.spotifyService.GetPlaylist(ctx, accessToken, recommended.ID)
 	if err != nil {
 		return nil, errors.Wrap(err, "[createRecommendedPlaylistForUser]: unable to playlist detail")
 	}
 
+	s.recordPlaylist(ctx, uid, playlist, recommended)
+
 	return playlist, nil
 }
 
+// recordPlaylist remembers a generated playlist, the user already has it on spotify so failing to record it is only logged
+func (s *service) recordPlaylist(ctx context.Context, uid string, playlist *spotify.Playlist, recommended *spotify.RecommendedPlaylist) {
+	now := time.Now()
+	record := Playlist{
+		UID:       uid,
+		SpotifyID: playlist.ID,
+		Name:      playlist.Name,
+		URL:       playlist.ExternalURLs.URL,
+		Seeds:     recommended.Seeds,
+		TrackURIs: recommended.TrackURIs,
+		ImageURL:  playlistImageURL(playlist),
+		CreatedAt: &now,
+		UpdatedAt: &now,
+	}
+
+	if _, err := s.repository.SavePlaylist(ctx, record); err != nil {
+		logger.FromContext(ctx).WithError(err).Warn("unable to record playlist")
+	}
+}
+
+func (s *service) createCarouselPlaylists(playlists []Playlist) *message.Flex {
+	buttonLabel := "go to playlist"
+
+	bubbles := []message.Flex{}
+	for _, playlist := range playlists {
+		text := fmt.Sprintf("%d tracks", len(playlist.TrackURIs))
+		if playlist.UpdatedAt != nil {
+			text = fmt.Sprintf("%s, updated %s", text, playlist.UpdatedAt.Format("2 Jan 2006"))
+		}
+
+		bubble := message.NewBubbleWithButton(
+			playlist.Name,
+			playlist.Name,
+			text,
+			buttonLabel,
+			playlist.URL,
+			playlist.ImageURL,
+			defaultFlexColor,
+		)
+		bubbles = append(bubbles, bubble)
+	}
+
+	carousel := message.NewCarousel(
+		"My sapo playlists",
+		bubbles,
+	)
+
+	return &carousel
+}
+
+// playlistImageURL returns the cover of a playlist, spotify may not have generated it yet for a new playlist
+func playlistImageURL(playlist *spotify.Playlist) string {
+	return imageURL(playlist.Images)
+}
+
+// imageURL returns the largest image, spotify lists images the largest first and some items have none
+func imageURL(images []spotify.Image) string {
+	if len(images) == 0 {
+		return ""
+	}
+
+	return images[0].URL
+}
+
 func (s *service) createPlaylistFlexMsg(playlist *spotify.Playlist) *message.Flex {
 	altText := "Playlist for you"
 	buttonLabel := "go to playlist"
@@ -232,33 +947,33 @@ func (s *service) createPlaylistFlexMsg(playlist *spotify.Playlist) *message.Fle
 		playlist.Description,
 		buttonLabel,
 		playlist.ExternalURLs.URL,
-		playlist.Images[0].URL,
+		playlistImageURL(playlist),
 		defaultFlexColor,
 	)
 
 	return &flex
 }
 
-func (s *service) getTopTracksWithAlbums(uid string) ([]spotify.Track, []spotify.Album, error) {
-	acc, err := s.getAccountByUID(uid)
+func (s *service) getTopTracksWithAlbums(ctx context.Context, uid string) ([]spotify.Track, []spotify.Album, error) {
+	acc, err := s.getAccountByUID(ctx, uid)
 	if err != nil {
 		return nil, nil, errors.Wrap(err, "[GetTopTracksWithAlbums]: unable to get user profile")
 	}
 	refreshToken := acc.RefreshToken
 
-	accessToken, err := s.spotifyService.RequestAccessTokenFromRefreshToken(refreshToken)
+	accessToken, err := s.spotifyService.RequestAccessTokenFromRefreshToken(ctx, refreshToken)
 	if err != nil {
 		return nil, nil, errors.Wrap(err, "[GetTopTracksWithAlbums]: unable to request access token")
 	}
 
-	tracks, err := s.spotifyService.GetTopTracks(accessToken, defaultFlexLimit)
+	tracks, err := s.spotifyService.GetTopTracks(ctx, accessToken, spotify.TimeRangeShort, defaultFlexLimit)
 	if err != nil {
 		return nil, nil, errors.Wrap(err, "[GetTopTracksWithAlbums]: unable to get user's top tracks")
 	}
 
 	albumIDs := s.findUniqueAlbumIDsFromTracks(tracks)
 
-	albums, err := s.spotifyService.GetAlbums(accessToken, albumIDs)
+	albums, err := s.spotifyService.GetAlbums(ctx, accessToken, albumIDs)
 	if err != nil {
 		return nil, nil, errors.Wrap(err, "[GetTopTracksWithAlbums]: unable to albums from ids")
 	}
@@ -266,14 +981,17 @@ func (s *service) getTopTracksWithAlbums(uid string) ([]spotify.Track, []spotify
 	return tracks, albums, nil
 }
 
+// createTopTracksFlexMsg is the top tracks list followed by a bubble per track with the track actions
 func (s *service) createTopTracksFlexMsg(tracks []spotify.Track, albums []spotify.Album) *message.Flex {
 	AlbumIDMapImageURL := map[string]string{}
 	for _, album := range albums {
-		AlbumIDMapImageURL[album.ID] = album.Images[0].URL
+		AlbumIDMapImageURL[album.ID] = imageURL(album.Images)
 	}
 
 	boxes := []message.BubbleReceiptBox{}
-	for _, track := range tracks {
+	bubbles := []message.Flex{}
+	for i := range tracks {
+		track := &tracks[i]
 		artists := []string{}
 		for _, a := range track.Artists {
 			artists = append(artists, a.Name)
@@ -289,10 +1007,19 @@ func (s *service) createTopTracksFlexMsg(tracks []spotify.Track, albums []spotif
 			URL:      track.ExternalURLs.URL,
 		}
 		boxes = append(boxes, box)
+
+		bubble := message.NewBubbleWithActions(
+			track.Name,
+			track.Name,
+			strings.Join(artists, ", "),
+			AlbumIDMapImageURL[track.Album.ID],
+			trackActions(track),
+		)
+		bubbles = append(bubbles, bubble)
 	}
 
 	now := time.Now()
-	flex := message.NewBubbleReceipt(
+	receipt := message.NewBubbleReceipt(
 		"My Top Tracks",
 		"sapo",
 		"My Top Tracks",
@@ -300,6 +1027,11 @@ func (s *service) createTopTracksFlexMsg(tracks []spotify.Track, albums []spotif
 		boxes,
 	)
 
+	flex := message.NewCarousel(
+		"My Top Tracks",
+		append([]message.Flex{receipt}, bubbles...),
+	)
+
 	return &flex
 }
 
@@ -319,19 +1051,19 @@ func (s *service) findUniqueAlbumIDsFromTracks(tracks []spotify.Track) []string
 	return ids
 }
 
-func (s *service) getTopArtists(uid string) ([]spotify.Artist, error) {
-	acc, err := s.getAccountByUID(uid)
+func (s *service) getTopArtists(ctx context.Context, uid string) ([]spotify.Artist, error) {
+	acc, err := s.getAccountByUID(ctx, uid)
 	if err != nil {
 		return nil, errors.Wrap(err, "[getTopArtists]: unable to get user profile")
 	}
 	refreshToken := acc.RefreshToken
 
-	accessToken, err := s.spotifyService.RequestAccessTokenFromRefreshToken(refreshToken)
+	accessToken, err := s.spotifyService.RequestAccessTokenFromRefreshToken(ctx, refreshToken)
 	if err != nil {
 		return nil, errors.Wrap(err, "[getTopArtists]: unable to request access token")
 	}
 
-	artists, err := s.spotifyService.GetTopArtists(accessToken, defaultCarouselLimit)
+	artists, err := s.spotifyService.GetTopArtists(ctx, accessToken, spotify.TimeRangeMedium, defaultCarouselLimit)
 	if err != nil {
 		return nil, errors.Wrap(err, "[getTopArtists]: unable to get user's top artists")
 	}
@@ -344,8 +1076,8 @@ func (s *service) createCarouselTopArtists(artists []spotify.Artist) *message.Fl
 	for _, artist := range artists {
 		bubble := message.NewBubblePlain(
 			artist.Name,
-			artist.Images[0].URL,
-			artist.ExternalURLs.URL,
+			imageURL(artist.Images),
+			message.NewPostbackAction(actionLabel(artist.Name), postbackData(postbackActionArtist, artist.ID), artist.Name),
 			defaultFlexColor,
 		)
 		bubbles = append(bubbles, bubble)
@@ -359,6 +1091,30 @@ func (s *service) createCarouselTopArtists(artists []spotify.Artist) *message.Fl
 	return &carousel
 }
 
+// updateSettings applies change to the settings of the user, turning the rolling playlist off keeps its id
+// so turning it on again reuses the same playlist
+func (s *service) updateSettings(ctx context.Context, uid string, change func(*AccountSettings)) error {
+	acc, err := s.getAccountByUID(ctx, uid)
+	if err != nil {
+		return errors.Wrap(err, "[updateSettings]: unable to get account")
+	}
+
+	settings := acc.Settings
+	change(&settings)
+	if err := s.repository.UpdateAccountSettings(ctx, uid, settings); err != nil {
+		return errors.Wrap(err, "[updateSettings]: unable to update settings")
+	}
+
+	return nil
+}
+
+func (s *service) createRollingQuickReplies() *linebot.QuickReplyItems {
+	on := linebot.NewQuickReplyButton("", linebot.NewMessageAction("Turn on", "Rolling playlist on"))
+	off := linebot.NewQuickReplyButton("", linebot.NewMessageAction("Turn off", "Rolling playlist off"))
+
+	return linebot.NewQuickReplyItems(on, off)
+}
+
 func (s *service) createMyTopQuickReplies() *linebot.QuickReplyItems {
 	topTrack := linebot.NewQuickReplyButton(
 		"https://i.imgur.com/tFFwSE4.png",
@@ -373,25 +1129,25 @@ func (s *service) createMyTopQuickReplies() *linebot.QuickReplyItems {
 	return linebot.NewQuickReplyItems(topTrack, topArtist)
 }
 
-func (s *service) getRandomTrackWithAlbum(uid string) (*spotify.Track, *spotify.Album, error) {
-	acc, err := s.getAccountByUID(uid)
+func (s *service) getRandomTrackWithAlbum(ctx context.Context, uid string) (*spotify.Track, *spotify.Album, error) {
+	acc, err := s.getAccountByUID(ctx, uid)
 	if err != nil {
 		return nil, nil, errors.Wrap(err, "[getRandomTrack]: unable to get user profile")
 	}
 	refreshToken := acc.RefreshToken
 
-	accessToken, err := s.spotifyService.RequestAccessTokenFromRefreshToken(refreshToken)
+	accessToken, err := s.spotifyService.RequestAccessTokenFromRefreshToken(ctx, refreshToken)
 	if err != nil {
 		return nil, nil, errors.Wrap(err, "[getRandomTrack]: unable to request access token")
 	}
 
-	track, err := s.spotifyService.GetRandomTrack(accessToken)
+	track, err := s.spotifyService.GetRandomTrack(ctx, accessToken)
 	if err != nil {
 		return nil, nil, errors.Wrap(err, "[getRandomTrack]: unable to get random track")
 	}
 
 	albumId := track.Album.ID
-	album, err := s.spotifyService.GetAlbum(accessToken, albumId)
+	album, err := s.spotifyService.GetAlbum(ctx, accessToken, albumId)
 	if err != nil {
 		return nil, nil, errors.Wrap(err, "[getRandomTrack]: unable to get an album")
 	}
@@ -405,22 +1161,44 @@ func (s *service) createTrackFlexMsg(track *spotify.Track, album *spotify.Album)
 		artists = append(artists, a.Name)
 	}
 
-	flex := message.NewBubbleWithImage(
+	flex := message.NewBubbleWithActions(
 		"Random Track For You!",
 		track.Name,
 		strings.Join(artists, ", "),
-		album.Images[0].URL,
-		track.ExternalURLs.URL,
-		defaultFlexColor,
+		imageURL(album.Images),
+		trackActions(track),
 	)
 
 	return &flex
 }
 
-func (s *service) Test(uid string) error {
-	//if err := s.lineService.PushFlexMsg(uid, *flex); err != nil {
-	//	return errors.Wrap(err, "[textEventsHandler]: unable to send flex message")
-	//}
+func (s *service) GetAccount(ctx context.Context, uid string) (*Account, error) {
+	return s.getAccountByUID(ctx, uid)
+}
 
-	return nil
+func (s *service) GetAccounts(ctx context.Context, limit, offset int) ([]Account, error) {
+	accounts, err := s.repository.GetAccounts(ctx, limit, offset)
+	if err != nil {
+		return nil, errors.Wrap(err, "[s.GetAccounts]: unable to get accounts")
+	}
+
+	return accounts, nil
+}
+
+func (s *service) GetCommandStats(ctx context.Context, since time.Time) ([]CommandStats, error) {
+	stats, err := s.repository.GetCommandStats(ctx, since)
+	if err != nil {
+		return nil, errors.Wrap(err, "[s.GetCommandStats]: unable to get command stats")
+	}
+
+	return stats, nil
+}
+
+func (s *service) GetRecentCommands(ctx context.Context, uid string, limit int) ([]Command, error) {
+	commands, err := s.repository.GetRecentCommands(ctx, uid, limit)
+	if err != nil {
+		return nil, errors.Wrap(err, "[s.GetRecentCommands]: unable to get recent commands")
+	}
+
+	return commands, nil
 }
@@ -0,0 +1,32 @@
+package server
+
+import "testing"
+
+func TestParseCommand(t *testing.T) {
+	tests := []struct {
+		name        string
+		msg         string
+		wantCommand string
+		wantArgs    string
+	}{
+		{"command", "my top tracks", textEventMyTopTracks, ""},
+		{"command that prefixes another", "my top", textEventMyTop, ""},
+		{"command with arguments", "genre playlist k-pop", textEventGenrePlaylist, "k-pop"},
+		{"arguments keep inner spaces", "search  daft punk ", textEventSearch, "daft punk"},
+		{"command missing its arguments", "search", unknownCommand, ""},
+		{"command with blank arguments", "search   ", unknownCommand, ""},
+		{"arguments on a command without any", "random please", unknownCommand, ""},
+		{"command not followed by a space", "searchdaft punk", unknownCommand, ""},
+		{"chat is never kept", "hello sapo, how are you?", unknownCommand, ""},
+		{"empty", "", unknownCommand, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			command, args := parseCommand(tt.msg)
+			if command != tt.wantCommand || args != tt.wantArgs {
+				t.Errorf("parseCommand(%q) = %q, %q, want %q, %q", tt.msg, command, args, tt.wantCommand, tt.wantArgs)
+			}
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/bbkbbbk/sapo/line/message"
+)
+
+const (
+	// statsTimezone decides where a day of listening starts and ends
+	statsTimezone   = "Asia/Bangkok"
+	statsDays       = 7
+	statsStreakDays = 90
+	playDateLayout  = "2006-01-02"
+)
+
+// ListeningStats sums up the plays of the last statsDays days, streaks look back statsStreakDays days
+type ListeningStats struct {
+	Since         time.Time
+	Until         time.Time
+	Plays         int64
+	Minutes       int64
+	BusiestHour   int
+	Artists       int
+	TopArtist     string
+	CurrentStreak int
+	LongestStreak int
+}
+
+func (s *service) getListeningStats(ctx context.Context, uid string, now time.Time) (*ListeningStats, error) {
+	loc, err := time.LoadLocation(statsTimezone)
+	if err != nil {
+		return nil, errors.Wrap(err, "[getListeningStats]: unable to load timezone")
+	}
+	today := startOfDay(now, loc)
+	since := today.AddDate(0, 0, -(statsDays - 1))
+
+	days, err := s.repository.GetPlaysPerDay(ctx, uid, today.AddDate(0, 0, -(statsStreakDays-1)), statsTimezone)
+	if err != nil {
+		return nil, errors.Wrap(err, "[getListeningStats]: unable to get plays per day")
+	}
+
+	stats := &ListeningStats{
+		Since: since,
+		Until: today,
+	}
+	durationMs := int64(0)
+	for _, day := range days {
+		if day.Key < since.Format(playDateLayout) {
+			continue
+		}
+		stats.Plays += day.Plays
+		durationMs += day.DurationMs
+	}
+	stats.Minutes = durationMs / int64(time.Minute/time.Millisecond)
+	stats.CurrentStreak, stats.LongestStreak = computeStreaks(days, today)
+
+	hours, err := s.repository.GetPlaysPerHour(ctx, uid, since, statsTimezone)
+	if err != nil {
+		return nil, errors.Wrap(err, "[getListeningStats]: unable to get plays per hour")
+	}
+	stats.BusiestHour = computeBusiestHour(hours)
+
+	artists, err := s.repository.GetPlaysPerArtist(ctx, uid, since, 0)
+	if err != nil {
+		return nil, errors.Wrap(err, "[getListeningStats]: unable to get plays per artist")
+	}
+	stats.Artists = len(artists)
+	if len(artists) > 0 {
+		stats.TopArtist = artists[0].Name
+	}
+
+	return stats, nil
+}
+
+// startOfDay returns the midnight in loc starting the day t falls on there
+func startOfDay(t time.Time, loc *time.Location) time.Time {
+	t = t.In(loc)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+}
+
+// computeBusiestHour returns the hour with the most plays, hours are sorted by key so a tie goes to the earliest.
+// It is -1 when nothing was played
+func computeBusiestHour(hours []PlayAggregate) int {
+	busiestHour, busiest := -1, int64(0)
+	for _, hour := range hours {
+		h, err := strconv.Atoi(hour.Key)
+		if err != nil || hour.Plays <= busiest {
+			continue
+		}
+		busiestHour, busiest = h, hour.Plays
+	}
+
+	return busiestHour
+}
+
+// computeStreaks counts the consecutive days with plays, days are sorted by key. The current streak
+// is still alive when nothing was played yet today but the user played yesterday
+func computeStreaks(days []PlayAggregate, today time.Time) (int, int) {
+	played := map[string]bool{}
+	longest, run := 0, 0
+	var previous time.Time
+	for _, day := range days {
+		date, err := time.Parse(playDateLayout, day.Key)
+		if err != nil || day.Plays == 0 {
+			continue
+		}
+		played[day.Key] = true
+
+		if !previous.IsZero() && previous.AddDate(0, 0, 1).Equal(date) {
+			run++
+		} else {
+			run = 1
+		}
+		if run > longest {
+			longest = run
+		}
+		previous = date
+	}
+
+	day := today
+	if !played[day.Format(playDateLayout)] {
+		day = day.AddDate(0, 0, -1)
+	}
+	current := 0
+	for played[day.Format(playDateLayout)] {
+		current++
+		day = day.AddDate(0, 0, -1)
+	}
+
+	return current, longest
+}
+
+// createStatsFlexMsg returns nil when nothing was played in the period
+func (s *service) createStatsFlexMsg(stats *ListeningStats) *message.Flex {
+	if stats.Plays == 0 {
+		return nil
+	}
+
+	items := []message.BubbleStatsItem{
+		{Label: "Minutes listened", Value: fmt.Sprintf("%d min", stats.Minutes)},
+		{Label: "Tracks played", Value: fmt.Sprintf("%d", stats.Plays)},
+		{Label: "Artists", Value: fmt.Sprintf("%d", stats.Artists)},
+	}
+	if stats.TopArtist != "" {
+		items = append(items, message.BubbleStatsItem{Label: "Top artist", Value: stats.TopArtist})
+	}
+	if stats.BusiestHour >= 0 {
+		busiest := fmt.Sprintf("%02d:00 - %02d:00", stats.BusiestHour, (stats.BusiestHour+1)%24)
+		items = append(items, message.BubbleStatsItem{Label: "Busiest hour", Value: busiest})
+	}
+	items = append(items,
+		message.BubbleStatsItem{Label: "Current streak", Value: streakText(stats.CurrentStreak)},
+		message.BubbleStatsItem{Label: "Longest streak", Value: streakText(stats.LongestStreak)},
+	)
+
+	flex := message.NewBubbleStats(
+		"My stats",
+		"My Stats",
+		fmt.Sprintf("Your listening from %s to %s", stats.Since.Format("2 Jan"), stats.Until.Format("2 Jan 2006")),
+		items,
+	)
+
+	return &flex
+}
+
+func streakText(days int) string {
+	if days == 1 {
+		return "1 day"
+	}
+
+	return fmt.Sprintf("%d days", days)
+}
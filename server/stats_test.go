@@ -0,0 +1,104 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func playDays(keys ...string) []PlayAggregate {
+	aggregates := []PlayAggregate{}
+	for _, key := range keys {
+		aggregates = append(aggregates, PlayAggregate{Key: key, Plays: 1})
+	}
+
+	return aggregates
+}
+
+func TestStartOfDay(t *testing.T) {
+	loc, err := time.LoadLocation(statsTimezone)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		now  time.Time
+		want string
+	}{
+		{"evening in utc is the next day in bangkok", time.Date(2026, 10, 17, 18, 30, 0, 0, time.UTC), "2026-10-18"},
+		{"just before midnight in bangkok", time.Date(2026, 10, 17, 16, 59, 59, 0, time.UTC), "2026-10-17"},
+		{"exactly midnight in bangkok", time.Date(2026, 10, 17, 17, 0, 0, 0, time.UTC), "2026-10-18"},
+		{"new year in bangkok", time.Date(2026, 12, 31, 20, 0, 0, 0, time.UTC), "2027-01-01"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := startOfDay(tt.now, loc)
+			if got.Format(playDateLayout) != tt.want {
+				t.Errorf("startOfDay() = %s, want %s", got.Format(playDateLayout), tt.want)
+			}
+			if got.Hour() != 0 || got.Minute() != 0 || got.Location() != loc {
+				t.Errorf("startOfDay() = %v, want midnight in %s", got, statsTimezone)
+			}
+		})
+	}
+}
+
+func TestComputeStreaks(t *testing.T) {
+	loc, err := time.LoadLocation(statsTimezone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	today := time.Date(2026, 10, 18, 0, 0, 0, 0, loc)
+
+	tests := []struct {
+		name        string
+		days        []PlayAggregate
+		wantCurrent int
+		wantLongest int
+	}{
+		{"no plays", []PlayAggregate{}, 0, 0},
+		{"only today", playDays("2026-10-18"), 1, 1},
+		{"only yesterday keeps the streak alive", playDays("2026-10-17"), 1, 1},
+		{"two days ago breaks the streak", playDays("2026-10-16"), 0, 1},
+		{"run up to today", playDays("2026-10-15", "2026-10-16", "2026-10-17", "2026-10-18"), 4, 4},
+		{"run up to yesterday", playDays("2026-10-15", "2026-10-16", "2026-10-17"), 3, 3},
+		{"gap keeps the longer earlier run", playDays("2026-10-01", "2026-10-02", "2026-10-03", "2026-10-17", "2026-10-18"), 2, 3},
+		{"run across a month", playDays("2026-09-29", "2026-09-30", "2026-10-01"), 0, 3},
+		{"run across a year", playDays("2025-12-31", "2026-01-01"), 0, 2},
+		{"days without plays do not count", []PlayAggregate{{Key: "2026-10-17", Plays: 1}, {Key: "2026-10-18", Plays: 0}}, 1, 1},
+		{"unparsable keys are skipped", []PlayAggregate{{Key: "2026-10-17", Plays: 1}, {Key: "not a day", Plays: 3}}, 1, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			current, longest := computeStreaks(tt.days, today)
+			if current != tt.wantCurrent || longest != tt.wantLongest {
+				t.Errorf("computeStreaks() = %d, %d, want %d, %d", current, longest, tt.wantCurrent, tt.wantLongest)
+			}
+		})
+	}
+}
+
+func TestComputeBusiestHour(t *testing.T) {
+	tests := []struct {
+		name  string
+		hours []PlayAggregate
+		want  int
+	}{
+		{"no plays", []PlayAggregate{}, -1},
+		{"hours without plays", []PlayAggregate{{Key: "08", Plays: 0}}, -1},
+		{"single hour", []PlayAggregate{{Key: "23", Plays: 2}}, 23},
+		{"most plays wins", []PlayAggregate{{Key: "00", Plays: 1}, {Key: "13", Plays: 5}, {Key: "22", Plays: 3}}, 13},
+		{"tie goes to the earliest", []PlayAggregate{{Key: "07", Plays: 4}, {Key: "19", Plays: 4}}, 7},
+		{"unparsable keys are skipped", []PlayAggregate{{Key: "x", Plays: 9}, {Key: "05", Plays: 1}}, 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := computeBusiestHour(tt.hours); got != tt.want {
+				t.Errorf("computeBusiestHour() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/bbkbbbk/sapo/pkg/logger"
+	"github.com/bbkbbbk/sapo/spotify"
+)
+
+const (
+	// JobWeeklyPlaylist is the scheduler job pushing recommended playlists to subscribers
+	JobWeeklyPlaylist = "weekly-playlist"
+)
+
+// DeliverWeeklyPlaylists pushes a recommended playlist to every subscriber, a failed delivery is logged
+// and does not stop the others
+func (s *service) DeliverWeeklyPlaylists(ctx context.Context) error {
+	delivered, failed, err := s.forEachAccount(ctx, accountFilter{weeklyPlaylist: true}, func(ctx context.Context, acc *Account) error {
+		return s.deliverWeeklyPlaylist(ctx, *acc)
+	})
+	logger.FromContext(ctx).WithField("delivered", delivered).WithField("failed", failed).Info("weekly playlists delivered")
+	if err != nil {
+		return errors.Wrap(err, "[DeliverWeeklyPlaylists]: unable to deliver weekly playlists")
+	}
+
+	return nil
+}
+
+func (s *service) deliverWeeklyPlaylist(ctx context.Context, acc Account) error {
+	missing := spotify.MissingScopes(acc.GrantedScopes(), commandScopes[textEventCreatePlaylist])
+	if len(missing) > 0 {
+		return errors.Errorf("[deliverWeeklyPlaylist]: missing scopes %v", missing)
+	}
+
+	playlist, err := s.createRecommendedPlaylistForUser(ctx, acc.UID)
+	if err != nil {
+		return errors.Wrap(err, "[deliverWeeklyPlaylist]: unable to create playlist")
+	}
+
+	flex := s.createPlaylistFlexMsg(playlist)
+	if err := s.lineService.PushFlexMsg(ctx, acc.UID, *flex); err != nil {
+		return errors.Wrap(err, "[deliverWeeklyPlaylist]: unable to push flex message")
+	}
+
+	return nil
+}
@@ -26,14 +26,23 @@ type ExternalURLs struct {
 type Artist struct {
 	ID           string       `json:"id"`
 	Name         string       `json:"name"`
+	Genres       []string     `json:"genres"`
+	Popularity   int          `json:"popularity"`
+	Followers    Followers    `json:"followers"`
 	Images       []Image      `json:"images"`
 	ExternalURLs ExternalURLs `json:"external_urls"`
 	URI          string       `json:"uri"`
 }
 
+type Followers struct {
+	Total int `json:"total"`
+}
+
 type Album struct {
 	ID           string             `json:"id"`
-	Name         string             `json:"display_name"`
+	Name         string             `json:"name"`
+	AlbumType    string             `json:"album_type"`
+	ReleaseDate  string             `json:"release_date"`
 	Label        string             `json:"label"`
 	Artists      []SimplifiedObject `json:"artists"`
 	Tracks       []Track            `json:"tracks.items"`
@@ -63,23 +72,36 @@ type SimplifiedObject struct {
 	Name         string       `json:"name"`
 	URI          string       `json:"uri"`
 	ExternalURLs ExternalURLs `json:"external_urls"`
+	// Images is only set for albums
+	Images []Image `json:"images"`
 }
 
 type Tracks struct {
 	Items []Track `json:"tracks"`
 }
 
+type Artists struct {
+	Items []Artist `json:"artists"`
+}
+
 type Albums struct {
 	Items []Album `json:"albums"`
 }
 
 type PlayingHistory struct {
-	Track    SimplifiedObject `json:"track"`
-	PlayedAt string           `json:"played_at"`
+	Track    Track  `json:"track"`
+	PlayedAt string `json:"played_at"`
 }
 
 type PlayingHistoryItems struct {
 	PlayingHistories []PlayingHistory `json:"items"`
+	Cursors          Cursors          `json:"cursors"`
+}
+
+// Cursors page through time ordered results, values are unix times in milliseconds
+type Cursors struct {
+	After  string `json:"after"`
+	Before string `json:"before"`
 }
 
 type TrackItems struct {
@@ -93,3 +115,47 @@ type ArtistItems struct {
 type AlbumItems struct {
 	Albums []Album `json:"items"`
 }
+
+type PlaylistItems struct {
+	Playlists []Playlist `json:"items"`
+}
+
+// SearchResult holds the matches of each searched type, types that were not searched are empty
+type SearchResult struct {
+	Tracks    []Track
+	Artists   []Artist
+	Albums    []Album
+	Playlists []Playlist
+}
+
+type responseSearch struct {
+	Tracks    TrackItems    `json:"tracks"`
+	Artists   ArtistItems   `json:"artists"`
+	Albums    AlbumItems    `json:"albums"`
+	Playlists PlaylistItems `json:"playlists"`
+}
+
+// CurrentlyPlaying is the track playing on the user's active device, Item is nil while an ad or an episode plays
+type CurrentlyPlaying struct {
+	Timestamp   int64  `json:"timestamp"`
+	ProgressMs  int    `json:"progress_ms"`
+	IsPlaying   bool   `json:"is_playing"`
+	Item        *Track `json:"item"`
+	PlayingType string `json:"currently_playing_type"`
+}
+
+// PlaybackState is the currently playing track along with the device playing it
+type PlaybackState struct {
+	CurrentlyPlaying
+	Device       Device `json:"device"`
+	ShuffleState bool   `json:"shuffle_state"`
+	RepeatState  string `json:"repeat_state"`
+}
+
+type Device struct {
+	ID            string `json:"id"`
+	Name          string `json:"name"`
+	Type          string `json:"type"`
+	IsActive      bool   `json:"is_active"`
+	VolumePercent int    `json:"volume_percent"`
+}
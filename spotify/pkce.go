@@ -0,0 +1,36 @@
+package spotify
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	codeVerifierSize  = 64
+	codeChallengeS256 = "S256"
+)
+
+// PKCE holds a code verifier and its S256 challenge for a single authorization request
+type PKCE struct {
+	Verifier  string
+	Challenge string
+}
+
+func NewPKCE() (*PKCE, error) {
+	b := make([]byte, codeVerifierSize)
+	if _, err := rand.Read(b); err != nil {
+		return nil, errors.Wrap(err, "[NewPKCE]: unable to generate code verifier")
+	}
+	verifier := base64.RawURLEncoding.EncodeToString(b)
+
+	sum := sha256.Sum256([]byte(verifier))
+	challenge := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	return &PKCE{
+		Verifier:  verifier,
+		Challenge: challenge,
+	}, nil
+}
@@ -0,0 +1,96 @@
+package spotify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// ReasonNoActiveDevice is the reason player commands fail when spotify is not open on any of the user's devices
+	ReasonNoActiveDevice = "NO_ACTIVE_DEVICE"
+	// ReasonPremiumRequired is the reason player commands fail for users without Spotify Premium
+	ReasonPremiumRequired = "PREMIUM_REQUIRED"
+)
+
+// GetPlaybackState returns nil when spotify is not open on any of the user's devices
+func (s *service) GetPlaybackState(ctx context.Context, token string) (*PlaybackState, error) {
+	spotifyURL := "https://api.spotify.com/v1/me/player?market=from_token"
+
+	res, err := s.makeRequest(ctx, token, http.MethodGet, spotifyURL, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "[GetPlaybackState]: unable to make request")
+	}
+	if len(res) == 0 {
+		return nil, nil
+	}
+
+	var state PlaybackState
+	err = json.Unmarshal(res, &state)
+	if err != nil {
+		return nil, errors.Wrap(err, "[GetPlaybackState]: unable to unmarshal response body")
+	}
+
+	return &state, nil
+}
+
+func (s *service) SkipToNext(ctx context.Context, token string) error {
+	spotifyURL := "https://api.spotify.com/v1/me/player/next"
+
+	_, err := s.makeRequest(ctx, token, http.MethodPost, spotifyURL, nil)
+	if err != nil {
+		return errors.Wrap(err, "[SkipToNext]: unable to make request")
+	}
+
+	return nil
+}
+
+func (s *service) SkipToPrevious(ctx context.Context, token string) error {
+	spotifyURL := "https://api.spotify.com/v1/me/player/previous"
+
+	_, err := s.makeRequest(ctx, token, http.MethodPost, spotifyURL, nil)
+	if err != nil {
+		return errors.Wrap(err, "[SkipToPrevious]: unable to make request")
+	}
+
+	return nil
+}
+
+func (s *service) Pause(ctx context.Context, token string) error {
+	spotifyURL := "https://api.spotify.com/v1/me/player/pause"
+
+	_, err := s.makeRequest(ctx, token, http.MethodPut, spotifyURL, nil)
+	if err != nil {
+		return errors.Wrap(err, "[Pause]: unable to make request")
+	}
+
+	return nil
+}
+
+// Play resumes playback on the active device
+func (s *service) Play(ctx context.Context, token string) error {
+	spotifyURL := "https://api.spotify.com/v1/me/player/play"
+
+	_, err := s.makeRequest(ctx, token, http.MethodPut, spotifyURL, nil)
+	if err != nil {
+		return errors.Wrap(err, "[Play]: unable to make request")
+	}
+
+	return nil
+}
+
+// AddToQueue plays the track with uri after the current one on the active device
+func (s *service) AddToQueue(ctx context.Context, token, uri string) error {
+	spotifyURL := fmt.Sprintf("https://api.spotify.com/v1/me/player/queue?uri=%s", url.QueryEscape(uri))
+
+	_, err := s.makeRequest(ctx, token, http.MethodPost, spotifyURL, nil)
+	if err != nil {
+		return errors.Wrap(err, "[AddToQueue]: unable to make request")
+	}
+
+	return nil
+}
@@ -0,0 +1,197 @@
+package spotify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/bbkbbbk/sapo/pkg/logger"
+)
+
+const (
+	authTokenURL = "https://accounts.spotify.com/api/token"
+)
+
+// pathCollections are the path segments followed by an id, used to keep endpoint names low cardinality
+var pathCollections = map[string]bool{
+	"users":     true,
+	"playlists": true,
+	"albums":    true,
+	"artists":   true,
+	"tracks":    true,
+	"shows":     true,
+	"episodes":  true,
+}
+
+// RequestError is returned when spotify responds with a non 2xx status
+type RequestError struct {
+	StatusCode int
+	Message    string
+	Reason     string
+}
+
+func (e *RequestError) Error() string {
+	if e.Reason != "" {
+		return fmt.Sprintf("spotify responded with status %d: %s (%s)", e.StatusCode, e.Message, e.Reason)
+	}
+
+	return fmt.Sprintf("spotify responded with status %d: %s", e.StatusCode, e.Message)
+}
+
+// StatusCode returns the status spotify responded with when err was caused by a RequestError, zero otherwise
+func StatusCode(err error) int {
+	if reqErr, ok := errors.Cause(err).(*RequestError); ok {
+		return reqErr.StatusCode
+	}
+
+	return 0
+}
+
+// Reason returns the reason spotify gave for a failed request, e.g. NO_ACTIVE_DEVICE or PREMIUM_REQUIRED
+func Reason(err error) string {
+	if reqErr, ok := errors.Cause(err).(*RequestError); ok {
+		return reqErr.Reason
+	}
+
+	return ""
+}
+
+type responseError struct {
+	Error json.RawMessage `json:"error"`
+	// the accounts service describes errors with a sibling field instead of an object
+	Description string `json:"error_description"`
+}
+
+type responseErrorObject struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+	Reason  string `json:"reason"`
+}
+
+func newRequestError(status int, body []byte) *RequestError {
+	reqErr := &RequestError{
+		StatusCode: status,
+		Message:    http.StatusText(status),
+	}
+
+	var res responseError
+	if err := json.Unmarshal(body, &res); err != nil || len(res.Error) == 0 {
+		return reqErr
+	}
+
+	var obj responseErrorObject
+	if err := json.Unmarshal(res.Error, &obj); err == nil {
+		reqErr.Message = obj.Message
+		reqErr.Reason = obj.Reason
+		return reqErr
+	}
+
+	var code string
+	if err := json.Unmarshal(res.Error, &code); err == nil {
+		reqErr.Message = res.Description
+		reqErr.Reason = code
+	}
+
+	return reqErr
+}
+
+// endpointName turns a request url into a name like "GET /playlists/{id}/tracks" for logs
+func endpointName(method, rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return method
+	}
+
+	path := strings.TrimPrefix(u.Path, "/v1")
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for i := 1; i < len(segments); i++ {
+		if pathCollections[segments[i-1]] {
+			segments[i] = "{id}"
+		}
+	}
+
+	return fmt.Sprintf("%s /%s", method, strings.Join(segments, "/"))
+}
+
+func requestEndpointName(req *http.Request) string {
+	return endpointName(req.Method, req.URL.String())
+}
+
+func (s *service) makeAuthRequest(ctx context.Context, form url.Values) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, authTokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, errors.Wrap(err, "[makeAuthRequest]: unable to create request")
+	}
+	req.Header.Add("Authorization", s.newAuthHeader())
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Add("Content-Length", strconv.Itoa(len(form.Encode())))
+
+	body, err := s.do(ctx, req)
+	if err != nil {
+		return nil, errors.Wrap(err, "[makeAuthRequest]: unable to make a success request")
+	}
+
+	return body, nil
+}
+
+func (s *service) makeRequest(ctx context.Context, token, method, url string, reqBody io.Reader) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
+	if err != nil {
+		return nil, errors.Wrap(err, "[makeRequest]: unable to create request")
+	}
+	req.Header.Add("Authorization", s.newAuthAccessHeader(token))
+	req.Header.Add("Content-Type", "application/json")
+
+	body, err := s.do(ctx, req)
+	if err != nil {
+		return nil, errors.Wrap(err, "[makeRequest]: unable to make a success request")
+	}
+
+	return body, nil
+}
+
+// do sends the request, logs its outcome and returns the body of a 2xx response
+func (s *service) do(ctx context.Context, req *http.Request) ([]byte, error) {
+	start := time.Now()
+	log := logger.FromContext(ctx).WithField(logger.FieldSpotifyEndpoint, endpointName(req.Method, req.URL.String()))
+
+	res, err := s.httpClient.Do(req)
+	if err != nil {
+		log.WithError(err).WithField(logger.FieldLatency, time.Since(start).Milliseconds()).Warn("spotify request failed")
+		return nil, errors.Wrap(err, "[do]: unable to get response from spotify")
+	}
+	defer func() {
+		err := res.Body.Close()
+		if err != nil {
+			log.WithError(err).Warn("unable to close spotify response body")
+		}
+	}()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "[do]: unable to read response body")
+	}
+
+	log = log.WithFields(logrus.Fields{
+		logger.FieldStatus:  res.StatusCode,
+		logger.FieldLatency: time.Since(start).Milliseconds(),
+	})
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		reqErr := newRequestError(res.StatusCode, body)
+		log.WithError(reqErr).Warn("spotify request failed")
+		return nil, reqErr
+	}
+	log.Debug("spotify request succeeded")
+
+	return body, nil
+}
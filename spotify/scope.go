@@ -0,0 +1,83 @@
+package spotify
+
+import "strings"
+
+const (
+	ScopeUserReadRecentlyPlayed    = "user-read-recently-played"
+	ScopeUserTopRead               = "user-top-read"
+	ScopeUserLibraryRead           = "user-library-read"
+	ScopeUserLibraryModify         = "user-library-modify"
+	ScopeUserFollowModify          = "user-follow-modify"
+	ScopeUserReadPlaybackState     = "user-read-playback-state"
+	ScopeUserModifyPlaybackState   = "user-modify-playback-state"
+	ScopeUserReadCurrentlyPlaying  = "user-read-currently-playing"
+	ScopePlaylistReadCollaborative = "playlist-read-collaborative"
+	ScopePlaylistModifyPublic      = "playlist-modify-public"
+	ScopePlaylistModifyPrivate     = "playlist-modify-private"
+)
+
+// DefaultScopes are requested on every sign-up, accounts created before scopes were stored were granted exactly these
+var DefaultScopes = []string{
+	ScopeUserReadRecentlyPlayed,
+	ScopePlaylistModifyPublic,
+	ScopePlaylistReadCollaborative,
+	ScopeUserTopRead,
+	ScopeUserLibraryRead,
+}
+
+var knownScopes = map[string]bool{
+	ScopeUserReadRecentlyPlayed:    true,
+	ScopeUserTopRead:               true,
+	ScopeUserLibraryRead:           true,
+	ScopeUserLibraryModify:         true,
+	ScopeUserFollowModify:          true,
+	ScopeUserReadPlaybackState:     true,
+	ScopeUserModifyPlaybackState:   true,
+	ScopeUserReadCurrentlyPlaying:  true,
+	ScopePlaylistReadCollaborative: true,
+	ScopePlaylistModifyPublic:      true,
+	ScopePlaylistModifyPrivate:     true,
+}
+
+func IsKnownScope(scope string) bool {
+	return knownScopes[scope]
+}
+
+// ParseScopes splits a space separated scope string as returned by the token endpoint
+func ParseScopes(scope string) []string {
+	return strings.Fields(scope)
+}
+
+// MergeScopes returns the union of the given scope lists without duplicates, keeping the first seen order
+func MergeScopes(lists ...[]string) []string {
+	seen := map[string]bool{}
+	merged := []string{}
+	for _, list := range lists {
+		for _, scope := range list {
+			if seen[scope] {
+				continue
+			}
+			seen[scope] = true
+			merged = append(merged, scope)
+		}
+	}
+
+	return merged
+}
+
+// MissingScopes returns the required scopes that are not in granted
+func MissingScopes(granted, required []string) []string {
+	has := map[string]bool{}
+	for _, scope := range granted {
+		has[scope] = true
+	}
+
+	missing := []string{}
+	for _, scope := range required {
+		if !has[scope] {
+			missing = append(missing, scope)
+		}
+	}
+
+	return missing
+}
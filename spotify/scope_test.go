@@ -0,0 +1,59 @@
+package spotify
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMergeScopes(t *testing.T) {
+	tests := []struct {
+		name  string
+		lists [][]string
+		want  []string
+	}{
+		{"nothing", nil, []string{}},
+		{"empty lists", [][]string{{}, nil}, []string{}},
+		{"single list", [][]string{{ScopeUserTopRead, ScopeUserLibraryRead}}, []string{ScopeUserTopRead, ScopeUserLibraryRead}},
+		{
+			name:  "union keeps the first seen order",
+			lists: [][]string{{ScopeUserTopRead, ScopeUserLibraryRead}, {ScopeUserFollowModify, ScopeUserTopRead}},
+			want:  []string{ScopeUserTopRead, ScopeUserLibraryRead, ScopeUserFollowModify},
+		},
+		{"duplicates within a list", [][]string{{ScopeUserTopRead, ScopeUserTopRead}}, []string{ScopeUserTopRead}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MergeScopes(tt.lists...); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("MergeScopes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMissingScopes(t *testing.T) {
+	tests := []struct {
+		name     string
+		granted  []string
+		required []string
+		want     []string
+	}{
+		{"nothing required", DefaultScopes, nil, []string{}},
+		{"everything granted", DefaultScopes, []string{ScopeUserTopRead, ScopePlaylistModifyPublic}, []string{}},
+		{"nothing granted", nil, []string{ScopeUserTopRead}, []string{ScopeUserTopRead}},
+		{
+			name:     "only the missing ones in required order",
+			granted:  []string{ScopeUserTopRead},
+			required: []string{ScopeUserFollowModify, ScopeUserTopRead, ScopeUserLibraryModify},
+			want:     []string{ScopeUserFollowModify, ScopeUserLibraryModify},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MissingScopes(tt.granted, tt.required); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("MissingScopes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
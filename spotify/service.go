@@ -2,29 +2,44 @@ package spotify
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"io"
-	"io/ioutil"
 	"net/http"
 	"net/url"
-	"strconv"
 	"strings"
 	"time"
 
 	"github.com/pkg/errors"
-	"github.com/sirupsen/logrus"
+
+	"github.com/bbkbbbk/sapo/pkg/metrics"
+	"github.com/bbkbbbk/sapo/pkg/tracing"
 )
 
 const (
 	defaultTimeout = 30
-	scopes         = "user-read-recently-played playlist-modify-public playlist-read-collaborative user-read-recently-played user-top-read user-library-read"
 
 	AuthState                = "spotify-auth-state"
+	AuthCodeVerifier         = "spotify-code-verifier"
 	LimitCurrentlyPlayedSize = 50
 	LimitSeedSize            = 5
 	LimitPlaylistSize        = 25
+	RollingPlaylistName      = "Tracks for you by sapo"
+	// PreviewDurationMs is the length of the clip a track's PreviewURL points to
+	PreviewDurationMs = 30000
+
+	// TimeRangeShort covers about the last 4 weeks, TimeRangeMedium the last 6 months and TimeRangeLong several years
+	TimeRangeShort  = "short_term"
+	TimeRangeMedium = "medium_term"
+	TimeRangeLong   = "long_term"
+
+	SearchTypeTrack    = "track"
+	SearchTypeArtist   = "artist"
+	SearchTypeAlbum    = "album"
+	SearchTypePlaylist = "playlist"
+	// MarketFromToken limits results to what is playable in the country of the user the token belongs to
+	MarketFromToken = "from_token"
 )
 
 var (
@@ -32,23 +47,44 @@ var (
 )
 
 type Service interface {
-	GetAuthURL(state string) string
-	RequestToken(code string) (string, string, error)
-	RequestAccessTokenFromRefreshToken(token string) (string, error)
-	CreateRecommendedPlaylistForUser(token, uid string) (string, error)
-	GetUserProfile(token string) (*User, error)
-	GetPlaylist(token, id string) (*Playlist, error)
-	GetAlbum(token string, id string) (*Album, error)
-	GetAlbums(token string, ids []string) ([]Album, error)
-	GetTopArtists(token string, limit int) ([]Artist, error)
-	GetTopTracks(token string, limit int) ([]Track, error)
-	GetRandomTrack(token string) (*Track, error)
+	GetAuthURL(state, codeChallenge string, scopes []string) string
+	RequestToken(ctx context.Context, code, codeVerifier string) (*Token, error)
+	RequestAccessTokenFromRefreshToken(ctx context.Context, token string) (string, error)
+	CreateRecommendedPlaylistForUser(ctx context.Context, token, uid string) (*RecommendedPlaylist, error)
+	RefreshRecommendedPlaylistForUser(ctx context.Context, token, uid, id string) (*RecommendedPlaylist, error)
+	CreateArtistsPlaylistForUser(ctx context.Context, token, uid, name string, artistIDs []string) (*RecommendedPlaylist, error)
+	GetUserProfile(ctx context.Context, token string) (*User, error)
+	GetPlaylist(ctx context.Context, token, id string) (*Playlist, error)
+	GetAlbum(ctx context.Context, token string, id string) (*Album, error)
+	GetAlbums(ctx context.Context, token string, ids []string) ([]Album, error)
+	GetTopArtists(ctx context.Context, token, timeRange string, limit int) ([]Artist, error)
+	GetTopTracks(ctx context.Context, token, timeRange string, limit int) ([]Track, error)
+	GetRandomTrack(ctx context.Context, token string) (*Track, error)
+	GetRecentlyPlayedAfter(ctx context.Context, token string, after time.Time) ([]PlayingHistory, error)
+	AddTracksToPlaylist(ctx context.Context, token, id string, uris []string) error
+	Search(ctx context.Context, token, query string, types []string, limit int, market string) (*SearchResult, error)
+	GetArtist(ctx context.Context, token, id string) (*Artist, error)
+	GetArtistTopTracks(ctx context.Context, token, id, market string) ([]Track, error)
+	GetRelatedArtists(ctx context.Context, token, id string) ([]Artist, error)
+	GetArtistAlbums(ctx context.Context, token, id string, limit int, market string) ([]Album, error)
+	GetTrack(ctx context.Context, token, id string) (*Track, error)
+	SaveTracks(ctx context.Context, token string, ids []string) error
+	FollowArtists(ctx context.Context, token string, ids []string) error
+	GetPlaybackState(ctx context.Context, token string) (*PlaybackState, error)
+	SkipToNext(ctx context.Context, token string) error
+	SkipToPrevious(ctx context.Context, token string) error
+	Pause(ctx context.Context, token string) error
+	Play(ctx context.Context, token string) error
+	AddToQueue(ctx context.Context, token, uri string) error
+	GetTracksBasedOnSeeds(ctx context.Context, token string, seeds []string, limit int) ([]Track, error)
+	CreateCollaborativePlaylistForUser(ctx context.Context, token, uid, name, description string, uris []string) (string, error)
 }
 
 type service struct {
 	ClientID    string
 	ClintSecret string
 	CallbackURL string
+	httpClient  *http.Client
 }
 
 type responseTokenBody struct {
@@ -59,9 +95,31 @@ type responseTokenBody struct {
 	RefreshToken   string `json:"refresh_token"`
 }
 
+// Token is the result of an authorization code exchange
+type Token struct {
+	AccessToken  string
+	RefreshToken string
+	Scopes       []string
+}
+
+// RecommendedPlaylist is a playlist filled with recommendations and what it was made of,
+// Created is false when the tracks of an existing playlist were replaced
+type RecommendedPlaylist struct {
+	ID        string
+	Seeds     []string
+	TrackURIs []string
+	Created   bool
+}
+
+type requestPlaylistTracks struct {
+	URIs []string `json:"uris"`
+}
+
 type requestCreatePlaylist struct {
-	Name        string `json:"name"`
-	Description string `json:"description"`
+	Name          string `json:"name,omitempty"`
+	Description   string `json:"description"`
+	Public        *bool  `json:"public,omitempty"`
+	Collaborative bool   `json:"collaborative,omitempty"`
 }
 
 func NewSpotifyService(id, secret, url string) Service {
@@ -70,82 +128,13 @@ func NewSpotifyService(id, secret, url string) Service {
 		ClientID:    id,
 		ClintSecret: secret,
 		CallbackURL: callbackURL,
+		httpClient: &http.Client{
+			Timeout:   time.Second * defaultTimeout,
+			Transport: tracing.NewTransport("spotify", requestEndpointName, metrics.NewTransport("spotify", requestEndpointName, nil)),
+		},
 	}
 }
 
-func (s *service) makeAuthRequest(form url.Values) ([]byte, error) {
-	spotifyURL := "https://accounts.spotify.com/api/token"
-
-	req, err := http.NewRequest("POST", spotifyURL, strings.NewReader(form.Encode()))
-	if err != nil {
-		return nil, errors.Wrap(err, "[makeAuthRequest]: unable to create request")
-	}
-	req.Header.Add("Authorization", s.newAuthHeader())
-	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-	req.Header.Add("Content-Length", strconv.Itoa(len(form.Encode())))
-
-	client := &http.Client{
-		Timeout: time.Second * defaultTimeout,
-	}
-
-	res, err := client.Do(req)
-	defer func() {
-		err := res.Body.Close()
-		if err != nil {
-			logrus.Warn("[makeAuthRequest]: unable to close response body", err)
-		}
-	}()
-	if err != nil {
-		return nil, errors.Wrap(err, "[makeAuthRequest]: unable to get response from spotify")
-	}
-
-	if res.StatusCode < 200 || res.StatusCode > 299 {
-		return nil, errors.Wrap(err, "[makeAuthRequest]: unable to make a success request")
-	}
-
-	body, err := ioutil.ReadAll(res.Body)
-	if err != nil {
-		return nil, errors.Wrap(err, "[makeAuthRequest]: unable to read response body")
-	}
-
-	return body, nil
-}
-
-func (s *service) makeRequest(token, method, url string, reqBody io.Reader) ([]byte, error) {
-	req, err := http.NewRequest(method, url, reqBody)
-	if err != nil {
-		return nil, errors.Wrap(err, "[makeRequest]: unable to create request")
-	}
-	req.Header.Add("Authorization", s.newAuthAccessHeader(token))
-	req.Header.Add("Content-Type", "application/json")
-
-	client := &http.Client{
-		Timeout: time.Second * defaultTimeout,
-	}
-
-	res, err := client.Do(req)
-	defer func() {
-		err := res.Body.Close()
-		if err != nil {
-			logrus.Warn("[makeRequest]: unable to close response body", err)
-		}
-	}()
-	if err != nil {
-		return nil, errors.Wrap(err, "[makeRequest]: unable to get response from spotify")
-	}
-
-	if res.StatusCode < 200 || res.StatusCode > 299 {
-		return nil, errors.Wrap(err, "[makeRequest]: unable to make a success request")
-	}
-
-	body, err := ioutil.ReadAll(res.Body)
-	if err != nil {
-		return nil, errors.Wrap(err, "[makeRequest]: unable to read response body")
-	}
-
-	return body, nil
-}
-
 func (s *service) newAuthHeader() string {
 	raw := fmt.Sprintf("%s:%s", s.ClientID, s.ClintSecret)
 	encoded := base64.StdEncoding.EncodeToString([]byte(raw))
@@ -158,44 +147,61 @@ func (s *service) newAuthAccessHeader(token string) string {
 	return fmt.Sprintf("Bearer %s", token)
 }
 
-func (s *service) GetAuthURL(state string) string {
+// GetAuthURL builds the authorization url, codeChallenge is optional and enables PKCE when set
+func (s *service) GetAuthURL(state, codeChallenge string, scopes []string) string {
 	spotifyURL := "https://accounts.spotify.com/authorize"
 
-	scope := url.QueryEscape(scopes)
-	path := fmt.Sprintf("%s?client_id=%s&scope=%s&response_type=code&redirect_uri=%s&state=%s", spotifyURL, s.ClientID, scope, s.CallbackURL, state)
+	query := url.Values{}
+	query.Add("client_id", s.ClientID)
+	query.Add("scope", strings.Join(scopes, " "))
+	query.Add("response_type", "code")
+	query.Add("redirect_uri", s.CallbackURL)
+	query.Add("state", state)
+	if codeChallenge != "" {
+		query.Add("code_challenge_method", codeChallengeS256)
+		query.Add("code_challenge", codeChallenge)
+	}
 
-	return path
+	return fmt.Sprintf("%s?%s", spotifyURL, query.Encode())
 }
 
-func (s *service) RequestToken(code string) (string, string, error) {
+func (s *service) RequestToken(ctx context.Context, code, codeVerifier string) (*Token, error) {
 	form := url.Values{}
 	form.Add("grant_type", "authorization_code")
 	form.Add("code", code)
 	form.Add("redirect_uri", s.CallbackURL)
+	if codeVerifier != "" {
+		form.Add("client_id", s.ClientID)
+		form.Add("code_verifier", codeVerifier)
+	}
 
-	res, err := s.makeAuthRequest(form)
+	res, err := s.makeAuthRequest(ctx, form)
 	if err != nil {
-		return "", "", errors.Wrap(err, "[RequestToken]: unable to make request")
+		return nil, errors.Wrap(err, "[RequestToken]: unable to make request")
 	}
 
-	var token responseTokenBody
-	err = json.Unmarshal(res, &token)
+	var tokenRes responseTokenBody
+	err = json.Unmarshal(res, &tokenRes)
 	if err != nil {
-		return "", "", errors.Wrap(err, "[RequestToken]: unable to unmarshal response body")
+		return nil, errors.Wrap(err, "[RequestToken]: unable to unmarshal response body")
 	}
 
-	accessToken := token.AccessToken
-	refreshToken := token.RefreshToken
+	token := &Token{
+		AccessToken:  tokenRes.AccessToken,
+		RefreshToken: tokenRes.RefreshToken,
+		Scopes:       ParseScopes(tokenRes.Scope),
+	}
 
-	return accessToken, refreshToken, nil
+	return token, nil
 }
 
-func (s *service) RequestAccessTokenFromRefreshToken(token string) (string, error) {
+func (s *service) RequestAccessTokenFromRefreshToken(ctx context.Context, token string) (string, error) {
 	form := url.Values{}
 	form.Add("grant_type", "refresh_token")
 	form.Add("refresh_token", token)
 
-	res, err := s.makeAuthRequest(form)
+	res, err := s.makeAuthRequest(ctx, form)
+	metrics.TokenRefreshes.WithLabelValues(metrics.Outcome(err)).Inc()
 	if err != nil {
 		return "", errors.Wrap(err, "[RequestAccessTokenFromRefreshToken]: unable to make request")
 	}
@@ -211,10 +217,10 @@ func (s *service) RequestAccessTokenFromRefreshToken(token string) (string, erro
 	return accessToken, nil
 }
 
-func (s *service) GetCurrentTrackSeeds(token string) ([]string, error) {
+func (s *service) GetCurrentTrackSeeds(ctx context.Context, token string) ([]string, error) {
 	spotifyURL := fmt.Sprintf("https://api.spotify.com/v1/me/player/recently-played?limit=%v", LimitCurrentlyPlayedSize)
 
-	res, err := s.makeRequest(token, http.MethodGet, spotifyURL, nil)
+	res, err := s.makeRequest(ctx, token, http.MethodGet, spotifyURL, nil)
 	if err != nil {
 		return nil, errors.Wrap(err, "[GetSeeds]: unable to make request")
 	}
@@ -235,25 +241,65 @@ func (s *service) GetCurrentTrackSeeds(token string) ([]string, error) {
 	return seedTracks, nil
 }
 
-func (s *service) GetTracksBasedOnSeeds(token string, seeds []string, limit int) ([]Track, error) {
-	if len(seeds) > LimitSeedSize {
+// GetRecentlyPlayedAfter returns up to LimitCurrentlyPlayedSize plays after the given time, the latest first
+func (s *service) GetRecentlyPlayedAfter(ctx context.Context, token string, after time.Time) ([]PlayingHistory, error) {
+	spotifyURL := fmt.Sprintf("https://api.spotify.com/v1/me/player/recently-played?limit=%v&after=%d", LimitCurrentlyPlayedSize, after.UnixNano()/int64(time.Millisecond))
+
+	res, err := s.makeRequest(ctx, token, http.MethodGet, spotifyURL, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "[GetRecentlyPlayedAfter]: unable to make request")
+	}
+
+	var items PlayingHistoryItems
+	err = json.Unmarshal(res, &items)
+	if err != nil {
+		return nil, errors.Wrap(err, "[GetRecentlyPlayedAfter]: unable to unmarshal response body")
+	}
+
+	histories := []PlayingHistory{}
+	histories = append(histories, items.PlayingHistories...)
+
+	return histories, nil
+}
+
+func (s *service) GetTracksBasedOnSeeds(ctx context.Context, token string, seeds []string, limit int) ([]Track, error) {
+	tracks, err := s.getRecommendations(ctx, token, "seed_tracks", seeds, limit)
+	if err != nil {
+		return nil, errors.Wrap(err, "[GetTracksBasedOnSeeds]: unable to get recommendations")
+	}
+
+	return tracks, nil
+}
+
+// GetTracksBasedOnArtistSeeds recommends tracks similar to up to LimitSeedSize artists
+func (s *service) GetTracksBasedOnArtistSeeds(ctx context.Context, token string, artistIDs []string, limit int) ([]Track, error) {
+	tracks, err := s.getRecommendations(ctx, token, "seed_artists", artistIDs, limit)
+	if err != nil {
+		return nil, errors.Wrap(err, "[GetTracksBasedOnArtistSeeds]: unable to get recommendations")
+	}
+
+	return tracks, nil
+}
+
+func (s *service) getRecommendations(ctx context.Context, token, seedParam string, seeds []string, limit int) ([]Track, error) {
+	if len(seeds) == 0 || len(seeds) > LimitSeedSize {
 		return nil, errorInvalidSeed
 	}
 
 	spotifyURL := "https://api.spotify.com/v1/recommendations"
-	seedTracks := strings.Join(seeds, ",")
+	seedIDs := strings.Join(seeds, ",")
 
-	path := fmt.Sprintf("%s?limit=%d&seed_tracks=%s", spotifyURL, limit, seedTracks)
+	path := fmt.Sprintf("%s?limit=%d&%s=%s", spotifyURL, limit, seedParam, seedIDs)
 
-	res, err := s.makeRequest(token, http.MethodGet, path, nil)
+	res, err := s.makeRequest(ctx, token, http.MethodGet, path, nil)
 	if err != nil {
-		return nil, errors.Wrap(err, "[GetRecommendationsBasedOnSeeds]: unable to make request")
+		return nil, errors.Wrap(err, "[getRecommendations]: unable to make request")
 	}
 
 	var items Tracks
 	err = json.Unmarshal(res, &items)
 	if err != nil {
-		return nil, errors.Wrap(err, "[GetRecommendationsBasedOnSeeds]: unable to unmarshal response body")
+		return nil, errors.Wrap(err, "[getRecommendations]: unable to unmarshal response body")
 	}
 
 	tracks := []Track{}
@@ -262,29 +308,68 @@ func (s *service) GetTracksBasedOnSeeds(token string, seeds []string, limit int)
 	return tracks, nil
 }
 
-func (s *service) CreatePlaylistForUser(token, uid string) (string, error) {
-	spotifyURL := fmt.Sprintf("https://api.spotify.com/v1/users/%s/playlists", uid)
+func (s *service) CreatePlaylistForUser(ctx context.Context, token, uid string) (string, error) {
 	now := time.Now()
 	name := fmt.Sprintf("%s Tracks for you", now.Format("2006-01-02"))
 
+	id, err := s.createPlaylist(ctx, token, uid, name, "Playlist created by sapo")
+	if err != nil {
+		return "", errors.Wrap(err, "[CreatePlaylistForUser]: unable to create playlist")
+	}
+
+	return id, nil
+}
+
+func (s *service) createPlaylist(ctx context.Context, token, uid, name, description string) (string, error) {
 	reqCreate := requestCreatePlaylist{
 		Name:        name,
-		Description: "Playlist created by sapo",
+		Description: description,
 	}
+
+	return s.postPlaylist(ctx, token, uid, reqCreate)
+}
+
+// CreateCollaborativePlaylistForUser creates a playlist filled with uris that everyone it is shared with can add to,
+// spotify requires collaborative playlists to be private
+func (s *service) CreateCollaborativePlaylistForUser(ctx context.Context, token, uid, name, description string, uris []string) (string, error) {
+	public := false
+	reqCreate := requestCreatePlaylist{
+		Name:          name,
+		Description:   description,
+		Public:        &public,
+		Collaborative: true,
+	}
+
+	id, err := s.postPlaylist(ctx, token, uid, reqCreate)
+	if err != nil {
+		return "", errors.Wrap(err, "[CreateCollaborativePlaylistForUser]: unable to create playlist")
+	}
+
+	err = s.AddTracksToPlaylist(ctx, token, id, uris)
+	if err != nil {
+		return "", errors.Wrap(err, "[CreateCollaborativePlaylistForUser]: unable to add track to a playlist")
+	}
+
+	return id, nil
+}
+
+func (s *service) postPlaylist(ctx context.Context, token, uid string, reqCreate requestCreatePlaylist) (string, error) {
+	spotifyURL := fmt.Sprintf("https://api.spotify.com/v1/users/%s/playlists", uid)
+
 	body, err := json.Marshal(&reqCreate)
 	if err != nil {
-		return "", errors.Wrap(err, "[CreatePlaylistForUser]: unable to marshal request body")
+		return "", errors.Wrap(err, "[postPlaylist]: unable to marshal request body")
 	}
 
-	res, err := s.makeRequest(token, http.MethodPost, spotifyURL, bytes.NewReader(body))
+	res, err := s.makeRequest(ctx, token, http.MethodPost, spotifyURL, bytes.NewReader(body))
 	if err != nil {
-		return "", errors.Wrap(err, "[CreatePlaylistForUser]: unable to make request")
+		return "", errors.Wrap(err, "[postPlaylist]: unable to make request")
 	}
 
 	var playlist Playlist
 	err = json.Unmarshal(res, &playlist)
 	if err != nil {
-		return "", errors.Wrap(err, "[CreatePlaylistForUser]: unable to unmarshal response body")
+		return "", errors.Wrap(err, "[postPlaylist]: unable to unmarshal response body")
 	}
 
 	id := playlist.ID
@@ -292,11 +377,70 @@ func (s *service) CreatePlaylistForUser(token, uid string) (string, error) {
 	return id, nil
 }
 
-func (s *service) AddTracksToPlaylist(token, id string, uris []string) error {
+// UpdatePlaylistDescription changes the description of a playlist the user owns
+func (s *service) UpdatePlaylistDescription(ctx context.Context, token, id, description string) error {
+	spotifyURL := fmt.Sprintf("https://api.spotify.com/v1/playlists/%s", id)
+
+	reqUpdate := requestCreatePlaylist{
+		Description: description,
+	}
+	body, err := json.Marshal(&reqUpdate)
+	if err != nil {
+		return errors.Wrap(err, "[UpdatePlaylistDescription]: unable to marshal request body")
+	}
+
+	_, err = s.makeRequest(ctx, token, http.MethodPut, spotifyURL, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "[UpdatePlaylistDescription]: unable to make request")
+	}
+
+	return nil
+}
+
+// ReplacePlaylistTracks replaces every track of a playlist with uris, at most 100 uris are accepted
+func (s *service) ReplacePlaylistTracks(ctx context.Context, token, id string, uris []string) error {
+	spotifyURL := fmt.Sprintf("https://api.spotify.com/v1/playlists/%s/tracks", id)
+
+	body, err := json.Marshal(&requestPlaylistTracks{URIs: uris})
+	if err != nil {
+		return errors.Wrap(err, "[ReplacePlaylistTracks]: unable to marshal request body")
+	}
+
+	_, err = s.makeRequest(ctx, token, http.MethodPut, spotifyURL, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "[ReplacePlaylistTracks]: unable to make request")
+	}
+
+	return nil
+}
+
+// IsFollowingPlaylist reports whether the user still follows a playlist,
+// deleting a playlist on spotify only unfollows it so this is how a deleted playlist is detected
+func (s *service) IsFollowingPlaylist(ctx context.Context, token, id, uid string) (bool, error) {
+	spotifyURL := fmt.Sprintf("https://api.spotify.com/v1/playlists/%s/followers/contains?ids=%s", id, uid)
+
+	res, err := s.makeRequest(ctx, token, http.MethodGet, spotifyURL, nil)
+	if err != nil {
+		if StatusCode(err) == http.StatusNotFound {
+			return false, nil
+		}
+		return false, errors.Wrap(err, "[IsFollowingPlaylist]: unable to make request")
+	}
+
+	var following []bool
+	err = json.Unmarshal(res, &following)
+	if err != nil {
+		return false, errors.Wrap(err, "[IsFollowingPlaylist]: unable to unmarshal response body")
+	}
+
+	return len(following) > 0 && following[0], nil
+}
+
+func (s *service) AddTracksToPlaylist(ctx context.Context, token, id string, uris []string) error {
 	urisParam := strings.Join(uris, ",")
 	spotifyURL := fmt.Sprintf("https://api.spotify.com/v1/playlists/%s/tracks?uris=%s", id, urisParam)
 
-	_, err := s.makeRequest(token, http.MethodPost, spotifyURL, nil)
+	_, err := s.makeRequest(ctx, token, http.MethodPost, spotifyURL, nil)
 	if err != nil {
 		return errors.Wrap(err, "[AddTracksToPlaylist]: unable to make request")
 	}
@@ -304,30 +448,118 @@ func (s *service) AddTracksToPlaylist(token, id string, uris []string) error {
 	return nil
 }
 
-func (s *service) CreateRecommendedPlaylistForUser(token, uid string) (string, error) {
-	seeds, err := s.GetCurrentTrackSeeds(token)
+func (s *service) CreateRecommendedPlaylistForUser(ctx context.Context, token, uid string) (*RecommendedPlaylist, error) {
+	seeds, uris, err := s.getRecommendedTrackURIs(ctx, token)
+	if err != nil {
+		return nil, errors.Wrap(err, "[CreateRecommendedPlaylistForUser]: unable to get recommended tracks")
+	}
+
+	playlistId, err := s.CreatePlaylistForUser(ctx, token, uid)
+	if err != nil {
+		return nil, errors.Wrap(err, "[CreateRecommendedPlaylistForUser]: unable to create playlist")
+	}
+
+	err = s.AddTracksToPlaylist(ctx, token, playlistId, uris)
+	if err != nil {
+		return nil, errors.Wrap(err, "[CreateRecommendedPlaylistForUser]: unable to add track to a playlist")
+	}
+
+	return &RecommendedPlaylist{
+		ID:        playlistId,
+		Seeds:     seeds,
+		TrackURIs: uris,
+		Created:   true,
+	}, nil
+}
+
+// RefreshRecommendedPlaylistForUser replaces the tracks of the playlist with id with new recommendations,
+// the playlist is created first when id is empty or the user deleted it
+func (s *service) RefreshRecommendedPlaylistForUser(ctx context.Context, token, uid, id string) (*RecommendedPlaylist, error) {
+	seeds, uris, err := s.getRecommendedTrackURIs(ctx, token)
 	if err != nil {
-		return "", errors.Wrap(err, "[CreateRecommendedPlaylistForUser]: unable to get seeds")
+		return nil, errors.Wrap(err, "[RefreshRecommendedPlaylistForUser]: unable to get recommended tracks")
+	}
+
+	created := false
+	if id != "" {
+		following, err := s.IsFollowingPlaylist(ctx, token, id, uid)
+		if err != nil {
+			return nil, errors.Wrap(err, "[RefreshRecommendedPlaylistForUser]: unable to check playlist")
+		}
+		if !following {
+			id = ""
+		}
+	}
+	if id == "" {
+		id, err = s.createPlaylist(ctx, token, uid, RollingPlaylistName, "")
+		if err != nil {
+			return nil, errors.Wrap(err, "[RefreshRecommendedPlaylistForUser]: unable to create playlist")
+		}
+		created = true
 	}
 
-	tracks, err := s.GetTracksBasedOnSeeds(token, seeds, LimitPlaylistSize)
+	err = s.ReplacePlaylistTracks(ctx, token, id, uris)
 	if err != nil {
-		return "", errors.Wrap(err, "[CreateRecommendedPlaylistForUser]: unable to get tracks from seeds")
+		return nil, errors.Wrap(err, "[RefreshRecommendedPlaylistForUser]: unable to replace tracks")
 	}
 
-	playlistId, err := s.CreatePlaylistForUser(token, uid)
+	description := fmt.Sprintf("Tracks picked by sapo from what you have been listening to, refreshed on %s", time.Now().Format("2 Jan 2006"))
+	err = s.UpdatePlaylistDescription(ctx, token, id, description)
 	if err != nil {
-		return "", errors.Wrap(err, "[CreateRecommendedPlaylistForUser]: unable to create playlist")
+		return nil, errors.Wrap(err, "[RefreshRecommendedPlaylistForUser]: unable to update description")
 	}
 
+	return &RecommendedPlaylist{
+		ID:        id,
+		Seeds:     seeds,
+		TrackURIs: uris,
+		Created:   created,
+	}, nil
+}
+
+// CreateArtistsPlaylistForUser creates a playlist named name filled with recommendations seeded on up to LimitSeedSize artists
+func (s *service) CreateArtistsPlaylistForUser(ctx context.Context, token, uid, name string, artistIDs []string) (*RecommendedPlaylist, error) {
+	tracks, err := s.GetTracksBasedOnArtistSeeds(ctx, token, artistIDs, LimitPlaylistSize)
+	if err != nil {
+		return nil, errors.Wrap(err, "[CreateArtistsPlaylistForUser]: unable to get recommended tracks")
+	}
 	uris := s.getURIsFromTracks(tracks)
 
-	err = s.AddTracksToPlaylist(token, playlistId, uris)
+	id, err := s.createPlaylist(ctx, token, uid, name, "Playlist created by sapo")
+	if err != nil {
+		return nil, errors.Wrap(err, "[CreateArtistsPlaylistForUser]: unable to create playlist")
+	}
+
+	err = s.AddTracksToPlaylist(ctx, token, id, uris)
 	if err != nil {
-		return "", errors.Wrap(err, "[CreateRecommendedPlaylistForUser]: unable to add track to a playlist")
+		return nil, errors.Wrap(err, "[CreateArtistsPlaylistForUser]: unable to add track to a playlist")
 	}
 
-	return playlistId, nil
+	return &RecommendedPlaylist{
+		ID:        id,
+		Seeds:     artistIDs,
+		TrackURIs: uris,
+		Created:   true,
+	}, nil
+}
+
+func (s *service) getRecommendedTrackURIs(ctx context.Context, token string) ([]string, []string, error) {
+	seeds, err := s.GetCurrentTrackSeeds(ctx, token)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "[getRecommendedTrackURIs]: unable to get seeds")
+	}
+
+	tracks, err := s.GetTracksBasedOnSeeds(ctx, token, seeds, LimitPlaylistSize)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "[getRecommendedTrackURIs]: unable to get tracks from seeds")
+	}
+
+	return seeds, s.getURIsFromTracks(tracks), nil
+}
+
+// TrackURI returns the spotify uri of the track with id
+func TrackURI(id string) string {
+	return fmt.Sprintf("spotify:track:%s", id)
 }
 
 func (s *service) getURIsFromTracks(tracks []Track) []string {
@@ -340,10 +572,10 @@ func (s *service) getURIsFromTracks(tracks []Track) []string {
 	return uris
 }
 
-func (s *service) GetUserProfile(token string) (*User, error) {
+func (s *service) GetUserProfile(ctx context.Context, token string) (*User, error) {
 	spotifyURL := "https://api.spotify.com/v1/me"
 
-	res, err := s.makeRequest(token, http.MethodGet, spotifyURL, nil)
+	res, err := s.makeRequest(ctx, token, http.MethodGet, spotifyURL, nil)
 	if err != nil {
 		return nil, errors.Wrap(err, "[GetUserProfile]: unable to make request")
 	}
@@ -357,10 +589,10 @@ func (s *service) GetUserProfile(token string) (*User, error) {
 	return &user, nil
 }
 
-func (s *service) GetPlaylist(token, id string) (*Playlist, error) {
+func (s *service) GetPlaylist(ctx context.Context, token, id string) (*Playlist, error) {
 	spotifyURL := fmt.Sprintf("https://api.spotify.com/v1/playlists/%s", id)
 
-	res, err := s.makeRequest(token, http.MethodGet, spotifyURL, nil)
+	res, err := s.makeRequest(ctx, token, http.MethodGet, spotifyURL, nil)
 	if err != nil {
 		return nil, errors.Wrap(err, "[GetPlaylist]: unable to make request")
 	}
@@ -374,10 +606,11 @@ func (s *service) GetPlaylist(token, id string) (*Playlist, error) {
 	return &playlist, nil
 }
 
-func (s *service) GetTopArtists(token string, limit int) ([]Artist, error) {
-	spotifyURL := fmt.Sprintf("https://api.spotify.com/v1/me/top/artists?limit=%v&time_range=medium_term", limit)
+// GetTopArtists returns the artists the user listened to the most over timeRange, one of the TimeRange constants
+func (s *service) GetTopArtists(ctx context.Context, token, timeRange string, limit int) ([]Artist, error) {
+	spotifyURL := fmt.Sprintf("https://api.spotify.com/v1/me/top/artists?limit=%v&time_range=%s", limit, timeRange)
 
-	res, err := s.makeRequest(token, http.MethodGet, spotifyURL, nil)
+	res, err := s.makeRequest(ctx, token, http.MethodGet, spotifyURL, nil)
 	if err != nil {
 		return nil, errors.Wrap(err, "[GetUserTopArtists]: unable to make request")
 	}
@@ -394,10 +627,11 @@ func (s *service) GetTopArtists(token string, limit int) ([]Artist, error) {
 	return artists, nil
 }
 
-func (s *service) GetTopTracks(token string, limit int) ([]Track, error) {
-	spotifyURL := fmt.Sprintf("https://api.spotify.com/v1/me/top/tracks?limit=%v&time_range=short_term", limit)
+// GetTopTracks returns the tracks the user listened to the most over timeRange, one of the TimeRange constants
+func (s *service) GetTopTracks(ctx context.Context, token, timeRange string, limit int) ([]Track, error) {
+	spotifyURL := fmt.Sprintf("https://api.spotify.com/v1/me/top/tracks?limit=%v&time_range=%s", limit, timeRange)
 
-	res, err := s.makeRequest(token, http.MethodGet, spotifyURL, nil)
+	res, err := s.makeRequest(ctx, token, http.MethodGet, spotifyURL, nil)
 	if err != nil {
 		return nil, errors.Wrap(err, "[GetUserTopTracks]: unable to make request")
 	}
@@ -414,11 +648,11 @@ func (s *service) GetTopTracks(token string, limit int) ([]Track, error) {
 	return tracks, nil
 }
 
-func (s *service) GetAlbums(token string, ids []string) ([]Album, error) {
+func (s *service) GetAlbums(ctx context.Context, token string, ids []string) ([]Album, error) {
 	idsParam := strings.Join(ids, ",")
 	spotifyURL := fmt.Sprintf("https://api.spotify.com/v1/albums?ids=%s", idsParam)
 
-	res, err := s.makeRequest(token, http.MethodGet, spotifyURL, nil)
+	res, err := s.makeRequest(ctx, token, http.MethodGet, spotifyURL, nil)
 	if err != nil {
 		return nil, errors.Wrap(err, "[GetAlbums]: unable to make request")
 	}
@@ -435,10 +669,10 @@ func (s *service) GetAlbums(token string, ids []string) ([]Album, error) {
 	return albums, nil
 }
 
-func (s *service) GetAlbum(token string, id string) (*Album, error) {
+func (s *service) GetAlbum(ctx context.Context, token string, id string) (*Album, error) {
 	spotifyURL := fmt.Sprintf("https://api.spotify.com/v1/albums/%s", id)
 
-	res, err := s.makeRequest(token, http.MethodGet, spotifyURL, nil)
+	res, err := s.makeRequest(ctx, token, http.MethodGet, spotifyURL, nil)
 	if err != nil {
 		return nil, errors.Wrap(err, "[GetAlbum]: unable to make request")
 	}
@@ -452,16 +686,179 @@ func (s *service) GetAlbum(token string, id string) (*Album, error) {
 	return &album, nil
 }
 
-func (s *service) GetRandomTrack(token string) (*Track, error) {
-	seeds, err := s.GetCurrentTrackSeeds(token)
+func (s *service) GetRandomTrack(ctx context.Context, token string) (*Track, error) {
+	seeds, err := s.GetCurrentTrackSeeds(ctx, token)
 	if err != nil {
 		return nil, errors.Wrap(err, "[GetRandomTrack]: unable to get seeds")
 	}
 
-	tracks, err := s.GetTracksBasedOnSeeds(token, seeds, 1)
+	tracks, err := s.GetTracksBasedOnSeeds(ctx, token, seeds, 1)
 	if err != nil {
 		return nil, errors.Wrap(err, "[GetRandomTrack]: unable to get tracks from seeds")
 	}
 
 	return &tracks[0], nil
 }
+
+// Search looks query up in each of types, the SearchType constants, returning up to limit matches per type.
+// market is a country code or MarketFromToken, an empty market searches every market
+func (s *service) Search(ctx context.Context, token, query string, types []string, limit int, market string) (*SearchResult, error) {
+	params := url.Values{}
+	params.Add("q", query)
+	params.Add("type", strings.Join(types, ","))
+	params.Add("limit", fmt.Sprintf("%d", limit))
+	if market != "" {
+		params.Add("market", market)
+	}
+	spotifyURL := fmt.Sprintf("https://api.spotify.com/v1/search?%s", params.Encode())
+
+	res, err := s.makeRequest(ctx, token, http.MethodGet, spotifyURL, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "[Search]: unable to make request")
+	}
+
+	var body responseSearch
+	err = json.Unmarshal(res, &body)
+	if err != nil {
+		return nil, errors.Wrap(err, "[Search]: unable to unmarshal response body")
+	}
+
+	result := &SearchResult{
+		Tracks:    []Track{},
+		Artists:   []Artist{},
+		Albums:    []Album{},
+		Playlists: []Playlist{},
+	}
+	result.Tracks = append(result.Tracks, body.Tracks.Tracks...)
+	result.Artists = append(result.Artists, body.Artists.Artists...)
+	result.Albums = append(result.Albums, body.Albums.Albums...)
+	// spotify returns null in place of playlists that are no longer available
+	for _, playlist := range body.Playlists.Playlists {
+		if playlist.ID != "" {
+			result.Playlists = append(result.Playlists, playlist)
+		}
+	}
+
+	return result, nil
+}
+
+func (s *service) GetArtist(ctx context.Context, token, id string) (*Artist, error) {
+	spotifyURL := fmt.Sprintf("https://api.spotify.com/v1/artists/%s", id)
+
+	res, err := s.makeRequest(ctx, token, http.MethodGet, spotifyURL, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "[GetArtist]: unable to make request")
+	}
+
+	var artist Artist
+	err = json.Unmarshal(res, &artist)
+	if err != nil {
+		return nil, errors.Wrap(err, "[GetArtist]: unable to unmarshal response body")
+	}
+
+	return &artist, nil
+}
+
+// GetArtistTopTracks returns the most popular tracks of the artist in market, spotify requires a market here
+func (s *service) GetArtistTopTracks(ctx context.Context, token, id, market string) ([]Track, error) {
+	spotifyURL := fmt.Sprintf("https://api.spotify.com/v1/artists/%s/top-tracks?market=%s", id, market)
+
+	res, err := s.makeRequest(ctx, token, http.MethodGet, spotifyURL, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "[GetArtistTopTracks]: unable to make request")
+	}
+
+	var items Tracks
+	err = json.Unmarshal(res, &items)
+	if err != nil {
+		return nil, errors.Wrap(err, "[GetArtistTopTracks]: unable to unmarshal response body")
+	}
+
+	tracks := []Track{}
+	tracks = append(tracks, items.Items...)
+
+	return tracks, nil
+}
+
+// GetRelatedArtists returns the artists listeners of the artist also listen to, the most similar first
+func (s *service) GetRelatedArtists(ctx context.Context, token, id string) ([]Artist, error) {
+	spotifyURL := fmt.Sprintf("https://api.spotify.com/v1/artists/%s/related-artists", id)
+
+	res, err := s.makeRequest(ctx, token, http.MethodGet, spotifyURL, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "[GetRelatedArtists]: unable to make request")
+	}
+
+	var items Artists
+	err = json.Unmarshal(res, &items)
+	if err != nil {
+		return nil, errors.Wrap(err, "[GetRelatedArtists]: unable to unmarshal response body")
+	}
+
+	artists := []Artist{}
+	artists = append(artists, items.Items...)
+
+	return artists, nil
+}
+
+// GetArtistAlbums returns the albums and singles of the artist, the latest first
+func (s *service) GetArtistAlbums(ctx context.Context, token, id string, limit int, market string) ([]Album, error) {
+	spotifyURL := fmt.Sprintf("https://api.spotify.com/v1/artists/%s/albums?include_groups=album,single&limit=%d&market=%s", id, limit, market)
+
+	res, err := s.makeRequest(ctx, token, http.MethodGet, spotifyURL, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "[GetArtistAlbums]: unable to make request")
+	}
+
+	var items AlbumItems
+	err = json.Unmarshal(res, &items)
+	if err != nil {
+		return nil, errors.Wrap(err, "[GetArtistAlbums]: unable to unmarshal response body")
+	}
+
+	albums := []Album{}
+	albums = append(albums, items.Albums...)
+
+	return albums, nil
+}
+
+func (s *service) GetTrack(ctx context.Context, token, id string) (*Track, error) {
+	spotifyURL := fmt.Sprintf("https://api.spotify.com/v1/tracks/%s", id)
+
+	res, err := s.makeRequest(ctx, token, http.MethodGet, spotifyURL, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "[GetTrack]: unable to make request")
+	}
+
+	var track Track
+	err = json.Unmarshal(res, &track)
+	if err != nil {
+		return nil, errors.Wrap(err, "[GetTrack]: unable to unmarshal response body")
+	}
+
+	return &track, nil
+}
+
+// SaveTracks adds the tracks to the user's Liked Songs, saving a track twice is not an error
+func (s *service) SaveTracks(ctx context.Context, token string, ids []string) error {
+	spotifyURL := fmt.Sprintf("https://api.spotify.com/v1/me/tracks?ids=%s", strings.Join(ids, ","))
+
+	_, err := s.makeRequest(ctx, token, http.MethodPut, spotifyURL, nil)
+	if err != nil {
+		return errors.Wrap(err, "[SaveTracks]: unable to make request")
+	}
+
+	return nil
+}
+
+// FollowArtists makes the user follow the artists, following an artist twice is not an error
+func (s *service) FollowArtists(ctx context.Context, token string, ids []string) error {
+	spotifyURL := fmt.Sprintf("https://api.spotify.com/v1/me/following?type=artist&ids=%s", strings.Join(ids, ","))
+
+	_, err := s.makeRequest(ctx, token, http.MethodPut, spotifyURL, nil)
+	if err != nil {
+		return errors.Wrap(err, "[FollowArtists]: unable to make request")
+	}
+
+	return nil
+}